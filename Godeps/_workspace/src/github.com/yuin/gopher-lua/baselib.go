@@ -139,6 +139,12 @@ func ipairsaux(L *LState) int {
 
 func baseIpairs(L *LState) int {
 	tb := L.CheckTable(1)
+	if fn := L.metaOp1(tb, "__ipairs"); fn.Type() == LTFunction {
+		L.Push(fn)
+		L.Push(tb)
+		L.Call(1, 3)
+		return 3
+	}
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
 	L.Push(LNumber(0))
@@ -237,6 +243,12 @@ func pairsaux(L *LState) int {
 
 func basePairs(L *LState) int {
 	tb := L.CheckTable(1)
+	if fn := L.metaOp1(tb, "__pairs"); fn.Type() == LTFunction {
+		L.Push(fn)
+		L.Push(tb)
+		L.Call(1, 3)
+		return 3
+	}
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
 	L.Push(LNil)
@@ -0,0 +1,108 @@
+package lua
+
+/* hooks {{{ */
+
+type HookMask int
+
+const (
+	MaskCall HookMask = 1 << iota
+	MaskReturn
+	MaskLine
+	MaskCount
+)
+
+type HookEvent int
+
+const (
+	HookCall HookEvent = iota
+	HookReturn
+	HookLine
+	HookCount
+)
+
+func (ev HookEvent) String() string {
+	switch ev {
+	case HookCall:
+		return "call"
+	case HookReturn:
+		return "return"
+	case HookLine:
+		return "line"
+	case HookCount:
+		return "count"
+	}
+	return "unknown"
+}
+
+// HookFunction is invoked by the VM while executing Lua functions. The frame
+// that raised the event is available through GetStack(0).
+type HookFunction func(L *LState, event HookEvent, line int)
+
+type hookState struct {
+	fn    HookFunction
+	mask  HookMask
+	count int
+	ticks int
+}
+
+// SetHook installs fn for all threads sharing this state's globals. A nil fn
+// or an empty mask removes the current hook. The hook is read without
+// synchronisation as the VM runs, so SetHook must be called from the
+// goroutine running the state, or while it runs nothing.
+func (ls *LState) SetHook(fn HookFunction, mask HookMask, count int) {
+	if fn == nil || mask == 0 {
+		ls.G.hook = nil
+		return
+	}
+	if count <= 0 {
+		mask &^= MaskCount
+	}
+	ls.G.hook = &hookState{fn: fn, mask: mask, count: count}
+}
+
+func (ls *LState) GetHook() (HookFunction, HookMask, int) {
+	if h := ls.G.hook; h != nil {
+		return h.fn, h.mask, h.count
+	}
+	return nil, 0, 0
+}
+
+func (ls *LState) callHook(cf *callFrame, inst uint32) {
+	h := ls.G.hook
+	if ls.inHook || cf.Fn.IsG {
+		return
+	}
+	ls.inHook = true
+	defer func() { ls.inHook = false }()
+
+	line := -1
+	if pc := cf.Pc - 1; pc < len(cf.Fn.Proto.DbgSourcePositions) {
+		line = cf.Fn.Proto.DbgSourcePositions[pc]
+	}
+	if cf.Pc == 1 && cf != ls.hookFrame {
+		ls.hookLine = -1
+		if h.mask&MaskCall != 0 {
+			h.fn(ls, HookCall, line)
+		}
+	}
+	if h.mask&MaskCount != 0 {
+		h.ticks++
+		if h.ticks >= h.count {
+			h.ticks = 0
+			h.fn(ls, HookCount, line)
+		}
+	}
+	if h.mask&MaskLine != 0 && (cf != ls.hookFrame || line != ls.hookLine) {
+		h.fn(ls, HookLine, line)
+	}
+	ls.hookFrame = cf
+	ls.hookLine = line
+	if int(inst>>26) == OP_RETURN {
+		ls.hookFrame = nil
+		if h.mask&MaskReturn != 0 {
+			h.fn(ls, HookReturn, line)
+		}
+	}
+}
+
+/* }}} */
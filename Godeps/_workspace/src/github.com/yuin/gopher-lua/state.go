@@ -1391,6 +1391,8 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 			ls.reg.SetTop(base)
 		}
 		ls.stack.SetSp(sp)
+		ls.currentFrame = ls.stack.Last()
+		ls.hookFrame = nil
 	}()
 
 	ls.Call(nargs, nret)
@@ -163,6 +163,7 @@ type Global struct {
 	builtinMts map[int]LValue
 	tempFiles  []*os.File
 	gccount    int32
+	hook       *hookState
 }
 
 type LState struct {
@@ -178,6 +179,9 @@ type LState struct {
 	currentFrame *callFrame
 	wrapped      bool
 	uvcache      *Upvalue
+	inHook       bool
+	hookFrame    *callFrame
+	hookLine     int
 }
 
 func (ls *LState) String() string   { return fmt.Sprintf("thread: %p", ls) }
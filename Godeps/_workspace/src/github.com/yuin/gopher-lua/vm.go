@@ -24,6 +24,9 @@ func mainLoop(L *LState, baseframe *callFrame) {
 		cf = L.currentFrame
 		inst = cf.Fn.Proto.Code[cf.Pc]
 		cf.Pc++
+		if L.G.hook != nil {
+			L.callHook(cf, inst)
+		}
 		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
 			return
 		}
@@ -324,6 +327,15 @@ func init() {
 			case LString:
 				reg.Set(RA, LNumber(len(lv)))
 			case *LTable:
+				if lv.Metatable != LNil {
+					if op := L.metaOp1(lv, "__len"); op.Type() == LTFunction {
+						reg.Push(op)
+						reg.Push(lv)
+						L.Call(1, 1)
+						reg.Set(RA, reg.Pop())
+						break
+					}
+				}
 				reg.Set(RA, LNumber(lv.Len()))
 			default:
 				op := L.metaOp1(lv, "__len")
@@ -0,0 +1,428 @@
+package lua
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DefaultMailboxSize is the number of messages an actor's mailbox holds when
+// ActorOptions doesn't set one.
+const DefaultMailboxSize = 64
+
+var (
+	// ErrActorExists is returned when spawning an actor with an ID already in
+	// use.
+	ErrActorExists = errors.New("actor already exists")
+
+	// ErrActorStopped is returned when sending to an actor that is stopped or
+	// stopping, and when asking an actor that stops before replying.
+	ErrActorStopped = errors.New("actor stopped")
+
+	// ErrMailboxFull is returned when sending to an actor whose mailbox is
+	// full.
+	ErrMailboxFull = errors.New("actor mailbox full")
+)
+
+// ActorOptions configures an actor started with Spawn.
+type ActorOptions struct {
+	// NewEngine creates the Engines the actor runs in, NewEngine if nil.
+	NewEngine func() (*Engine, error)
+	// MailboxSize is the number of messages waiting to be received the
+	// mailbox holds, DefaultMailboxSize if zero.
+	MailboxSize int
+	// Restart runs the actor again, in a new Engine, when it fails. Messages
+	// in its mailbox are kept.
+	Restart bool
+	// MaxRestarts is the number of times the actor is restarted before it's
+	// stopped with its last error, zero means no limit.
+	MaxRestarts int
+	// RestartDelay is waited before each restart.
+	RestartDelay time.Duration
+	// OnError is called, from the actor's goroutine, with each error the actor
+	// fails with.
+	OnError func(id string, err error)
+}
+
+// ActorSystem runs actors, each in its own Engine and goroutine, and lets them
+// send messages to each other by ID.
+type ActorSystem struct {
+	mu       sync.Mutex
+	actors   map[string]*Actor
+	stopping bool
+}
+
+// Actor is a script running in its own Engine and goroutine, receiving the
+// messages sent to its mailbox. Its methods are safe for concurrent use.
+type Actor struct {
+	id       string
+	system   *ActorSystem
+	opts     ActorOptions
+	run      func(*Engine) error
+	mailbox  chan *message
+	mu       sync.Mutex
+	stopped  bool
+	stop     chan struct{}
+	done     chan struct{}
+	err      error
+	restarts int
+}
+
+// message is a message in a mailbox, holding the JSON encoding of the value
+// sent.
+type message struct {
+	data  []byte
+	from  string
+	reply chan []byte
+}
+
+// NewActorSystem returns an ActorSystem with no actors.
+func NewActorSystem() *ActorSystem {
+	return &ActorSystem{actors: make(map[string]*Actor)}
+}
+
+// Spawn starts the actor id, which runs run on its own goroutine with a new
+// Engine. run usually loads the script of the actor, which loops receiving
+// messages until receive returns nil:
+//
+//	while true do
+//	  local msg, from = receive()
+//	  if msg == nil then break end
+//	  if msg.type == "ping" then reply("pong") end
+//	  if from then send(from, {type = "seen"}) end
+//	end
+//
+// The Engine has these functions as globals:
+//
+//	receive([timeout]) -> msg, from | nil
+//	send(id, msg) -> true | false, reason
+//	reply(msg) -> true | false
+//
+// receive waits for the next message, and the ID of the actor that sent it,
+// nil for messages sent from Go. It returns nil once the actor is stopped and
+// its mailbox is empty, or when the timeout, in seconds, runs out. reply
+// answers the last message received if it was sent with Ask.
+//
+// Messages are copied between Engines, as JSON, so they can only hold values
+// that can be encoded, and tables are received as plain tables. The actor
+// stops when run returns, and when it fails, by returning an error or with a
+// panic, unless it's restarted.
+func (s *ActorSystem) Spawn(id string, run func(*Engine) error, opts ...ActorOptions) (*Actor, error) {
+	a := &Actor{
+		id:     id,
+		system: s,
+		run:    run,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if len(opts) > 0 {
+		a.opts = opts[0]
+	}
+	if a.opts.NewEngine == nil {
+		a.opts.NewEngine = func() (*Engine, error) {
+			return NewEngine(), nil
+		}
+	}
+	if a.opts.MailboxSize <= 0 {
+		a.opts.MailboxSize = DefaultMailboxSize
+	}
+	a.mailbox = make(chan *message, a.opts.MailboxSize)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return nil, ErrActorStopped
+	}
+	if _, ok := s.actors[id]; ok {
+		return nil, ErrActorExists
+	}
+	s.actors[id] = a
+	go a.loop()
+
+	return a, nil
+}
+
+// Actor returns the running actor id, nil if there is none.
+func (s *ActorSystem) Actor(id string) *Actor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.actors[id]
+}
+
+// Shutdown stops every actor and waits for them to finish, or for ctx to be
+// done. No actors can be spawned once it's called.
+func (s *ActorSystem) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopping = true
+	actors := make([]*Actor, 0, len(s.actors))
+	for _, a := range s.actors {
+		actors = append(actors, a)
+	}
+	s.mu.Unlock()
+
+	for _, a := range actors {
+		a.shutdown()
+	}
+	for _, a := range actors {
+		select {
+		case <-a.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// ID returns the ID of the actor.
+func (a *Actor) ID() string {
+	return a.id
+}
+
+// Send puts msg in the mailbox of the actor without waiting for it to be
+// received. msg is encoded as JSON, as encoding/json would, before Send
+// returns.
+func (a *Actor) Send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return a.deliver(&message{data: data})
+}
+
+// Ask sends msg to the actor and waits for it to reply, returning the reply
+// decoded as encoding/json decodes into an interface{}. It fails if ctx is
+// done, or the actor stops, before the reply comes.
+func (a *Actor) Ask(ctx context.Context, msg interface{}) (interface{}, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	reply := make(chan []byte, 1)
+	if err := a.deliver(&message{data: data, reply: reply}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case data := <-reply:
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+
+		return v, nil
+	case <-a.done:
+		return nil, ErrActorStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stop stops the actor once it has received the messages in its mailbox, and
+// waits for it to finish, or for ctx to be done. Messages sent after Stop is
+// called are refused.
+func (a *Actor) Stop(ctx context.Context) error {
+	a.shutdown()
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel closed when the actor has finished.
+func (a *Actor) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns the error the actor stopped with, nil if it's running or
+// finished without failing.
+func (a *Actor) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.err
+}
+
+// Restarts returns the number of times the actor has been restarted.
+func (a *Actor) Restarts() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.restarts
+}
+
+// deliver puts msg in the mailbox.
+func (a *Actor) deliver(msg *message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped {
+		return ErrActorStopped
+	}
+	select {
+	case a.mailbox <- msg:
+		return nil
+	default:
+		return ErrMailboxFull
+	}
+}
+
+// shutdown refuses new messages and tells the actor to stop.
+func (a *Actor) shutdown() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.stopped {
+		a.stopped = true
+		close(a.stop)
+	}
+}
+
+// loop runs the actor, restarting it as its options allow, until it stops.
+func (a *Actor) loop() {
+	defer func() {
+		a.shutdown()
+		a.system.mu.Lock()
+		if a.system.actors[a.id] == a {
+			delete(a.system.actors, a.id)
+		}
+		a.system.mu.Unlock()
+		close(a.done)
+	}()
+
+	for {
+		err := a.runOnce()
+		if err == nil {
+			return
+		}
+		if a.opts.OnError != nil {
+			a.opts.OnError(a.id, err)
+		}
+
+		a.mu.Lock()
+		restart := a.opts.Restart && !a.stopped &&
+			(a.opts.MaxRestarts == 0 || a.restarts < a.opts.MaxRestarts)
+		if restart {
+			a.restarts++
+		} else {
+			a.err = err
+		}
+		a.mu.Unlock()
+		if !restart {
+			return
+		}
+
+		if a.opts.RestartDelay > 0 {
+			select {
+			case <-time.After(a.opts.RestartDelay):
+			case <-a.stop:
+				return
+			}
+		}
+	}
+}
+
+// runOnce runs the actor in a new Engine, returning the error it fails with.
+func (a *Actor) runOnce() (err error) {
+	e, err := a.opts.NewEngine()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("actor %s: %v", a.id, r)
+		}
+	}()
+	a.open(e)
+
+	return a.run(e)
+}
+
+// open registers the functions actors use in e.
+func (a *Actor) open(e *Engine) {
+	var current *message
+
+	e.provide("receive", e.state.NewFunction(func(l *glua.LState) int {
+		var timeout <-chan time.Time
+		if l.Get(1) != glua.LNil {
+			timeout = time.After(time.Duration(float64(l.CheckNumber(1)) * float64(time.Second)))
+		}
+
+		var msg *message
+		select {
+		case msg = <-a.mailbox:
+		case <-timeout:
+		case <-a.stop:
+			select {
+			case msg = <-a.mailbox:
+			default:
+			}
+		}
+		current = msg
+		if msg == nil {
+			l.Push(glua.LNil)
+
+			return 1
+		}
+		lv, err := e.decodeJSON(msg.data)
+		if err != nil {
+			l.RaiseError("receive: %s", err)
+		}
+		l.Push(lv)
+		if msg.from == "" {
+			l.Push(glua.LNil)
+		} else {
+			l.Push(glua.LString(msg.from))
+		}
+
+		return 2
+	}))
+
+	e.provide("send", e.state.NewFunction(func(l *glua.LState) int {
+		id := l.CheckString(1)
+		data, err := e.encodeJSON(l.CheckAny(2), "")
+		if err != nil {
+			l.ArgError(2, err.Error())
+		}
+		to := a.system.Actor(id)
+		if to == nil {
+			err = fmt.Errorf("unknown actor %s", id)
+		} else {
+			err = to.deliver(&message{data: []byte(data), from: a.id})
+		}
+		if err != nil {
+			l.Push(glua.LFalse)
+			l.Push(glua.LString(err.Error()))
+
+			return 2
+		}
+		l.Push(glua.LTrue)
+
+		return 1
+	}))
+
+	e.provide("reply", e.state.NewFunction(func(l *glua.LState) int {
+		data, err := e.encodeJSON(l.Get(1), "")
+		if err != nil {
+			l.ArgError(1, err.Error())
+		}
+		if current == nil || current.reply == nil {
+			l.Push(glua.LFalse)
+
+			return 1
+		}
+		current.reply <- []byte(data)
+		current.reply = nil
+		l.Push(glua.LTrue)
+
+		return 1
+	}))
+}
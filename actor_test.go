@@ -0,0 +1,163 @@
+package lua_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Actors", func() {
+	var system *ActorSystem
+
+	BeforeEach(func() {
+		system = NewActorSystem()
+	})
+
+	AfterEach(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		Expect(system.Shutdown(ctx)).To(BeNil())
+	})
+
+	script := func(src string) func(*Engine) error {
+		return func(e *Engine) error {
+			return e.LoadString(src)
+		}
+	}
+
+	It("should answer questions asked from Go", func() {
+		_, err := system.Spawn("npc", script(`
+			local hp = 10
+			while true do
+				local msg = receive()
+				if msg == nil then break end
+				if msg.type == "hit" then hp = hp - msg.damage end
+				if msg.type == "status" then reply({hp = hp, alive = hp > 0}) end
+			end
+		`))
+		Expect(err).To(BeNil())
+
+		npc := system.Actor("npc")
+		Expect(npc.Send(map[string]interface{}{"type": "hit", "damage": 3})).To(BeNil())
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		status, err := npc.Ask(ctx, map[string]string{"type": "status"})
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(map[string]interface{}{"hp": 7.0, "alive": true}))
+	})
+
+	It("should send copies of messages between actors", func() {
+		_, err := system.Spawn("echo", script(`
+			while true do
+				local msg, from = receive()
+				if msg == nil then break end
+				msg.seen = true
+				send(from, msg)
+			end
+		`))
+		Expect(err).To(BeNil())
+		_, err = system.Spawn("guard", script(`
+			local msg = {name = "orc"}
+			ok = send("echo", msg)
+			missing, reason = send("nobody", msg)
+			local echoed
+			while true do
+				local m, from = receive()
+				if m == nil then break end
+				if from == "echo" then
+					echoed = m.seen
+				else
+					reply({original = msg.seen == nil, echoed = echoed, ok = ok, reason = reason})
+				end
+			end
+		`))
+		Expect(err).To(BeNil())
+
+		report := func() interface{} {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			got, err := system.Actor("guard").Ask(ctx, "report")
+			Expect(err).To(BeNil())
+
+			return got
+		}
+		Eventually(report).Should(Equal(map[string]interface{}{
+			"original": true,
+			"echoed":   true,
+			"ok":       true,
+			"reason":   "unknown actor nobody",
+		}))
+	})
+
+	It("should restart failing actors", func() {
+		var failures []error
+		_, err := system.Spawn("flaky", script(`
+			while true do
+				local msg = receive()
+				if msg == nil then break end
+				if msg == "crash" then error("boom") end
+				reply(msg)
+			end
+		`), ActorOptions{
+			Restart: true,
+			OnError: func(id string, err error) {
+				failures = append(failures, err)
+			},
+		})
+		Expect(err).To(BeNil())
+
+		flaky := system.Actor("flaky")
+		Expect(flaky.Send("crash")).To(BeNil())
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		got, err := flaky.Ask(ctx, "still here")
+		Expect(err).To(BeNil())
+		Expect(got).To(Equal("still here"))
+		Expect(flaky.Restarts()).To(Equal(1))
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].Error()).To(ContainSubstring("boom"))
+	})
+
+	It("should stop after too many restarts", func() {
+		a, err := system.Spawn("broken", func(*Engine) error {
+			return errors.New("cannot start")
+		}, ActorOptions{Restart: true, MaxRestarts: 2})
+		Expect(err).To(BeNil())
+		Eventually(a.Done()).Should(BeClosed())
+		Expect(a.Err()).To(MatchError("cannot start"))
+		Expect(a.Restarts()).To(Equal(2))
+		Expect(system.Actor("broken")).To(BeNil())
+	})
+
+	It("should shut down once mailboxes are empty", func() {
+		count := make(chan int, 1)
+		a, err := system.Spawn("counter", func(e *Engine) error {
+			if err := e.LoadString(`
+				n = 0
+				while receive() ~= nil do n = n + 1 end
+			`); err != nil {
+				return err
+			}
+			count <- int(e.GetGlobal("n").AsNumber())
+
+			return nil
+		})
+		Expect(err).To(BeNil())
+		for i := 0; i < 3; i++ {
+			Expect(a.Send(i)).To(BeNil())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		Expect(system.Shutdown(ctx)).To(BeNil())
+		Expect(<-count).To(Equal(3))
+		Expect(a.Send(4)).To(Equal(ErrActorStopped))
+		_, err = system.Spawn("late", script(``))
+		Expect(err).To(Equal(ErrActorStopped))
+	})
+})
@@ -0,0 +1,650 @@
+package lua
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DiagnosticKind identifies the problem a Diagnostic reports.
+type DiagnosticKind string
+
+// The kinds of problems reported by Analyze.
+const (
+	UndefinedGlobal DiagnosticKind = "undefined-global"
+	GlobalWrite     DiagnosticKind = "global-write"
+	DeniedCall      DiagnosticKind = "denied-call"
+	UnknownModule   DiagnosticKind = "unknown-module"
+	UnusedLocal     DiagnosticKind = "unused-local"
+	UnreachableCode DiagnosticKind = "unreachable-code"
+)
+
+// IsError returns true for kinds that will fail at runtime, the others are
+// only warnings.
+func (k DiagnosticKind) IsError() bool {
+	switch k {
+	case UndefinedGlobal, DeniedCall, UnknownModule:
+		return true
+	}
+
+	return false
+}
+
+// Diagnostic is a single problem found in a script.
+type Diagnostic struct {
+	Source  string
+	Line    int
+	Kind    DiagnosticKind
+	Message string
+}
+
+// String formats the diagnostic as "source:line: message (kind)".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", d.Source, d.Line, d.Message, d.Kind)
+}
+
+// AnalysisError is returned when loading a script that fails analysis on an
+// Engine with CheckOnLoad set.
+type AnalysisError []Diagnostic
+
+// Implements the Error interface for AnalysisError
+func (a AnalysisError) Error() string {
+	lines := make([]string, len(a))
+	for i, d := range a {
+		lines[i] = d.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// AnalyzeOptions configures what Analyze considers to be defined.
+type AnalyzeOptions struct {
+	// Source is the chunk name used in diagnostics.
+	Source string
+
+	// Globals are the names scripts can use without defining them. Dotted
+	// names ("string.format") restrict which fields of a global table exist,
+	// tables without dotted names are not checked.
+	Globals []string
+
+	// Denied are the (dotted) names of functions a sandbox removes.
+	Denied []string
+
+	// Modules, if not nil, are the module names require may be given.
+	Modules []string
+
+	// ReportGlobalWrites reports assignments to globals not in Globals, by
+	// default scripts may define new globals (entry points for Call).
+	ReportGlobalWrites bool
+}
+
+// analysisScope is a block of local variable declarations.
+type analysisScope struct {
+	parent *analysisScope
+	locals map[string]*analysisLocal
+}
+
+// analysisLocal tracks the use of a local variable.
+type analysisLocal struct {
+	name   string
+	line   int
+	used   bool
+	silent bool
+}
+
+// globalRead is a read of a global recorded until the whole chunk has been
+// seen, globals defined later in the chunk are known.
+type globalRead struct {
+	name string
+	line int
+}
+
+// analyzer walks the AST of a chunk collecting diagnostics.
+type analyzer struct {
+	opts    AnalyzeOptions
+	known   map[string]bool
+	members map[string]map[string]bool
+	denied  map[string]bool
+	modules map[string]bool
+	defined map[string]bool
+	reads   []globalRead
+	scope   *analysisScope
+	diags   []Diagnostic
+}
+
+// Analyze parses the Lua source and reports uses of undefined globals, calls
+// to functions denied by a sandbox, unknown modules, unused locals and
+// unreachable code. The error is only set if the source fails to parse.
+func Analyze(src string, opts AnalyzeOptions) ([]Diagnostic, error) {
+	if opts.Source == "" {
+		opts.Source = "<string>"
+	}
+	chunk, err := parse.Parse(strings.NewReader(src), opts.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &analyzer{
+		opts:    opts,
+		known:   make(map[string]bool),
+		members: make(map[string]map[string]bool),
+		denied:  make(map[string]bool),
+		defined: make(map[string]bool),
+	}
+	for _, name := range opts.Globals {
+		if i := strings.Index(name, "."); i >= 0 {
+			base := name[:i]
+			if a.members[base] == nil {
+				a.members[base] = make(map[string]bool)
+			}
+			a.members[base][name[i+1:]] = true
+			a.known[base] = true
+		} else {
+			a.known[name] = true
+		}
+	}
+	for _, name := range opts.Denied {
+		a.denied[name] = true
+	}
+	if opts.Modules != nil {
+		a.modules = make(map[string]bool)
+		for _, name := range opts.Modules {
+			a.modules[name] = true
+		}
+	}
+
+	a.block(chunk, nil)
+	for _, read := range a.reads {
+		if !a.defined[read.name] {
+			a.report(read.line, UndefinedGlobal, "undefined global %s", read.name)
+		}
+	}
+	sort.Stable(diagnosticsByLine(a.diags))
+
+	return a.diags, nil
+}
+
+// Analyze checks the source against everything available to scripts in the
+// Engine, see AnalyzeOptions.
+func (e *Engine) Analyze(src, source string) ([]Diagnostic, error) {
+	opts := e.AnalyzeOptions()
+	opts.Source = source
+
+	return Analyze(src, opts)
+}
+
+// AnalyzeOptions describes the globals and modules registered with the
+// Engine. For secure Engines the globals are those of the sandbox and
+// everything else available in the Lua state is reported as denied.
+func (e *Engine) AnalyzeOptions() AnalyzeOptions {
+	globals := e.state.G.Global
+	env := globals
+	if e.Secure {
+		if tbl, ok := e.state.GetGlobal(e.sandbox.EnvName).(*glua.LTable); ok {
+			env = tbl
+		}
+	}
+
+	var opts AnalyzeOptions
+	allowed := tableNames(env)
+	for name := range allowed {
+		opts.Globals = append(opts.Globals, name)
+	}
+	if env != globals {
+		for name := range tableNames(globals) {
+			if !allowed[name] && name != e.sandbox.EnvName {
+				opts.Denied = append(opts.Denied, name)
+			}
+		}
+	}
+	sort.Strings(opts.Globals)
+	sort.Strings(opts.Denied)
+
+	if pkg, ok := e.state.GetGlobal("package").(*glua.LTable); ok {
+		opts.Modules = []string{}
+		for _, field := range []string{"preload", "loaded"} {
+			if tbl, ok := pkg.RawGetH(glua.LString(field)).(*glua.LTable); ok {
+				for name := range tableNames(tbl) {
+					if !strings.Contains(name, ".") {
+						opts.Modules = append(opts.Modules, name)
+					}
+				}
+			}
+		}
+		sort.Strings(opts.Modules)
+	}
+
+	return opts
+}
+
+// tableNames returns the string keys of the table and, for values that are
+// tables, the dotted names of their string keys.
+func tableNames(tbl *glua.LTable) map[string]bool {
+	names := make(map[string]bool)
+	tbl.ForEach(func(key, val glua.LValue) {
+		name, ok := key.(glua.LString)
+		if !ok {
+			return
+		}
+		names[string(name)] = true
+		if sub, ok := val.(*glua.LTable); ok && sub != tbl {
+			sub.ForEach(func(key, _ glua.LValue) {
+				if member, ok := key.(glua.LString); ok {
+					names[string(name)+"."+string(member)] = true
+				}
+			})
+		}
+	})
+
+	return names
+}
+
+// report records a diagnostic.
+func (a *analyzer) report(line int, kind DiagnosticKind, format string, args ...interface{}) {
+	a.diags = append(a.diags, Diagnostic{
+		Source:  a.opts.Source,
+		Line:    line,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// open starts a new scope.
+func (a *analyzer) open() {
+	a.scope = &analysisScope{parent: a.scope, locals: make(map[string]*analysisLocal)}
+}
+
+// close ends the current scope reporting locals that were never read.
+func (a *analyzer) close() {
+	var unused []*analysisLocal
+	for _, local := range a.scope.locals {
+		if !local.used && !local.silent && !strings.HasPrefix(local.name, "_") {
+			unused = append(unused, local)
+		}
+	}
+	sort.Sort(localsByLine(unused))
+	for _, local := range unused {
+		a.report(local.line, UnusedLocal, "local %s is never used", local.name)
+	}
+	a.scope = a.scope.parent
+}
+
+// declare adds a local to the current scope, silent locals (parameters and
+// loop variables) are never reported as unused.
+func (a *analyzer) declare(name string, line int, silent bool) {
+	a.scope.locals[name] = &analysisLocal{name: name, line: line, silent: silent}
+}
+
+// resolve finds the local variable with the given name.
+func (a *analyzer) resolve(name string) *analysisLocal {
+	for s := a.scope; s != nil; s = s.parent {
+		if local, ok := s.locals[name]; ok {
+			return local
+		}
+	}
+
+	return nil
+}
+
+// block analyzes a list of statements in a new scope, params are declared in
+// it first.
+func (a *analyzer) block(stmts []ast.Stmt, params []string) {
+	a.open()
+	for _, name := range params {
+		a.declare(name, 0, true)
+	}
+	a.stmts(stmts)
+	a.close()
+}
+
+// stmts analyzes statements in the current scope, reporting the first
+// statement following one that always leaves the block.
+func (a *analyzer) stmts(stmts []ast.Stmt) {
+	for i, stmt := range stmts {
+		a.stmt(stmt)
+		if exits(stmt) && i+1 < len(stmts) {
+			a.report(stmts[i+1].Line(), UnreachableCode, "unreachable code")
+			for _, rest := range stmts[i+1:] {
+				a.stmt(rest)
+			}
+
+			return
+		}
+	}
+}
+
+// stmt analyzes a single statement.
+func (a *analyzer) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		a.exprs(s.Rhs)
+		for _, lhs := range s.Lhs {
+			a.assign(lhs)
+		}
+	case *ast.LocalAssignStmt:
+		// "local function f" is parsed as "local f = function", the name is
+		// visible inside the function so it can recurse
+		if fn, ok := localFunction(s); ok {
+			a.declare(s.Names[0], s.Line(), false)
+			a.function(fn, nil)
+
+			return
+		}
+		a.exprs(s.Exprs)
+		for _, name := range s.Names {
+			a.declare(name, s.Line(), false)
+		}
+	case *ast.FuncCallStmt:
+		a.expr(s.Expr)
+	case *ast.DoBlockStmt:
+		a.block(s.Stmts, nil)
+	case *ast.WhileStmt:
+		a.expr(s.Condition)
+		a.block(s.Stmts, nil)
+	case *ast.RepeatStmt:
+		// the condition can see the locals of the body
+		a.open()
+		a.stmts(s.Stmts)
+		a.expr(s.Condition)
+		a.close()
+	case *ast.IfStmt:
+		a.expr(s.Condition)
+		a.block(s.Then, nil)
+		a.block(s.Else, nil)
+	case *ast.NumberForStmt:
+		a.expr(s.Init)
+		a.expr(s.Limit)
+		if s.Step != nil {
+			a.expr(s.Step)
+		}
+		a.loop([]string{s.Name}, s.Line(), s.Stmts)
+	case *ast.GenericForStmt:
+		a.exprs(s.Exprs)
+		a.loop(s.Names, s.Line(), s.Stmts)
+	case *ast.FuncDefStmt:
+		var params []string
+		if s.Name.Func != nil {
+			a.assign(s.Name.Func)
+		} else {
+			a.expr(s.Name.Receiver)
+			params = []string{"self"}
+		}
+		a.function(s.Func, params)
+	case *ast.ReturnStmt:
+		a.exprs(s.Exprs)
+	}
+}
+
+// loop analyzes the body of a for loop with its control variables.
+func (a *analyzer) loop(names []string, line int, stmts []ast.Stmt) {
+	a.open()
+	for _, name := range names {
+		a.declare(name, line, true)
+	}
+	a.block(stmts, nil)
+	a.close()
+}
+
+// function analyzes the body of a function.
+func (a *analyzer) function(fn *ast.FunctionExpr, params []string) {
+	params = append(params, fn.ParList.Names...)
+	a.block(fn.Stmts, params)
+}
+
+// assign analyzes the target of an assignment.
+func (a *analyzer) assign(target ast.Expr) {
+	switch t := target.(type) {
+	case *ast.IdentExpr:
+		if a.resolve(t.Value) != nil {
+			return
+		}
+		a.defined[t.Value] = true
+		if a.opts.ReportGlobalWrites && !a.known[t.Value] {
+			a.report(t.Line(), GlobalWrite, "assignment to undeclared global %s", t.Value)
+		}
+	case *ast.AttrGetExpr:
+		// assigning fields of a global table only reads the table
+		if ident, ok := t.Object.(*ast.IdentExpr); ok {
+			a.ident(ident)
+		} else {
+			a.expr(t.Object)
+		}
+		a.expr(t.Key)
+	default:
+		a.expr(target)
+	}
+}
+
+// exprs analyzes a list of expressions.
+func (a *analyzer) exprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		a.expr(expr)
+	}
+}
+
+// expr analyzes a single expression.
+func (a *analyzer) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		a.ident(e)
+	case *ast.AttrGetExpr:
+		if a.qualified(e) {
+			return
+		}
+		a.expr(e.Object)
+		a.expr(e.Key)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			if field.Key != nil {
+				a.expr(field.Key)
+			}
+			a.expr(field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if e.Func != nil {
+			a.expr(e.Func)
+			a.require(e)
+		} else {
+			a.expr(e.Receiver)
+		}
+		a.exprs(e.Args)
+	case *ast.LogicalOpExpr:
+		a.expr(e.Lhs)
+		a.expr(e.Rhs)
+	case *ast.RelationalOpExpr:
+		a.expr(e.Lhs)
+		a.expr(e.Rhs)
+	case *ast.StringConcatOpExpr:
+		a.expr(e.Lhs)
+		a.expr(e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		a.expr(e.Lhs)
+		a.expr(e.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		a.expr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		a.expr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		a.expr(e.Expr)
+	case *ast.FunctionExpr:
+		a.function(e, nil)
+	}
+}
+
+// ident analyzes the read of a name.
+func (a *analyzer) ident(ident *ast.IdentExpr) {
+	if local := a.resolve(ident.Value); local != nil {
+		local.used = true
+
+		return
+	}
+	if a.denied[ident.Value] {
+		a.report(ident.Line(), DeniedCall, "%s is denied by the sandbox", ident.Value)
+
+		return
+	}
+	if !a.known[ident.Value] {
+		a.reads = append(a.reads, globalRead{name: ident.Value, line: ident.Line()})
+	}
+}
+
+// qualified checks reads of fields of global tables ("os.execute"), it
+// returns true if the expression was fully handled.
+func (a *analyzer) qualified(expr *ast.AttrGetExpr) bool {
+	ident, ok := expr.Object.(*ast.IdentExpr)
+	if !ok {
+		return false
+	}
+	key, ok := expr.Key.(*ast.StringExpr)
+	if !ok || a.resolve(ident.Value) != nil {
+		return false
+	}
+
+	name := ident.Value + "." + key.Value
+	switch {
+	case a.denied[name]:
+		a.report(expr.Line(), DeniedCall, "%s is denied by the sandbox", name)
+	case a.members[ident.Value] != nil && !a.members[ident.Value][key.Value] && a.known[ident.Value]:
+		a.report(expr.Line(), UndefinedGlobal, "undefined field %s", name)
+	default:
+		a.ident(ident)
+	}
+
+	return true
+}
+
+// require checks calls of require with a constant module name.
+func (a *analyzer) require(call *ast.FuncCallExpr) {
+	ident, ok := call.Func.(*ast.IdentExpr)
+	if !ok || ident.Value != "require" || a.modules == nil || len(call.Args) != 1 {
+		return
+	}
+	if a.resolve("require") != nil {
+		return
+	}
+	if name, ok := call.Args[0].(*ast.StringExpr); ok && !a.modules[name.Value] {
+		a.report(call.Line(), UnknownModule, "unknown module %q", name.Value)
+	}
+}
+
+// localFunction returns the function of a "local function" statement.
+func localFunction(s *ast.LocalAssignStmt) (*ast.FunctionExpr, bool) {
+	if len(s.Names) != 1 || len(s.Exprs) != 1 {
+		return nil, false
+	}
+	fn, ok := s.Exprs[0].(*ast.FunctionExpr)
+
+	return fn, ok
+}
+
+// exits returns true if control never continues past the statement.
+func exits(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt:
+		return true
+	case *ast.FuncCallStmt:
+		call, ok := s.Expr.(*ast.FuncCallExpr)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Func.(*ast.IdentExpr)
+
+		return ok && ident.Value == "error"
+	case *ast.DoBlockStmt:
+		return blockExits(s.Stmts)
+	case *ast.IfStmt:
+		return len(s.Else) > 0 && blockExits(s.Then) && blockExits(s.Else)
+	case *ast.WhileStmt:
+		_, infinite := s.Condition.(*ast.TrueExpr)
+
+		return infinite && !breaks(s.Stmts)
+	case *ast.RepeatStmt:
+		_, infinite := s.Condition.(*ast.FalseExpr)
+
+		return infinite && !breaks(s.Stmts)
+	}
+
+	return false
+}
+
+// blockExits returns true if any statement in the block exits.
+func blockExits(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if exits(stmt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// breaks returns true if the statements contain a break leaving the loop they
+// belong to.
+func breaks(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.BreakStmt:
+			return true
+		case *ast.DoBlockStmt:
+			if breaks(s.Stmts) {
+				return true
+			}
+		case *ast.IfStmt:
+			if breaks(s.Then) || breaks(s.Else) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkSource runs the load time analysis of the Engine returning an
+// AnalysisError if any errors are found.
+func (e *Engine) checkSource(src, source string) error {
+	diags, err := e.Analyze(src, source)
+	if err != nil {
+		return err
+	}
+
+	var errs AnalysisError
+	for _, d := range diags {
+		if d.Kind.IsError() {
+			errs = append(errs, d)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// checkFile runs the load time analysis on the contents of a file.
+func (e *Engine) checkFile(fn string) error {
+	src, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	return e.checkSource(string(src), fn)
+}
+
+// diagnosticsByLine sorts diagnostics by line.
+type diagnosticsByLine []Diagnostic
+
+func (d diagnosticsByLine) Len() int           { return len(d) }
+func (d diagnosticsByLine) Less(i, j int) bool { return d[i].Line < d[j].Line }
+func (d diagnosticsByLine) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// localsByLine sorts locals by the line they're declared on.
+type localsByLine []*analysisLocal
+
+func (l localsByLine) Len() int           { return len(l) }
+func (l localsByLine) Less(i, j int) bool { return l[i].line < l[j].line }
+func (l localsByLine) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,154 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Analyze", func() {
+	kinds := func(diags []Diagnostic) []DiagnosticKind {
+		out := make([]DiagnosticKind, len(diags))
+		for i, d := range diags {
+			out[i] = d.Kind
+		}
+
+		return out
+	}
+
+	It("should report syntax errors", func() {
+		_, err := Analyze("function (", AnalyzeOptions{})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should report undefined globals with their line", func() {
+		diags, err := Analyze("local x = 1\nprint(x)\nprnt(x)", AnalyzeOptions{
+			Source:  "test.lua",
+			Globals: []string{"print"},
+		})
+		Expect(err).To(BeNil())
+		Expect(diags).To(Equal([]Diagnostic{{
+			Source:  "test.lua",
+			Line:    3,
+			Kind:    UndefinedGlobal,
+			Message: "undefined global prnt",
+		}}))
+	})
+
+	It("should know globals defined later in the script", func() {
+		diags, err := Analyze(`
+			function a() return b() end
+			function b() return 1 end
+		`, AnalyzeOptions{})
+		Expect(err).To(BeNil())
+		Expect(diags).To(BeEmpty())
+	})
+
+	It("should report global writes when asked", func() {
+		diags, _ := Analyze("x = 1", AnalyzeOptions{ReportGlobalWrites: true})
+		Expect(kinds(diags)).To(Equal([]DiagnosticKind{GlobalWrite}))
+	})
+
+	It("should report unused locals", func() {
+		diags, _ := Analyze(`
+			local used, unused = 1, 2
+			local _ignored = 3
+			local function f(param)
+				return used
+			end
+			f()
+		`, AnalyzeOptions{})
+		Expect(diags).To(HaveLen(1))
+		Expect(diags[0].Kind).To(Equal(UnusedLocal))
+		Expect(diags[0].Message).To(Equal("local unused is never used"))
+	})
+
+	It("should report unreachable code", func() {
+		diags, _ := Analyze(`
+			local function f(x)
+				if x then
+					return 1
+				else
+					error("no")
+				end
+				return 2
+			end
+			f()
+		`, AnalyzeOptions{Globals: []string{"error"}})
+		Expect(kinds(diags)).To(Equal([]DiagnosticKind{UnreachableCode}))
+		Expect(diags[0].Line).To(Equal(8))
+	})
+
+	It("should report calls to denied functions and unknown fields", func() {
+		diags, _ := Analyze(`
+			os.execute("rm -rf /")
+			string.fromat("%d", 1)
+			string.format("%d", 1)
+		`, AnalyzeOptions{
+			Globals: []string{"os", "os.time", "string", "string.format"},
+			Denied:  []string{"os.execute"},
+		})
+		Expect(kinds(diags)).To(Equal([]DiagnosticKind{DeniedCall, UndefinedGlobal}))
+	})
+
+	It("should report unknown modules", func() {
+		diags, _ := Analyze(`local m = require("nope") m.x()`, AnalyzeOptions{
+			Globals: []string{"require"},
+			Modules: []string{"yes"},
+		})
+		Expect(kinds(diags)).To(Equal([]DiagnosticKind{UnknownModule}))
+	})
+
+	Context("with a secure engine", func() {
+		var engine *Engine
+
+		BeforeEach(func() {
+			var err error
+			engine, err = NewSecureEngine()
+			Expect(err).To(BeNil())
+			engine.RegisterFunc("greet", func(string) {})
+			engine.RegisterModule("world", map[string]interface{}{})
+			engine.CheckOnLoad = true
+		})
+
+		AfterEach(func() {
+			engine.Close()
+		})
+
+		It("should use the sandbox as the known globals", func() {
+			diags, err := engine.Analyze(`
+				local w = require("world")
+				function f()
+					greet(tostring(w))
+					return loadstring("x")
+				end
+			`, "npc.lua")
+			Expect(err).To(BeNil())
+			Expect(diags).To(HaveLen(2))
+			Expect(kinds(diags)).To(Equal([]DiagnosticKind{DeniedCall, DeniedCall}))
+			Expect(diags[0].Message).To(Equal("greet is denied by the sandbox"))
+			Expect(diags[1].Message).To(Equal("loadstring is denied by the sandbox"))
+		})
+
+		It("should know functions exposed in the sandbox", func() {
+			engine.ExposeRegistered = true
+			engine.RegisterFunc("wave", func(string) {})
+			diags, err := engine.Analyze(`function f() wave("hi") end`, "npc.lua")
+			Expect(err).To(BeNil())
+			Expect(diags).To(BeEmpty())
+		})
+
+		It("should refuse to load scripts with errors", func() {
+			err := engine.LoadString("function f() os.exit(1) end")
+			Expect(err).To(BeAssignableToTypeOf(AnalysisError{}))
+			Expect(engine.GetGlobal("f").IsNil()).To(BeTrue())
+		})
+
+		It("should load scripts that pass", func() {
+			Expect(engine.LoadString("function f() return os.time() end")).To(BeNil())
+			_, err := engine.Call("f", 1)
+			Expect(err).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,538 @@
+package lua
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// API describes what has been registered with an Engine, see DescribeAPI.
+type API struct {
+	Functions []*APIFunction
+	Modules   []*APIModule
+	Types     []*APIType
+	Enums     []*APIEnum
+	Globals   []*APIValue
+}
+
+// APIFunction describes a Go function callable from Lua.
+type APIFunction struct {
+	Name string
+	// Signature is the Go signature of the function, without the receiver of
+	// methods. It's empty if the function isn't known.
+	Signature string
+	Doc       string
+
+	params   []string
+	results  []string
+	variadic bool
+}
+
+// APIModule describes a module registered with RegisterModule.
+type APIModule struct {
+	Name      string
+	Doc       string
+	Functions []*APIFunction
+	Fields    []*APIValue
+}
+
+// APIType describes a type registered with RegisterType or RegisterClass.
+type APIType struct {
+	Name   string
+	GoType string
+	Doc    string
+	// Class is set for types registered with RegisterClass, which are built
+	// with Constructor.
+	Class       bool
+	Constructor *APIFunction
+	Fields      []*APIValue
+	Methods     []*APIFunction
+}
+
+// APIEnum describes an enum registered with RegisterEnum.
+type APIEnum struct {
+	Name   string
+	Doc    string
+	Values map[string]int64
+}
+
+// APIValue describes a global, or a field of a module or type.
+type APIValue struct {
+	Name string
+	// Type is the Go type of the value.
+	Type string
+	Doc  string
+
+	luaType string
+}
+
+// registration records something registered with the Engine for DescribeAPI.
+type registration struct {
+	kind   string
+	name   string
+	typ    reflect.Type
+	ctor   reflect.Type
+	fields map[string]reflect.Type
+	values map[string]int64
+}
+
+// SetDoc sets the documentation DescribeAPI gives for name. Fields and methods
+// of modules and types are named like "module.field" and "Type.method".
+func (e *Engine) SetDoc(name, doc string) {
+	e.root().docs[name] = doc
+}
+
+// record adds r to the registrations, replacing an earlier registration of
+// the same name. Modules are kept apart from globals.
+func (e *Engine) record(r *registration) {
+	e = e.root()
+	key := r.name
+	if r.kind == "module" {
+		key = "module " + key
+	}
+	if e.registered == nil {
+		e.registered = make(map[string]int)
+	}
+	if i, ok := e.registered[key]; ok {
+		e.registrations[i] = r
+
+		return
+	}
+	e.registered[key] = len(e.registrations)
+	e.registrations = append(e.registrations, r)
+}
+
+// luaFuncType returns the Go type of the Lua function lv if it was created by
+// a Func function, or the type of fn otherwise.
+func (e *Engine) luaFuncType(fn interface{}, lv glua.LValue) reflect.Type {
+	if lf, ok := lv.(*glua.LFunction); ok {
+		if t, ok := e.root().funcTypes[lf]; ok {
+			return t
+		}
+	}
+	if v, ok := fn.(*Value); ok {
+		return reflect.TypeOf(v.lval)
+	}
+
+	return reflect.TypeOf(fn)
+}
+
+// DescribeAPI describes the functions, modules, types, enums and globals
+// registered with the Engine, in the order they were registered.
+func (e *Engine) DescribeAPI() *API {
+	e = e.root()
+	api := &API{}
+	for _, r := range e.registrations {
+		switch r.kind {
+		case "function":
+			api.Functions = append(api.Functions, e.describeFunc(r.name, r.name, r.typ, false))
+		case "module":
+			m := &APIModule{Name: r.name, Doc: e.docs[r.name]}
+			names := make([]string, 0, len(r.fields))
+			for name := range r.fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				t := r.fields[name]
+				if t != nil && (t.Kind() == reflect.Func || t == reflect.TypeOf(&glua.LFunction{})) {
+					m.Functions = append(m.Functions, e.describeFunc(name, r.name+"."+name, t, false))
+				} else {
+					m.Fields = append(m.Fields, e.describeValue(name, r.name+"."+name, t))
+				}
+			}
+			api.Modules = append(api.Modules, m)
+		case "type", "class":
+			api.Types = append(api.Types, e.describeType(r))
+		case "enum":
+			api.Enums = append(api.Enums, &APIEnum{Name: r.name, Doc: e.docs[r.name], Values: r.values})
+		case "global":
+			api.Globals = append(api.Globals, e.describeValue(r.name, r.name, r.typ))
+		}
+	}
+
+	return api
+}
+
+// describeType describes the registered type r.
+func (e *Engine) describeType(r *registration) *APIType {
+	t := r.typ
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	typ := &APIType{Name: r.name, GoType: t.String(), Doc: e.docs[r.name], Class: r.kind == "class"}
+	if typ.Class {
+		typ.Constructor = e.describeFunc("new", r.name+".new", r.ctor, false)
+		if r.ctor == nil {
+			typ.Constructor.results = []string{r.name}
+		}
+	}
+
+	info := e.types[t]
+	if info == nil {
+		return typ
+	}
+	seen := make(map[string]bool)
+	for _, name := range info.members {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		doc := r.name + "." + name
+		if goName, ok := info.methods[name]; ok {
+			m, _ := reflect.PtrTo(t).MethodByName(goName)
+			if e.docs[doc] == "" {
+				doc = r.name + "." + goName
+			}
+			typ.Methods = append(typ.Methods, e.describeFunc(name, doc, m.Type, true))
+		} else if prop, ok := info.props[name]; ok {
+			var pt reflect.Type
+			if prop.get != "" {
+				m, _ := reflect.PtrTo(t).MethodByName(prop.get)
+				pt = m.Type.Out(0)
+			} else {
+				m, _ := reflect.PtrTo(t).MethodByName(prop.set)
+				pt = m.Type.In(1)
+			}
+			typ.Fields = append(typ.Fields, e.describeValue(name, doc, pt))
+		} else if f, ok := info.fields[name]; ok {
+			typ.Fields = append(typ.Fields, e.describeValue(name, doc, t.FieldByIndex(f.index).Type))
+		}
+	}
+
+	return typ
+}
+
+// describeFunc describes the function name of Go type t, documented as doc.
+// The first parameter of methods is the receiver.
+func (e *Engine) describeFunc(name, doc string, t reflect.Type, method bool) *APIFunction {
+	fn := &APIFunction{Name: name, Doc: e.docs[doc]}
+	if t == nil || t.Kind() != reflect.Func {
+		fn.variadic = true
+
+		return fn
+	}
+
+	var in, out []string
+	start := 0
+	if method {
+		start = 1
+	}
+	for i := start; i < t.NumIn(); i++ {
+		p := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = append(in, "..."+p.Elem().String())
+			fn.variadic = true
+			fn.params = append(fn.params, e.luaTypeOf(p.Elem()))
+			continue
+		}
+		in = append(in, p.String())
+		fn.params = append(fn.params, e.luaTypeOf(p))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		out = append(out, t.Out(i).String())
+		if t.Out(i) != errorType {
+			fn.results = append(fn.results, e.luaTypeOf(t.Out(i)))
+		}
+	}
+	if t == reflect.TypeOf(ScriptFunction(nil)) || t == reflect.TypeOf(func(*Engine) int { return 0 }) {
+		// script functions take and return whatever they pop and push
+		fn.params, fn.results, fn.variadic = []string{"any"}, nil, true
+	}
+
+	fn.Signature = "func(" + strings.Join(in, ", ") + ")"
+	switch len(out) {
+	case 0:
+	case 1:
+		fn.Signature += " " + out[0]
+	default:
+		fn.Signature += " (" + strings.Join(out, ", ") + ")"
+	}
+
+	return fn
+}
+
+// describeValue describes the value name of Go type t, documented as doc.
+func (e *Engine) describeValue(name, doc string, t reflect.Type) *APIValue {
+	v := &APIValue{Name: name, Doc: e.docs[doc], luaType: "any"}
+	if t != nil {
+		v.Type = t.String()
+		v.luaType = e.luaTypeOf(t)
+	}
+
+	return v
+}
+
+// luaTypeOf returns the EmmyLua name of the Go type t.
+func (e *Engine) luaTypeOf(t reflect.Type) string {
+	if en, ok := e.enums[t]; ok {
+		return en.name
+	}
+	if info := e.types[t]; info != nil {
+		return info.name
+	}
+	if t.Kind() == reflect.Ptr && e.types[t.Elem()] != nil {
+		return e.types[t.Elem()].name
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return e.luaTypeOf(t.Elem()) + "[]"
+	case reflect.Map:
+		return fmt.Sprintf("table<%s, %s>", e.luaTypeOf(t.Key()), e.luaTypeOf(t.Elem()))
+	case reflect.Func:
+		return "function"
+	case reflect.Ptr:
+		if t == reflect.TypeOf(&glua.LTable{}) {
+			return "table"
+		}
+		if t == reflect.TypeOf(&glua.LFunction{}) {
+			return "function"
+		}
+	}
+	if isIntegerKind(t.Kind()) {
+		return "integer"
+	}
+	switch t {
+	case reflect.TypeOf(glua.LNumber(0)):
+		return "number"
+	case reflect.TypeOf(glua.LString("")):
+		return "string"
+	case reflect.TypeOf(glua.LBool(false)):
+		return "boolean"
+	}
+
+	return "any"
+}
+
+// Markdown returns the API as a Markdown document.
+func (api *API) Markdown() string {
+	var buf bytes.Buffer
+	buf.WriteString("# Lua API\n")
+
+	if len(api.Functions) > 0 {
+		buf.WriteString("\n## Functions\n")
+		for _, fn := range api.Functions {
+			writeMarkdownFunc(&buf, "###", fn.Name, fn)
+		}
+	}
+
+	if len(api.Modules) > 0 {
+		buf.WriteString("\n## Modules\n")
+		for _, m := range api.Modules {
+			fmt.Fprintf(&buf, "\n### %s\n", m.Name)
+			writeMarkdownDoc(&buf, m.Doc)
+			writeMarkdownValues(&buf, m.Fields)
+			for _, fn := range m.Functions {
+				writeMarkdownFunc(&buf, "####", m.Name+"."+fn.Name, fn)
+			}
+		}
+	}
+
+	if len(api.Types) > 0 {
+		buf.WriteString("\n## Types\n")
+		for _, t := range api.Types {
+			fmt.Fprintf(&buf, "\n### %s\n\nGo type `%s`", t.Name, t.GoType)
+			if t.Class {
+				fmt.Fprintf(&buf, ", created with `%s.new`", t.Name)
+			}
+			buf.WriteString(".\n")
+			writeMarkdownDoc(&buf, t.Doc)
+			writeMarkdownValues(&buf, t.Fields)
+			for _, fn := range t.Methods {
+				writeMarkdownFunc(&buf, "####", t.Name+":"+fn.Name, fn)
+			}
+		}
+	}
+
+	if len(api.Enums) > 0 {
+		buf.WriteString("\n## Enums\n")
+		for _, en := range api.Enums {
+			fmt.Fprintf(&buf, "\n### %s\n", en.Name)
+			writeMarkdownDoc(&buf, en.Doc)
+			buf.WriteString("\n| Name | Value |\n| --- | --- |\n")
+			for _, name := range enumNames(en) {
+				fmt.Fprintf(&buf, "| `%s` | %d |\n", name, en.Values[name])
+			}
+		}
+	}
+
+	if len(api.Globals) > 0 {
+		buf.WriteString("\n## Globals\n")
+		writeMarkdownValues(&buf, api.Globals)
+	}
+
+	return buf.String()
+}
+
+// writeMarkdownFunc writes the section for the function fn called as name.
+func writeMarkdownFunc(buf *bytes.Buffer, heading, name string, fn *APIFunction) {
+	fmt.Fprintf(buf, "\n%s %s\n", heading, name)
+	if fn.Signature != "" {
+		fmt.Fprintf(buf, "\n`%s`\n", fn.Signature)
+	}
+	writeMarkdownDoc(buf, fn.Doc)
+}
+
+// writeMarkdownValues writes a table of values, if there are any.
+func writeMarkdownValues(buf *bytes.Buffer, values []*APIValue) {
+	if len(values) == 0 {
+		return
+	}
+	buf.WriteString("\n| Name | Type | Description |\n| --- | --- | --- |\n")
+	for _, v := range values {
+		doc := strings.Replace(v.Doc, "\n", " ", -1)
+		fmt.Fprintf(buf, "| `%s` | `%s` | %s |\n", v.Name, v.Type, doc)
+	}
+}
+
+// writeMarkdownDoc writes doc as a paragraph, if there is one.
+func writeMarkdownDoc(buf *bytes.Buffer, doc string) {
+	if doc != "" {
+		fmt.Fprintf(buf, "\n%s\n", strings.TrimSpace(doc))
+	}
+}
+
+// Stubs returns the API as a Lua file of EmmyLua annotations understood by
+// editors using LuaLS. Modules are only reachable through require, so they're
+// left to ModuleStubs.
+func (api *API) Stubs() string {
+	var buf bytes.Buffer
+	buf.WriteString("---@meta\n")
+
+	for _, t := range api.Types {
+		buf.WriteString("\n")
+		writeStubDoc(&buf, t.Doc)
+		fmt.Fprintf(&buf, "---@class %s\n", t.Name)
+		for _, f := range t.Fields {
+			writeStubField(&buf, f)
+		}
+		fmt.Fprintf(&buf, "%s = {}\n", t.Name)
+		if t.Constructor != nil {
+			writeStubFunc(&buf, t.Name+".new", t.Constructor)
+		}
+		for _, fn := range t.Methods {
+			writeStubFunc(&buf, t.Name+":"+fn.Name, fn)
+		}
+	}
+
+	for _, en := range api.Enums {
+		buf.WriteString("\n")
+		writeStubDoc(&buf, en.Doc)
+		fmt.Fprintf(&buf, "---@enum %s\n%s = {\n", en.Name, en.Name)
+		for _, name := range enumNames(en) {
+			fmt.Fprintf(&buf, "  %s = %d,\n", name, en.Values[name])
+		}
+		buf.WriteString("}\n")
+	}
+
+	for _, fn := range api.Functions {
+		writeStubFunc(&buf, fn.Name, fn)
+	}
+
+	if len(api.Globals) > 0 {
+		buf.WriteString("\n")
+	}
+	for _, v := range api.Globals {
+		writeStubDoc(&buf, v.Doc)
+		fmt.Fprintf(&buf, "---@type %s\n%s = nil\n", v.luaType, v.Name)
+	}
+
+	return buf.String()
+}
+
+// ModuleStubs returns a stub file for each module, by module name. The stubs
+// declare the table require returns, so they should be saved where LuaLS
+// looks for the module, like world/items.lua for "world.items".
+func (api *API) ModuleStubs() map[string]string {
+	stubs := make(map[string]string, len(api.Modules))
+	for _, m := range api.Modules {
+		local := m.Name
+		if !isLuaName(local) {
+			local = "M"
+		}
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "---@meta %s\n\n", m.Name)
+		writeStubDoc(&buf, m.Doc)
+		fmt.Fprintf(&buf, "---@class %s\n", m.Name)
+		for _, f := range m.Fields {
+			writeStubField(&buf, f)
+		}
+		fmt.Fprintf(&buf, "local %s = {}\n", local)
+		for _, fn := range m.Functions {
+			writeStubFunc(&buf, local+"."+fn.Name, fn)
+		}
+		fmt.Fprintf(&buf, "\nreturn %s\n", local)
+		stubs[m.Name] = buf.String()
+	}
+
+	return stubs
+}
+
+// writeStubFunc writes the annotated stub of fn as the Lua function name.
+func writeStubFunc(buf *bytes.Buffer, name string, fn *APIFunction) {
+	buf.WriteString("\n")
+	writeStubDoc(buf, fn.Doc)
+	var params []string
+	for i, p := range fn.params {
+		param := fmt.Sprintf("arg%d", i+1)
+		if fn.variadic && i == len(fn.params)-1 {
+			param = "..."
+		}
+		fmt.Fprintf(buf, "---@param %s %s\n", param, p)
+		params = append(params, param)
+	}
+	if fn.variadic && len(fn.params) == 0 {
+		params = append(params, "...")
+	}
+	for _, r := range fn.results {
+		fmt.Fprintf(buf, "---@return %s\n", r)
+	}
+	fmt.Fprintf(buf, "function %s(%s) end\n", name, strings.Join(params, ", "))
+}
+
+// writeStubField writes the field annotation for v.
+func writeStubField(buf *bytes.Buffer, v *APIValue) {
+	fmt.Fprintf(buf, "---@field %s %s", v.Name, v.luaType)
+	if v.Doc != "" {
+		fmt.Fprintf(buf, " %s", strings.Replace(v.Doc, "\n", " ", -1))
+	}
+	buf.WriteString("\n")
+}
+
+// writeStubDoc writes doc as annotation comments.
+func writeStubDoc(buf *bytes.Buffer, doc string) {
+	doc = strings.TrimSpace(doc)
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		fmt.Fprintf(buf, "---%s\n", line)
+	}
+}
+
+// enumNames returns the names of en sorted by value, then by name.
+func enumNames(en *APIEnum) []string {
+	names := make([]string, 0, len(en.Values))
+	for name := range en.Values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := en.Values[names[i]], en.Values[names[j]]
+
+		return a < b || a == b && names[i] < names[j]
+	})
+
+	return names
+}
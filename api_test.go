@@ -0,0 +1,126 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DescribeAPI", func() {
+	var (
+		engine *Engine
+		api    *API
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		engine.RegisterFunc("double", func(x float64) float64 {
+			return x * 2
+		})
+		engine.RegisterModule("world", map[string]interface{}{
+			"name": "earth",
+			"find": Func1(engine, func(name string) (*Creature, error) {
+				return nil, nil
+			}),
+		})
+		engine.RegisterClass("Creature", Creature{}, TypeOptions{Properties: true})
+		Expect(engine.RegisterEnum("Direction", map[string]Direction{"North": North})).To(BeNil())
+		engine.SetGlobal("version", "1.0")
+		engine.SetDoc("double", "Doubles a number.")
+		engine.SetDoc("world.find", "Finds a creature by name.")
+		engine.SetDoc("Creature.health", "Current health.")
+		api = engine.DescribeAPI()
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should describe registered functions and modules", func() {
+		Expect(api.Functions).To(HaveLen(1))
+		Expect(api.Functions[0].Name).To(Equal("double"))
+		Expect(api.Functions[0].Signature).To(Equal("func(float64) float64"))
+		Expect(api.Functions[0].Doc).To(Equal("Doubles a number."))
+
+		Expect(api.Modules).To(HaveLen(1))
+		world := api.Modules[0]
+		Expect(world.Functions).To(HaveLen(1))
+		Expect(world.Functions[0].Signature).To(Equal("func(string) (*lua_test.Creature, error)"))
+		Expect(world.Fields).To(HaveLen(1))
+		Expect(world.Fields[0].Type).To(Equal("string"))
+	})
+
+	It("should describe types, enums and globals", func() {
+		Expect(api.Types).To(HaveLen(1))
+		creature := api.Types[0]
+		Expect(creature.Class).To(BeTrue())
+		var fields, methods []string
+		for _, f := range creature.Fields {
+			fields = append(fields, f.Name)
+		}
+		for _, m := range creature.Methods {
+			methods = append(methods, m.Name)
+		}
+		Expect(fields).To(Equal([]string{"name", "alive", "health"}))
+		Expect(methods).To(ContainElement("getHealth"))
+		Expect(creature.Fields[2].Doc).To(Equal("Current health."))
+
+		Expect(api.Enums).To(HaveLen(1))
+		Expect(api.Enums[0].Values).To(Equal(map[string]int64{"North": 1}))
+		Expect(api.Globals).To(HaveLen(1))
+		Expect(api.Globals[0].Name).To(Equal("version"))
+	})
+
+	It("should replace earlier registrations of the same name", func() {
+		engine.RegisterFunc("double", func(x int) int {
+			return x * 2
+		})
+		api = engine.DescribeAPI()
+		Expect(api.Functions).To(HaveLen(1))
+		Expect(api.Functions[0].Signature).To(Equal("func(int) int"))
+	})
+
+	It("should record registrations and docs made from ScriptFunctions", func() {
+		engine.RegisterFunc("setup", func(se *Engine) int {
+			se.RegisterFunc("triple", func(x float64) float64 {
+				return x * 3
+			})
+			se.SetDoc("triple", "Triples a number.")
+
+			return 0
+		})
+		Expect(engine.LoadString(`setup()`)).To(BeNil())
+
+		api = engine.DescribeAPI()
+		triple := api.Functions[len(api.Functions)-1]
+		Expect(triple.Name).To(Equal("triple"))
+		Expect(triple.Doc).To(Equal("Triples a number."))
+	})
+
+	It("should export Markdown", func() {
+		md := api.Markdown()
+		Expect(md).To(ContainSubstring("### double\n\n`func(float64) float64`\n\nDoubles a number.\n"))
+		Expect(md).To(ContainSubstring("#### world.find"))
+		Expect(md).To(ContainSubstring("| `health` | `int` | Current health. |"))
+		Expect(md).To(ContainSubstring("| `North` | 1 |"))
+	})
+
+	It("should export EmmyLua stubs", func() {
+		stubs := api.Stubs()
+		Expect(stubs).To(HavePrefix("---@meta\n"))
+		Expect(stubs).To(ContainSubstring("---@class Creature\n---@field name string\n"))
+		Expect(stubs).ToNot(ContainSubstring("world"))
+		Expect(stubs).To(ContainSubstring("---@param arg1 number\n---@return number\nfunction double(arg1) end\n"))
+		Expect(stubs).To(ContainSubstring("---@enum Direction\n"))
+		Expect(stubs).To(ContainSubstring("---@type string\nversion = nil\n"))
+	})
+
+	It("should export modules as stubs returning their table", func() {
+		stubs := api.ModuleStubs()
+		Expect(stubs).To(HaveLen(1))
+		Expect(stubs["world"]).To(HavePrefix("---@meta world\n\n---@class world\n---@field name string\nlocal world = {}\n"))
+		Expect(stubs["world"]).To(ContainSubstring("---Finds a creature by name.\n---@param arg1 string\n---@return Creature\nfunction world.find(arg1) end\n"))
+		Expect(stubs["world"]).To(HaveSuffix("\nreturn world\n"))
+	})
+})
@@ -0,0 +1,71 @@
+package lua_test
+
+import (
+	"testing"
+
+	. "github.com/seer-server/script-engine"
+)
+
+var bindCode = `
+	function call_add(n)
+		local sum = 0
+		for i = 1, n do
+			sum = add(sum, i)
+		end
+		return sum
+	end
+`
+
+func Benchmark_RegisterFuncAdd(b *testing.B) {
+	e := NewEngine()
+	defer e.Close()
+	e.RegisterFunc("add", func(a, b int) int {
+		return a + b
+	})
+	e.LoadString(bindCode)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ret, _ := e.Call("call_add", 1, 100)
+		_ = ret[0].AsNumber()
+	}
+}
+
+func Benchmark_Bind2Add(b *testing.B) {
+	e := NewEngine()
+	defer e.Close()
+	Bind2(e, "add", func(a, b int) (int, error) {
+		return a + b, nil
+	})
+	e.LoadString(bindCode)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ret, _ := e.Call("call_add", 1, 100)
+		_ = ret[0].AsNumber()
+	}
+}
+
+func Benchmark_NewSecureEngine(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		e, _ := NewSecureEngine()
+		e.RegisterFunc("add", func(a, b int) int {
+			return a + b
+		})
+		e.LoadString(bindCode)
+		e.Close()
+	}
+}
+
+func Benchmark_Reset(b *testing.B) {
+	e, _ := NewSecureEngine()
+	defer e.Close()
+	e.RegisterFunc("add", func(a, b int) int {
+		return a + b
+	})
+	e.LoadString(bindCode)
+	e.Checkpoint()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Call("call_add", 1, 10)
+		e.Reset()
+	}
+}
@@ -0,0 +1,186 @@
+package lua
+
+import (
+	"reflect"
+
+	"github.com/layeh/gopher-luar"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// The Bind and Func functions wrap Go functions with converters for their
+// argument and result types built once, instead of going through
+// gopher-luar's reflection on every call. A non-nil error returned by the
+// function is raised as a Lua error.
+//
+// Arguments of type int, int64, float64, string, bool, *Value and
+// glua.LValue are converted directly, other types fall back to reflection.
+
+// Bind0 registers fn as the global function name.
+func Bind0[R any](e *Engine, name string, fn func() (R, error)) {
+	e.register(name, e.goFunc(name, Func0(e, fn).lval))
+	e.record(&registration{kind: "function", name: name, typ: reflect.TypeOf(fn)})
+}
+
+// Bind1 registers fn as the global function name.
+func Bind1[A, R any](e *Engine, name string, fn func(A) (R, error)) {
+	e.register(name, e.goFunc(name, Func1(e, fn).lval))
+	e.record(&registration{kind: "function", name: name, typ: reflect.TypeOf(fn)})
+}
+
+// Bind2 registers fn as the global function name.
+func Bind2[A, B, R any](e *Engine, name string, fn func(A, B) (R, error)) {
+	e.register(name, e.goFunc(name, Func2(e, fn).lval))
+	e.record(&registration{kind: "function", name: name, typ: reflect.TypeOf(fn)})
+}
+
+// Bind3 registers fn as the global function name.
+func Bind3[A, B, C, R any](e *Engine, name string, fn func(A, B, C) (R, error)) {
+	e.register(name, e.goFunc(name, Func3(e, fn).lval))
+	e.record(&registration{kind: "function", name: name, typ: reflect.TypeOf(fn)})
+}
+
+// Func0 returns fn as a Lua function, for use as a field of a module or table.
+func Func0[R any](e *Engine, fn func() (R, error)) *Value {
+	ret := retOf[R]()
+
+	return e.typedFunc(reflect.TypeOf(fn), 0, func(l *glua.LState) int {
+		v, err := fn()
+
+		return ret(l, v, err)
+	})
+}
+
+// Func1 returns fn as a Lua function, for use as a field of a module or table.
+func Func1[A, R any](e *Engine, fn func(A) (R, error)) *Value {
+	a := argOf[A](e)
+	ret := retOf[R]()
+
+	return e.typedFunc(reflect.TypeOf(fn), 1, func(l *glua.LState) int {
+		v, err := fn(a(l, 1))
+
+		return ret(l, v, err)
+	})
+}
+
+// Func2 returns fn as a Lua function, for use as a field of a module or table.
+func Func2[A, B, R any](e *Engine, fn func(A, B) (R, error)) *Value {
+	a, b := argOf[A](e), argOf[B](e)
+	ret := retOf[R]()
+
+	return e.typedFunc(reflect.TypeOf(fn), 2, func(l *glua.LState) int {
+		v, err := fn(a(l, 1), b(l, 2))
+
+		return ret(l, v, err)
+	})
+}
+
+// Func3 returns fn as a Lua function, for use as a field of a module or table.
+func Func3[A, B, C, R any](e *Engine, fn func(A, B, C) (R, error)) *Value {
+	a, b, c := argOf[A](e), argOf[B](e), argOf[C](e)
+	ret := retOf[R]()
+
+	return e.typedFunc(reflect.TypeOf(fn), 3, func(l *glua.LState) int {
+		v, err := fn(a(l, 1), b(l, 2), c(l, 3))
+
+		return ret(l, v, err)
+	})
+}
+
+// typedFunc returns fn, a function taking n arguments, as a Lua function. typ
+// is the type of the Go function it calls.
+func (e *Engine) typedFunc(typ reflect.Type, n int, fn glua.LGFunction) *Value {
+	lfn := e.state.NewFunction(func(l *glua.LState) int {
+		if top := l.GetTop(); top != n {
+			l.RaiseError("invalid number of function argument (%d expected, got %d)", n, top)
+		}
+
+		return fn(l)
+	})
+	e.root().funcTypes[lfn] = typ
+
+	return e.value(lfn)
+}
+
+// argOf returns the converter for arguments of type T.
+func argOf[T any](e *Engine) func(*glua.LState, int) T {
+	var conv interface{}
+	switch reflect.TypeOf((*T)(nil)).Elem() {
+	case reflect.TypeOf(0):
+		conv = func(l *glua.LState, n int) int { return l.CheckInt(n) }
+	case reflect.TypeOf(int64(0)):
+		conv = func(l *glua.LState, n int) int64 { return l.CheckInt64(n) }
+	case reflect.TypeOf(0.0):
+		conv = func(l *glua.LState, n int) float64 { return float64(l.CheckNumber(n)) }
+	case reflect.TypeOf(""):
+		conv = func(l *glua.LState, n int) string { return l.CheckString(n) }
+	case reflect.TypeOf(false):
+		conv = func(l *glua.LState, n int) bool { return glua.LVAsBool(l.Get(n)) }
+	case reflect.TypeOf(&Value{}):
+		conv = func(l *glua.LState, n int) *Value { return e.value(l.Get(n)) }
+	case lvalueType:
+		conv = func(l *glua.LState, n int) glua.LValue { return l.Get(n) }
+	}
+	if conv != nil {
+		return conv.(func(*glua.LState, int) T)
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	return func(l *glua.LState, n int) T {
+		e.enumValue(l, n, t)
+		val, err := reflectValue(l.Get(n), t)
+		if err != nil {
+			l.ArgError(n, err.Error())
+		}
+
+		v, _ := val.Interface().(T)
+
+		return v
+	}
+}
+
+// retOf returns the function pushing results of type R, or raising the error
+// returned with them.
+func retOf[R any]() func(*glua.LState, R, error) int {
+	var push interface{}
+	switch reflect.TypeOf((*R)(nil)).Elem() {
+	case reflect.TypeOf(0):
+		push = func(l *glua.LState, v int) { l.Push(glua.LNumber(v)) }
+	case reflect.TypeOf(int64(0)):
+		push = func(l *glua.LState, v int64) { l.Push(glua.LNumber(v)) }
+	case reflect.TypeOf(0.0):
+		push = func(l *glua.LState, v float64) { l.Push(glua.LNumber(v)) }
+	case reflect.TypeOf(""):
+		push = func(l *glua.LState, v string) { l.Push(glua.LString(v)) }
+	case reflect.TypeOf(false):
+		push = func(l *glua.LState, v bool) { l.Push(glua.LBool(v)) }
+	case reflect.TypeOf(&Value{}):
+		push = func(l *glua.LState, v *Value) {
+			if v == nil {
+				l.Push(glua.LNil)
+			} else {
+				l.Push(v.lval)
+			}
+		}
+	case lvalueType:
+		push = func(l *glua.LState, v glua.LValue) {
+			if v == nil {
+				l.Push(glua.LNil)
+			} else {
+				l.Push(v)
+			}
+		}
+	default:
+		push = func(l *glua.LState, v R) { l.Push(luar.New(l, v)) }
+	}
+	p := push.(func(*glua.LState, R))
+
+	return func(l *glua.LState, v R, err error) int {
+		if err != nil {
+			l.RaiseError("%s", err.Error())
+		}
+		p(l, v)
+
+		return 1
+	}
+}
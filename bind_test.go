@@ -0,0 +1,113 @@
+package lua_test
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bind", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should convert arguments and results", func() {
+		Bind0(engine, "answer", func() (int, error) {
+			return 42, nil
+		})
+		Bind1(engine, "upper", func(s string) (string, error) {
+			return strings.ToUpper(s), nil
+		})
+		Bind2(engine, "add", func(a, b float64) (float64, error) {
+			return a + b, nil
+		})
+		Bind3(engine, "pick", func(cond bool, a, b *Value) (*Value, error) {
+			if cond {
+				return a, nil
+			}
+
+			return b, nil
+		})
+
+		Expect(engine.LoadString(`
+			a, u, s, p = answer(), upper("hi"), add(1.5, 2), pick(false, {}, "b")
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("a").AsNumber()).To(Equal(42.0))
+		Expect(engine.GetGlobal("u").AsString()).To(Equal("HI"))
+		Expect(engine.GetGlobal("s").AsNumber()).To(Equal(3.5))
+		Expect(engine.GetGlobal("p").AsString()).To(Equal("b"))
+	})
+
+	It("should convert other types with reflection", func() {
+		engine.RegisterType("Vector", Vector{})
+		Bind2(engine, "scale", func(v *Vector, n int32) (*Vector, error) {
+			return &Vector{v.X * float64(n), v.Y * float64(n)}, nil
+		})
+
+		Expect(engine.LoadString(`v = scale(Vector(), 2)`)).To(BeNil())
+		Expect(engine.GetGlobal("v").Interface()).To(Equal(&Vector{0, 0}))
+		Expect(engine.LoadString(`v = scale("x", 2)`)).ToNot(BeNil())
+	})
+
+	It("should raise returned errors", func() {
+		Bind1(engine, "fail", func(msg string) (bool, error) {
+			return false, errors.New(msg)
+		})
+
+		err := engine.LoadString(`fail("out of mana")`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("out of mana"))
+	})
+
+	It("should check the number of arguments", func() {
+		Bind2(engine, "add", func(a, b int) (int, error) {
+			return a + b, nil
+		})
+
+		err := engine.LoadString(`add(1)`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("2 expected, got 1"))
+	})
+
+	It("should create functions for modules", func() {
+		engine.RegisterModule("text", map[string]interface{}{
+			"upper": Func1(engine, func(s string) (string, error) {
+				return strings.ToUpper(s), nil
+			}),
+		})
+
+		Expect(engine.LoadString(`u = require("text").upper("hi")`)).To(BeNil())
+		Expect(engine.GetGlobal("u").AsString()).To(Equal("HI"))
+	})
+	It("should bind functions from ScriptFunctions", func() {
+		engine.RegisterFunc("setup", func(se *Engine) int {
+			Bind1(se, "upper", func(s string) (string, error) {
+				return strings.ToUpper(s), nil
+			})
+			se.RegisterModule("text", map[string]interface{}{
+				"lower": Func1(se, func(s string) (string, error) {
+					return strings.ToLower(s), nil
+				}),
+			})
+
+			return 0
+		})
+
+		Expect(engine.LoadString(`
+			setup()
+			u, l = upper("hi"), require("text").lower("HI")
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("u").AsString()).To(Equal("HI"))
+		Expect(engine.GetGlobal("l").AsString()).To(Equal("hi"))
+	})
+})
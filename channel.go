@@ -0,0 +1,387 @@
+package lua
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/layeh/gopher-luar"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DefaultChannelTimeout is how long channel operations of secure engines wait
+// when the script gives no timeout, unless Engine.ChannelTimeout is set.
+const DefaultChannelTimeout = 30 * time.Second
+
+// luaChannel is a channel used from Lua, either one made by channel.make,
+// holding Lua values, or a Go channel bound with BindChannel.
+type luaChannel struct {
+	ch     reflect.Value
+	name   string
+	native bool
+}
+
+// BindChannel exposes ch, a Go channel of any element type and direction, as
+// the global name. Scripts use it with the channel module, or its receive,
+// send and close methods, and can only use it in the directions the channel
+// type allows.
+//
+// Values are converted as they cross the channel: tables sent to Go are
+// copied into the element type, as encoding/json would decode them, and maps
+// and slices received from Go are copied into tables. Userdata, and the other
+// values received from Go, share the Go value with the other end of the
+// channel, which must only change it in ways safe for concurrent use.
+func (e *Engine) BindChannel(name string, ch interface{}) error {
+	v := reflect.ValueOf(ch)
+	if v.Kind() != reflect.Chan {
+		return fmt.Errorf("cannot bind %T as channel %s, it isn't a channel", ch, name)
+	}
+
+	ud := e.state.NewUserData()
+	ud.Value = &luaChannel{ch: v, name: name}
+	ud.Metatable = e.channelMetatable()
+	e.register(name, ud)
+	e.record(&registration{kind: "global", name: name, typ: v.Type()})
+
+	return nil
+}
+
+// channelMetatable returns the metatable of bound channels, created the first
+// time it's needed.
+func (e *Engine) channelMetatable() *glua.LTable {
+	if e.chanMeta != nil {
+		return e.chanMeta
+	}
+	methods := e.state.NewTable()
+	for _, name := range []string{"receive", "send", "close"} {
+		methods.RawSetH(glua.LString(name), e.channelFuncs[name])
+	}
+	e.chanMeta = e.state.NewTable()
+	e.chanMeta.RawSetH(glua.LString("__index"), methods)
+	e.chanMeta.RawSetH(glua.LString("__tostring"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(glua.LString("channel: " + e.checkChannel(l, 1).name))
+
+		return 1
+	}))
+	e.chanMeta.RawSetH(glua.LString("__metatable"), glua.LFalse)
+
+	return e.chanMeta
+}
+
+// openChannel replaces the channel module of gopher-lua with one adding
+// timeouts and support for bound channels, it's safe to use in sandboxes.
+//
+//	channel.make([buffer]) -> channel
+//	channel.receive(ch [, timeout]) -> ok, value | false, nil, "timeout"
+//	channel.send(ch, value [, timeout]) -> true | false, "timeout"
+//	channel.close(ch)
+//	channel.select(case, ...) -> index, value, ok
+//
+// Timeouts are in seconds. The cases of select are tables, {"|<-", ch} to
+// receive, {"<-|", ch, value} to send, {"default"} and {"timeout", seconds},
+// each optionally followed by a function called when the case is chosen.
+//
+// Waiting stops with an error when the context of the call ends, and in secure
+// engines when an operation without a timeout waits longer than the
+// ChannelTimeout of the Engine.
+func (e *Engine) openChannel() {
+	e.channelFuncs = map[string]*glua.LFunction{
+		"make": e.state.NewFunction(func(l *glua.LState) int {
+			l.Push(glua.LChannel(make(chan glua.LValue, l.OptInt(1, 0))))
+
+			return 1
+		}),
+		"receive": e.state.NewFunction(func(l *glua.LState) int {
+			c := e.checkChannel(l, 1)
+			e.checkDir(l, c, reflect.RecvDir)
+			cases := append([]reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: c.ch}}, timeoutCase(l, 2)...)
+			i, v, ok := e.wait(l, cases, len(cases) > 1)
+			if i == 1 {
+				l.Push(glua.LFalse)
+				l.Push(glua.LNil)
+				l.Push(glua.LString("timeout"))
+
+				return 3
+			}
+			l.Push(glua.LBool(ok))
+			l.Push(e.fromChannel(l, c, v, ok))
+
+			return 2
+		}),
+		"send": e.state.NewFunction(func(l *glua.LState) int {
+			c := e.checkChannel(l, 1)
+			e.checkDir(l, c, reflect.SendDir)
+			v := e.toChannel(l, c, 2)
+			cases := append([]reflect.SelectCase{{Dir: reflect.SelectSend, Chan: c.ch, Send: v}}, timeoutCase(l, 3)...)
+			if i, _, _ := e.wait(l, cases, len(cases) > 1); i == 1 {
+				l.Push(glua.LFalse)
+				l.Push(glua.LString("timeout"))
+
+				return 2
+			}
+			l.Push(glua.LTrue)
+
+			return 1
+		}),
+		"close": e.state.NewFunction(func(l *glua.LState) int {
+			c := e.checkChannel(l, 1)
+			e.checkDir(l, c, reflect.SendDir)
+			defer func() {
+				if r := recover(); r != nil {
+					l.RaiseError("%s", r)
+				}
+			}()
+			c.ch.Close()
+
+			return 0
+		}),
+		"select": e.state.NewFunction(e.channelSelect),
+	}
+
+	module := e.state.NewTable()
+	for name, fn := range e.channelFuncs {
+		module.RawSetH(glua.LString(name), fn)
+	}
+	// the methods of channels made in Lua wait like the module functions
+	if mt, ok := e.state.GetMetatable(glua.LChannel(nil)).(*glua.LTable); ok {
+		for _, name := range []string{"receive", "send", "close"} {
+			mt.RawSetH(glua.LString(name), e.channelFuncs[name])
+		}
+	}
+	e.state.SetGlobal("channel", module)
+	if loaded, ok := e.state.GetField(e.state.Get(glua.RegistryIndex), "_LOADED").(*glua.LTable); ok {
+		loaded.RawSetH(glua.LString("channel"), module)
+	}
+}
+
+// channelSelect implements channel.select.
+func (e *Engine) channelSelect(l *glua.LState) int {
+	top := l.GetTop()
+	if top == 0 {
+		l.ArgError(1, "select case expected")
+	}
+	cases := make([]reflect.SelectCase, top)
+	chans := make([]*luaChannel, top)
+	// a default or timeout case keeps select from waiting forever
+	bounded := false
+	for i := range cases {
+		tbl := l.CheckTable(i + 1)
+		dir, _ := tbl.RawGetInt(1).(glua.LString)
+		switch dir {
+		case "|<-", "<-|":
+			l.Push(tbl.RawGetInt(2))
+			c := e.checkChannel(l, l.GetTop())
+			l.Pop(1)
+			chans[i] = c
+			cases[i].Chan = c.ch
+			if dir == "|<-" {
+				e.checkDir(l, c, reflect.RecvDir)
+				cases[i].Dir = reflect.SelectRecv
+			} else {
+				e.checkDir(l, c, reflect.SendDir)
+				l.Push(tbl.RawGetInt(3))
+				cases[i].Dir = reflect.SelectSend
+				cases[i].Send = e.toChannel(l, c, l.GetTop())
+				l.Pop(1)
+			}
+		case "default":
+			cases[i].Dir = reflect.SelectDefault
+			bounded = true
+		case "timeout":
+			l.Push(tbl.RawGetInt(2))
+			timeout := timeoutCase(l, l.GetTop())
+			l.Pop(1)
+			if len(timeout) == 0 {
+				l.ArgError(i+1, "timeout case needs a number of seconds")
+			}
+			cases[i] = timeout[0]
+			bounded = true
+		default:
+			l.ArgError(i+1, fmt.Sprintf("invalid select case %q", string(dir)))
+		}
+	}
+
+	pos, recv, ok := e.wait(l, cases, bounded)
+	lv := glua.LValue(glua.LNil)
+	if cases[pos].Dir == reflect.SelectRecv && chans[pos] != nil {
+		lv = e.fromChannel(l, chans[pos], recv, ok)
+	}
+	tbl := l.Get(pos + 1).(*glua.LTable)
+	if fn, isFn := tbl.RawGetInt(tbl.Len()).(*glua.LFunction); isFn {
+		l.Push(fn)
+		switch {
+		case chans[pos] != nil && cases[pos].Dir == reflect.SelectRecv:
+			l.Push(glua.LBool(ok))
+			l.Push(lv)
+			l.Call(2, 0)
+		case cases[pos].Dir == reflect.SelectSend:
+			l.Push(tbl.RawGetInt(3))
+			l.Call(1, 0)
+		default:
+			l.Call(0, 0)
+		}
+	}
+	l.Push(glua.LNumber(pos + 1))
+	l.Push(lv)
+	l.Push(glua.LBool(ok && chans[pos] != nil))
+
+	return 3
+}
+
+// wait runs the select cases of a channel operation. Unless bounded, because
+// the script gave a timeout or a default case, secure engines stop waiting
+// after their ChannelTimeout. An error is raised when the context of the call
+// or that timeout ends first.
+func (e *Engine) wait(l *glua.LState, cases []reflect.SelectCase, bounded bool) (int, reflect.Value, bool) {
+	n := len(cases)
+	cases = cases[:n:n]
+	var done <-chan struct{}
+	if e.ctx != nil {
+		done = e.ctx.Done()
+	}
+	if done != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	timeout := e.ChannelTimeout
+	if timeout == 0 {
+		timeout = DefaultChannelTimeout
+	}
+	if e.Secure && !bounded && timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+	}
+
+	pos, recv, ok := channelSelect(l, cases)
+	switch {
+	case pos < n:
+		return pos, recv, ok
+	case done != nil && pos == n:
+		l.RaiseError("channel operation stopped: %s", e.ctx.Err())
+	default:
+		l.RaiseError("channel operation timed out after %s", timeout)
+	}
+
+	return pos, recv, ok
+}
+
+// channelSelect runs reflect.Select, raising the panics of sends on closed
+// channels as Lua errors.
+func channelSelect(l *glua.LState, cases []reflect.SelectCase) (pos int, recv reflect.Value, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.RaiseError("%s", r)
+		}
+	}()
+
+	return reflect.Select(cases)
+}
+
+// timeoutCase returns a select case for the timeout in seconds at idx, none if
+// there is no timeout.
+func timeoutCase(l *glua.LState, idx int) []reflect.SelectCase {
+	if l.Get(idx) == glua.LNil {
+		return nil
+	}
+	d := time.Duration(float64(l.CheckNumber(idx)) * float64(time.Second))
+
+	return []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(time.After(d))}}
+}
+
+// checkChannel returns the channel at idx, raising an error if it isn't one.
+func (e *Engine) checkChannel(l *glua.LState, idx int) *luaChannel {
+	switch v := l.Get(idx).(type) {
+	case glua.LChannel:
+		return &luaChannel{ch: reflect.ValueOf((chan glua.LValue)(v)), name: v.String(), native: true}
+	case *glua.LUserData:
+		if c, ok := v.Value.(*luaChannel); ok {
+			return c
+		}
+	}
+	l.ArgError(idx, "channel expected")
+
+	return nil
+}
+
+// checkDir raises an error if c can't be used in the direction dir.
+func (e *Engine) checkDir(l *glua.LState, c *luaChannel, dir reflect.ChanDir) {
+	if c.ch.Type().ChanDir()&dir != 0 {
+		return
+	}
+	if dir == reflect.SendDir {
+		l.RaiseError("channel %s is receive-only", c.name)
+	}
+	l.RaiseError("channel %s is send-only", c.name)
+}
+
+// toChannel converts the value at idx to the element type of c.
+func (e *Engine) toChannel(l *glua.LState, c *luaChannel, idx int) reflect.Value {
+	lv := l.Get(idx)
+	if c.native {
+		switch v := lv.(type) {
+		case *glua.LFunction, *glua.LUserData:
+			l.ArgError(idx, "can not send a function, userdata, thread or table that has a metatable")
+		case *glua.LTable:
+			if v.Metatable != glua.LNil {
+				l.ArgError(idx, "can not send a function, userdata, thread or table that has a metatable")
+			}
+		}
+
+		return reflect.ValueOf(&lv).Elem()
+	}
+
+	t := c.ch.Type().Elem()
+	if _, ok := lv.(*glua.LTable); ok {
+		data, err := e.encodeJSON(lv, "")
+		if err != nil {
+			l.ArgError(idx, err.Error())
+		}
+		v := reflect.New(t)
+		if err := json.Unmarshal([]byte(data), v.Interface()); err != nil {
+			l.ArgError(idx, fmt.Sprintf("cannot send table on channel %s: %s", c.name, err))
+		}
+
+		return v.Elem()
+	}
+	e.enumValue(l, idx, t)
+	v, err := reflectValue(l.Get(idx), t)
+	if err != nil {
+		l.ArgError(idx, err.Error())
+	}
+
+	return v
+}
+
+// fromChannel converts v, received from c, to Lua. Values received from
+// closed channels are nil.
+func (e *Engine) fromChannel(l *glua.LState, c *luaChannel, v reflect.Value, ok bool) glua.LValue {
+	if !ok || !v.IsValid() {
+		return glua.LNil
+	}
+	if c.native {
+		lv, _ := v.Interface().(glua.LValue)
+		if lv == nil {
+			return glua.LNil
+		}
+
+		return lv
+	}
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && !v.IsNil() {
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			l.RaiseError("cannot receive %s on channel %s: %s", v.Type(), c.name, err)
+		}
+		lv, err := e.decodeJSON(data)
+		if err != nil {
+			l.RaiseError("cannot receive %s on channel %s: %s", v.Type(), c.name, err)
+		}
+
+		return lv
+	}
+
+	return luar.New(l, v.Interface())
+}
@@ -0,0 +1,175 @@
+package lua_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type Order struct {
+	Item  string `json:"item"`
+	Count int    `json:"count"`
+}
+
+var _ = Describe("Channels", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should receive values from Go", func() {
+		events := make(chan map[string]interface{}, 1)
+		Expect(engine.BindChannel("events", (<-chan map[string]interface{})(events))).To(BeNil())
+		events <- map[string]interface{}{"name": "spawn", "ids": []int{1, 2}}
+		Expect(engine.LoadString(`
+			ok, ev = events:receive()
+			name, second = ev.name, ev.ids[2]
+			timedOut, _, reason = channel.receive(events, 0.01)
+			sendOk, sendErr = pcall(events.send, events, 1)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("ok").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("spawn"))
+		Expect(engine.GetGlobal("second").AsNumber()).To(Equal(2.0))
+		Expect(engine.GetGlobal("timedOut").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("reason").AsString()).To(Equal("timeout"))
+		Expect(engine.GetGlobal("sendOk").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("sendErr").AsString()).To(ContainSubstring("channel events is receive-only"))
+
+		close(events)
+		Expect(engine.LoadString(`closedOk, closedVal = events:receive()`)).To(BeNil())
+		Expect(engine.GetGlobal("closedOk").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("closedVal").IsNil()).To(BeTrue())
+	})
+
+	It("should send converted values to Go", func() {
+		orders := make(chan Order, 1)
+		counts := make(chan int)
+		Expect(engine.BindChannel("orders", (chan<- Order)(orders))).To(BeNil())
+		Expect(engine.BindChannel("counts", counts)).To(BeNil())
+		Expect(engine.LoadString(`
+			orders:send({item = "sword", count = 2})
+			full, reason = orders:send({item = "shield"}, 0.01)
+			recvOk, recvErr = pcall(orders.receive, orders)
+		`)).To(BeNil())
+		Expect(<-orders).To(Equal(Order{Item: "sword", Count: 2}))
+		Expect(engine.GetGlobal("full").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("reason").AsString()).To(Equal("timeout"))
+		Expect(engine.GetGlobal("recvErr").AsString()).To(ContainSubstring("channel orders is send-only"))
+
+		go func() {
+			counts <- <-counts * 2
+		}()
+		Expect(engine.LoadString(`counts:send(5, 1); _, n = counts:receive(1)`)).To(BeNil())
+		Expect(engine.GetGlobal("n").AsNumber()).To(Equal(10.0))
+	})
+
+	It("should select between channels with a timeout", func() {
+		counts := make(chan int, 1)
+		counts <- 3
+		Expect(engine.BindChannel("counts", counts)).To(BeNil())
+		Expect(engine.LoadString(`
+			local local_ch = channel.make(1)
+			got = nil
+			i, v, ok = channel.select(
+				{"|<-", local_ch},
+				{"|<-", counts, function(ok, v) got = v end}
+			)
+			ti, tv, tok = channel.select({"|<-", local_ch}, {"timeout", 0.01})
+			local_ch:send("hi")
+			_, msg = channel.receive(local_ch)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("i").AsNumber()).To(Equal(2.0))
+		Expect(engine.GetGlobal("v").AsNumber()).To(Equal(3.0))
+		Expect(engine.GetGlobal("ok").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("got").AsNumber()).To(Equal(3.0))
+		Expect(engine.GetGlobal("ti").AsNumber()).To(Equal(2.0))
+		Expect(engine.GetGlobal("tok").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("msg").AsString()).To(Equal("hi"))
+	})
+
+	It("should be available to secure engines", func() {
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		secure.ExposeRegistered = true
+		results := make(chan string, 1)
+		Expect(secure.BindChannel("results", results)).To(BeNil())
+		Expect(secure.LoadString(`
+			function report()
+				local ch = channel.make(1)
+				channel.send(ch, "done")
+				local _, v = channel.receive(ch, 1)
+				results:send(v)
+			end
+		`)).To(BeNil())
+		_, err = secure.Call("report", 0)
+		Expect(err).To(BeNil())
+		Eventually(results, time.Second).Should(Receive(Equal("done")))
+
+		Expect(secure.BindChannel("bad", 1)).ToNot(BeNil())
+	})
+	It("should reject a select without cases", func() {
+		err := engine.LoadString(`channel.select()`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("select case expected"))
+	})
+
+	It("should stop waiting when the context of the call ends", func() {
+		Expect(engine.LoadString(`
+			function wait()
+				return channel.receive(channel.make())
+			end
+		`)).To(BeNil())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := engine.CallContext(ctx, "wait", 2)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("channel operation stopped: context deadline exceeded"))
+	})
+
+	It("should time out waits without a timeout in secure engines", func() {
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		secure.ChannelTimeout = 10 * time.Millisecond
+		Expect(secure.LoadString(`
+			function wait()
+				local ch = channel.make()
+				local _, _, msg = channel.receive(ch, 0.001)
+				channel.receive, json.encode = nil, nil
+				local ok = ch:receive()
+				return msg
+			end
+		`)).To(BeNil())
+		_, err = secure.Call("wait", 1)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("channel operation timed out after 10ms"))
+
+		Expect(secure.LoadString(`
+			function select()
+				return channel.select({"|<-", channel.make()})
+			end
+		`)).To(BeNil())
+		_, err = secure.Call("select", 1)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("timed out"))
+
+		// the sandbox changed copies of the modules
+		for _, module := range []string{"channel", "json"} {
+			var names []string
+			secure.GetGlobal(module).ForEach(func(key, _ *Value) {
+				names = append(names, key.AsString())
+			})
+			Expect(names).To(ContainElement(Or(Equal("receive"), Equal("encode"))))
+		}
+	})
+})
@@ -0,0 +1,237 @@
+package lua
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/layeh/gopher-luar"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// classKeys are the entries of class tables that aren't members of their
+// instances.
+var classKeys = map[string]bool{"new": true, "extend": true, "isinstance": true}
+
+// instanceKey marks the Env tables Class.new gives to the instances it
+// creates, holding their class. Other userdata have the globals as their Env,
+// so it is never read without the mark.
+var instanceKey = &glua.LUserData{}
+
+// class describes a class table created by RegisterClass or by extending
+// another class from Lua.
+type class struct {
+	name   string
+	info   *typeInfo
+	ctor   glua.LValue
+	parent *glua.LTable
+}
+
+// newClass creates the table for a class whose instances are built by ctor.
+// Base classes get the extend and isinstance functions and resolve the Go
+// methods of their type, so overrides can call them as Base.method(self).
+func (e *Engine) newClass(name string, info *typeInfo, ctor glua.LValue, parent *glua.LTable) *glua.LTable {
+	table := e.state.NewTable()
+	e.root().classes[table] = &class{name: name, info: info, ctor: ctor, parent: parent}
+	table.RawSetH(glua.LString("new"), e.state.NewFunction(func(l *glua.LState) int {
+		top := l.GetTop()
+		l.Push(ctor)
+		for i := 1; i <= top; i++ {
+			l.Push(l.Get(i))
+		}
+		l.Call(top, 1)
+		if ud, ok := l.Get(-1).(*glua.LUserData); ok && reflect.ValueOf(ud.Value).Kind() == reflect.Ptr {
+			ud.Env = l.NewTable()
+			ud.Env.RawSetH(instanceKey, table)
+		}
+
+		return 1
+	}))
+
+	mt := e.state.NewTable()
+	if parent != nil {
+		mt.RawSetH(glua.LString("__index"), parent)
+	} else {
+		table.RawSetH(glua.LString("extend"), e.state.NewFunction(e.classExtend))
+		table.RawSetH(glua.LString("isinstance"), e.state.NewFunction(e.classIsInstance))
+		if info != nil {
+			info.class = table
+			mt.RawSetH(glua.LString("__index"), e.state.NewFunction(func(l *glua.LState) int {
+				name, ok := info.methods[l.CheckString(2)]
+				if !ok {
+					return 0
+				}
+				m, _ := reflect.PtrTo(info.typ).MethodByName(name)
+				l.Push(luar.New(l, m.Func.Interface()))
+
+				return 1
+			}))
+		}
+	}
+	e.state.SetMetatable(table, mt)
+
+	return table
+}
+
+// classExtend implements Class:extend([name]), returning a subclass whose
+// instances are created with the constructor of the base class.
+func (e *Engine) classExtend(l *glua.LState) int {
+	parent := l.CheckTable(1)
+	c, ok := e.root().classes[parent]
+	if !ok {
+		l.ArgError(1, "class expected")
+	}
+	name := l.OptString(2, c.name)
+	l.Push(e.newClass(name, c.info, c.ctor, parent))
+
+	return 1
+}
+
+// classIsInstance implements Class:isinstance(value).
+func (e *Engine) classIsInstance(l *glua.LState) int {
+	cls := l.CheckTable(1)
+	l.Push(glua.LBool(e.isInstance(l.Get(2), cls)))
+
+	return 1
+}
+
+// isInstance returns true if lv holds a value of cls or of one of its
+// subclasses.
+func (e *Engine) isInstance(lv glua.LValue, cls *glua.LTable) bool {
+	ud, ok := lv.(*glua.LUserData)
+	if !ok {
+		return false
+	}
+	for c := e.classOf(ud); c != nil; c = e.root().classes[c].parent {
+		if c == cls {
+			return true
+		}
+	}
+
+	return false
+}
+
+// instance returns the Env table and the class of ud if it was created by
+// Class.new, or nils otherwise.
+func instance(ud *glua.LUserData) (*glua.LTable, *glua.LTable) {
+	if ud.Env == nil {
+		return nil, nil
+	}
+	cls, ok := ud.Env.RawGetH(instanceKey).(*glua.LTable)
+	if !ok {
+		return nil, nil
+	}
+
+	return ud.Env, cls
+}
+
+// classOf returns the class table of the Go value held by ud. Instances created
+// through a class keep it in their Env table, along with the fields set from
+// Lua. Other values use the class their type was registered with, if any.
+func (e *Engine) classOf(ud *glua.LUserData) *glua.LTable {
+	if _, cls := instance(ud); cls != nil {
+		return cls
+	}
+	t := reflect.TypeOf(ud.Value)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if info := e.root().types[t]; info != nil {
+		return info.class
+	}
+
+	return nil
+}
+
+// classMember returns the value of key set on the instance ud or defined by
+// its class or any of the classes it extends.
+func (e *Engine) classMember(ud *glua.LUserData, key string) glua.LValue {
+	if classKeys[key] {
+		return glua.LNil
+	}
+	if env, _ := instance(ud); env != nil {
+		if lv := env.RawGetH(glua.LString(key)); lv != glua.LNil {
+			return lv
+		}
+	}
+	for c := e.classOf(ud); c != nil; c = e.root().classes[c].parent {
+		if lv := c.RawGetH(glua.LString(key)); lv != glua.LNil {
+			return lv
+		}
+	}
+
+	return glua.LNil
+}
+
+// setClassField stores a field that isn't part of the Go type on an instance of
+// a class extended in Lua. It returns false if ud isn't such an instance.
+func (e *Engine) setClassField(ud *glua.LUserData, key string, lv glua.LValue) bool {
+	env, cls := instance(ud)
+	if cls == nil || e.root().classes[cls].parent == nil {
+		return false
+	}
+	env.RawSetH(glua.LString(key), lv)
+
+	return true
+}
+
+// IsInstance returns true if val holds a value created from the class
+// registered, or assigned in Lua, as the global class, or from one of its
+// subclasses.
+func (e *Engine) IsInstance(val *Value, class string) bool {
+	cls, ok := e.state.GetGlobal(class).(*glua.LTable)
+
+	return ok && e.isInstance(val.lval, cls)
+}
+
+// CallMethod calls the method of obj, a value of a registered type or a Value
+// holding one, with obj as the receiver. Methods defined by the Lua class of
+// obj take precedence over the Go ones, so overrides made by scripts extending
+// a class are called. The Lua class of an instance is kept by the Value holding
+// it, so pass that Value rather than the Go value for the overrides to apply.
+func (e *Engine) CallMethod(obj interface{}, method string, retCount int, params ...interface{}) ([]*Value, error) {
+	self := e.ValueFor(obj).lval
+	ud, ok := self.(*glua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("cannot call method %q of a %s", method, self.Type())
+	}
+	ref := reflect.ValueOf(ud.Value)
+	info := e.root().types[reflect.Indirect(ref).Type()]
+	if info == nil {
+		return nil, fmt.Errorf("cannot call method %q of unregistered type %T", method, ud.Value)
+	}
+
+	fn := e.classMember(ud, method)
+	if fn == glua.LNil {
+		fn = info.member(e.state, ref, method)
+	}
+	lfn, ok := fn.(*glua.LFunction)
+	if !ok {
+		return nil, fmt.Errorf("%s has no method %q", info.name, method)
+	}
+	if e.Secure && !lfn.IsG {
+		if env, ok := e.state.GetGlobal(e.sandbox.EnvName).(*glua.LTable); ok {
+			lfn.Env = env
+		}
+	}
+
+	args := []glua.LValue{self}
+	for _, p := range params {
+		args = append(args, e.ValueFor(p).lval)
+	}
+	err := e.state.CallByParam(glua.P{
+		Fn:      lfn,
+		NRet:    retCount,
+		Protect: true,
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	retVals := make([]*Value, retCount)
+	for i := 0; i < retCount; i++ {
+		retVals[i] = e.value(e.state.Get(i - retCount))
+	}
+	e.state.Pop(retCount)
+
+	return retVals, nil
+}
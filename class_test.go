@@ -0,0 +1,144 @@
+package lua_test
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type NPC struct {
+	Name  string
+	Level int
+}
+
+func (n *NPC) Greet() string {
+	return "hello, I'm " + n.Name
+}
+
+func (n *NPC) String() string {
+	return "NPC " + n.Name
+}
+
+func (n *NPC) LuaCompare(other interface{}) (int, error) {
+	o, ok := other.(*NPC)
+	if !ok {
+		return 0, errors.New("can only compare NPCs")
+	}
+
+	return strings.Compare(n.Name, o.Name), nil
+}
+
+var _ = Describe("RegisterClass", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		engine.RegisterClassWithCtor("NPC", NPC{}, func(name string, level int) *NPC {
+			return &NPC{Name: name, Level: level}
+		})
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should create instances with new", func() {
+		Expect(engine.LoadString(`greeting = NPC.new("bob", 1):greet()`)).To(BeNil())
+		Expect(engine.GetGlobal("greeting").AsString()).To(Equal("hello, I'm bob"))
+	})
+
+	It("should let scripts extend classes and override methods", func() {
+		Expect(engine.LoadString(`
+			Guard = NPC:extend("Guard")
+			function Guard:greet()
+				return "halt! " .. NPC.greet(self)
+			end
+			function Guard:patrol()
+				self.route = "walls"
+				return self.route
+			end
+
+			guard = Guard.new("tom", 3)
+			greeting = guard:greet()
+			route = guard:patrol()
+			npc_greeting = NPC.new("ann", 2):greet()
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("greeting").AsString()).To(Equal("halt! hello, I'm tom"))
+		Expect(engine.GetGlobal("route").AsString()).To(Equal("walls"))
+		Expect(engine.GetGlobal("npc_greeting").AsString()).To(Equal("hello, I'm ann"))
+	})
+
+	It("should call overridden methods from Go", func() {
+		Expect(engine.LoadString(`
+			Guard = NPC:extend()
+			function Guard:greet() return "halt!" end
+			guard = Guard.new("tom", 3)
+		`)).To(BeNil())
+		guard := engine.GetGlobal("guard")
+
+		ret, err := engine.CallMethod(guard, "greet", 1)
+		Expect(err).To(BeNil())
+		Expect(ret[0].AsString()).To(Equal("halt!"))
+
+		ret, err = engine.CallMethod(guard.Interface(), "greet", 1)
+		Expect(err).To(BeNil())
+		Expect(ret[0].AsString()).To(Equal("hello, I'm tom"))
+
+		_, err = engine.CallMethod(guard, "fly", 0)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should check the class of instances", func() {
+		Expect(engine.LoadString(`
+			Guard = NPC:extend()
+			guard, npc = Guard.new("tom", 3), NPC.new("ann", 2)
+			a, b, c, d = NPC:isinstance(guard), Guard:isinstance(guard), Guard:isinstance(npc), NPC:isinstance(1)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("a").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("b").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("c").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("d").AsBool()).To(BeFalse())
+		Expect(engine.IsInstance(engine.GetGlobal("npc"), "NPC")).To(BeTrue())
+		Expect(engine.IsInstance(engine.GetGlobal("npc"), "Guard")).To(BeFalse())
+	})
+
+	It("should use Go interfaces and Lua overrides for metamethods", func() {
+		Expect(engine.LoadString(`
+			a, b, c = NPC.new("ann", 1), NPC.new("ann", 5), NPC.new("bob", 3)
+			same, different, less = a == b, a == c, a < c
+			name = tostring(c)
+
+			Guard = NPC:extend()
+			function Guard:__tostring() return "Guard " .. self.name end
+			guard_name = tostring(Guard.new("tom", 3))
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("same").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("different").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("less").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("NPC bob"))
+		Expect(engine.GetGlobal("guard_name").AsString()).To(Equal("Guard tom"))
+	})
+
+	It("should not store unknown fields on base class instances", func() {
+		Expect(engine.LoadString(`NPC.new("ann", 1).route = "x"`)).ToNot(BeNil())
+	})
+	It("should not read fields or methods of values from the globals", func() {
+		engine.SetGlobal("hero", &NPC{Name: "bob", Level: 1})
+		Expect(engine.LoadString(`
+			name = "global-name"
+			function greet() return "global greet" end
+			field, greeting, printer = hero.name, hero:greet(), hero.print
+			npc = NPC.new("ann", 2)
+			npc_field, npc_greeting = npc.name, npc:greet()
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("field").AsString()).To(Equal("bob"))
+		Expect(engine.GetGlobal("greeting").AsString()).To(Equal("hello, I'm bob"))
+		Expect(engine.GetGlobal("printer").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("npc_field").AsString()).To(Equal("ann"))
+		Expect(engine.GetGlobal("npc_greeting").AsString()).To(Equal("hello, I'm ann"))
+	})
+})
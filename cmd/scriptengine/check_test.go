@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("check", func() {
+	var (
+		dir            string
+		stdout, stderr *bytes.Buffer
+	)
+
+	write := func(name, src string) string {
+		fn := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(fn, []byte(src), 0644)).To(BeNil())
+
+		return fn
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "check")
+		Expect(err).To(BeNil())
+		stdout, stderr = new(bytes.Buffer), new(bytes.Buffer)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should pass clean scripts", func() {
+		fn := write("ok.lua", "local x = 1\nprint(x)\n")
+		Expect(check([]string{fn}, stdout, stderr)).To(Equal(0))
+		Expect(stdout.String()).To(BeEmpty())
+		Expect(stderr.String()).To(BeEmpty())
+	})
+
+	It("should only fail on errors", func() {
+		fn := write("warn.lua", "local unused = 1\n")
+		Expect(check([]string{fn}, stdout, stderr)).To(Equal(0))
+		Expect(stdout.String()).To(Equal(fn + ":1: local unused is never used (unused-local)\n"))
+
+		fn = write("err.lua", "print(missing)\n")
+		Expect(check([]string{fn}, stdout, stderr)).To(Equal(1))
+		Expect(stdout.String()).To(ContainSubstring(fn + ":1: "))
+		Expect(stdout.String()).To(ContainSubstring("(undefined-global)\n"))
+	})
+
+	It("should apply the flags", func() {
+		fn := write("npc.lua", "greet(os.exit)\n")
+		Expect(check([]string{fn}, stdout, stderr)).To(Equal(1))
+
+		stdout.Reset()
+		Expect(check([]string{"-globals", "greet", fn}, stdout, stderr)).To(Equal(0))
+		Expect(stdout.String()).To(BeEmpty())
+
+		Expect(check([]string{"-secure", "-globals", "greet", fn}, stdout, stderr)).To(Equal(1))
+		Expect(stdout.String()).To(ContainSubstring("(denied-call)"))
+	})
+
+	It("should report files that can't be read or parsed on their own line", func() {
+		missing := filepath.Join(dir, "missing.lua")
+		broken := write("broken.lua", "function (\n")
+		Expect(check([]string{missing, broken}, stdout, stderr)).To(Equal(1))
+		lines := bytes.Split(bytes.TrimSuffix(stderr.Bytes(), []byte("\n")), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+		Expect(string(lines[0])).To(ContainSubstring("missing.lua"))
+		Expect(stderr.String()).To(HaveSuffix("\n"))
+	})
+})
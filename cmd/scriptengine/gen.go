@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	gofmt "go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exportDirective marks the Go functions and struct types gen exposes to Lua,
+// optionally followed by the name to use in Lua.
+const exportDirective = "//lua:export"
+
+// generatedHeader starts every file written by gen.
+const generatedHeader = `Code generated by "scriptengine gen"; DO NOT EDIT.`
+
+// enginePath is the import path of the script engine, always imported as lua
+// by the generated code.
+const enginePath = "github.com/seer-server/script-engine"
+
+// gen reads the Go package in a directory and writes registration code for the
+// functions and types marked with //lua:export, along with a Lua stub file
+// describing them for editors. It's meant to be run by go generate:
+//
+//	//go:generate scriptengine gen -module world
+//
+// The exit code is 2 if the package couldn't be read or written.
+func gen(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	flags.SetOutput(stderr)
+	module := flags.String("module", "", "name of the Lua module holding the functions (default package name)")
+	output := flags.String("o", "lua_export.go", "file to write the registration code to")
+	stub := flags.String("stub", "", "file to write the Lua stubs to (default module.lua)")
+	funcName := flags.String("func", "RegisterLua", "name of the generated registration function")
+	flags.Parse(args)
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	pkg, err := readExports(dir, *output)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *module == "" {
+		*module = pkg.name
+	}
+	if *stub == "" {
+		*stub = *module + ".lua"
+	}
+
+	src, err := pkg.registration(*module, *funcName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, *output), src, 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, *stub), pkg.stubs(*module), 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	return 0
+}
+
+// exportedPackage holds the declarations of a Go package exported to Lua.
+type exportedPackage struct {
+	name    string
+	funcs   []*exportedFunc
+	classes []*exportedClass
+	// types are the type declarations of the package by name
+	types map[string]*ast.TypeSpec
+	// imports are the packages the exported declarations refer to, by the
+	// name they're referred to with
+	imports map[string]string
+}
+
+// exportedFunc is a function of the module.
+type exportedFunc struct {
+	name string
+	decl *ast.FuncDecl
+}
+
+// exportedClass is a struct type registered as a class.
+type exportedClass struct {
+	name    string
+	spec    *ast.TypeSpec
+	doc     *ast.CommentGroup
+	ctor    *ast.FuncDecl
+	methods []*ast.FuncDecl
+}
+
+// readExports parses the Go files in dir, other than tests and the generated
+// output, and collects the exported declarations.
+func readExports(dir, output string) (*exportedPackage, error) {
+	fset := token.NewFileSet()
+	filter := func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != output
+	}
+	pkgs, err := parser.ParseDir(fset, dir, filter, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s: expected one package, found %d", dir, len(pkgs))
+	}
+
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	fileNames := make([]string, 0, len(pkg.Files))
+	for fn := range pkg.Files {
+		fileNames = append(fileNames, fn)
+	}
+	sort.Strings(fileNames)
+
+	exp := &exportedPackage{
+		name:    pkg.Name,
+		types:   make(map[string]*ast.TypeSpec),
+		imports: make(map[string]string),
+	}
+	ctors := make(map[string]*ast.FuncDecl)
+	methods := make(map[string][]*ast.FuncDecl)
+	for _, fn := range fileNames {
+		file := pkg.Files[fn]
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if !decl.Name.IsExported() {
+					continue
+				}
+				if decl.Recv != nil {
+					if recv := receiverName(decl); recv != "" {
+						methods[recv] = append(methods[recv], decl)
+					}
+					continue
+				}
+				if strings.HasPrefix(decl.Name.Name, "New") {
+					ctors[decl.Name.Name[3:]] = decl
+				}
+				if name, ok := exportName(decl.Doc, lowerFirst(decl.Name.Name)); ok {
+					if decl.Type.TypeParams != nil {
+						return nil, fmt.Errorf("%s: cannot export generic function %s", fset.Position(decl.Pos()), decl.Name.Name)
+					}
+					exp.funcs = append(exp.funcs, &exportedFunc{name: name, decl: decl})
+					exp.addImports(file, decl.Type)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					exp.types[ts.Name.Name] = ts
+					doc := ts.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					name, ok := exportName(doc, ts.Name.Name)
+					if !ok {
+						continue
+					}
+					if _, isStruct := ts.Type.(*ast.StructType); !isStruct || ts.TypeParams != nil {
+						return nil, fmt.Errorf("%s: can only export non-generic struct types, not %s", fset.Position(ts.Pos()), ts.Name.Name)
+					}
+					exp.classes = append(exp.classes, &exportedClass{name: name, spec: ts, doc: doc})
+				}
+			}
+		}
+	}
+
+	for _, c := range exp.classes {
+		c.methods = methods[c.spec.Name.Name]
+		if ctor := ctors[c.spec.Name.Name]; ctor != nil && returnsType(ctor, c.spec.Name.Name) {
+			c.ctor = ctor
+		}
+	}
+
+	return exp, nil
+}
+
+// exportName returns the Lua name given with the export directive in doc, or
+// name if the directive doesn't give one. It returns false if there's no
+// directive.
+func exportName(doc *ast.CommentGroup, name string) (string, bool) {
+	if doc == nil {
+		return "", false
+	}
+	for _, c := range doc.List {
+		fields := strings.Fields(c.Text)
+		if len(fields) == 0 || fields[0] != exportDirective {
+			continue
+		}
+		if len(fields) > 1 {
+			return fields[1], true
+		}
+
+		return name, true
+	}
+
+	return "", false
+}
+
+// receiverName returns the name of the type of the receiver of decl.
+func receiverName(decl *ast.FuncDecl) string {
+	typ := decl.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if id, ok := typ.(*ast.Ident); ok {
+		return id.Name
+	}
+
+	return ""
+}
+
+// returnsType returns true if decl returns a single value of type name, or a
+// pointer to it, optionally followed by an error.
+func returnsType(decl *ast.FuncDecl, name string) bool {
+	results := fieldTypes(decl.Type.Results)
+	if len(results) == 2 && isIdent(results[1], "error") {
+		results = results[:1]
+	}
+	if len(results) != 1 {
+		return false
+	}
+	typ := results[0]
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+
+	return isIdent(typ, name)
+}
+
+// addImports records the imports of file used by the types in node.
+func (exp *exportedPackage) addImports(file *ast.File, node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, imp := range file.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			if name == id.Name {
+				exp.imports[name] = path
+			}
+		}
+
+		return false
+	})
+}
+
+// registration returns the Go source of the function registering the classes
+// and the module.
+func (exp *exportedPackage) registration(module, funcName string) ([]byte, error) {
+	var body bytes.Buffer
+	for _, c := range exp.classes {
+		if c.ctor != nil {
+			fmt.Fprintf(&body, "\te.RegisterClassWithCtor(%q, %s{}, %s)\n", c.name, c.spec.Name.Name, c.ctor.Name.Name)
+		} else {
+			fmt.Fprintf(&body, "\te.RegisterClass(%q, %s{})\n", c.name, c.spec.Name.Name)
+		}
+	}
+	if len(exp.classes) > 0 {
+		body.WriteString("\n")
+	}
+	fmt.Fprintf(&body, "\treturn e.RegisterModule(%q, map[string]interface{}{\n", module)
+	for _, f := range exp.funcs {
+		fmt.Fprintf(&body, "\t\t%q: %s,\n", f.name, f.binding())
+	}
+	body.WriteString("\t})\n")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// %s\n\npackage %s\n\nimport (\n", generatedHeader, exp.name)
+	// only the wrappers of functions refer to other packages
+	names := make([]string, 0, len(exp.imports))
+	for name, path := range exp.imports {
+		if path != enginePath && strings.Contains(body.String(), name+".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := exp.imports[name]
+		if strings.HasSuffix("/"+path, "/"+name) {
+			fmt.Fprintf(&buf, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(&buf, "\t%s %q\n", name, path)
+		}
+	}
+	fmt.Fprintf(&buf, "\n\tlua %q\n)\n\n", enginePath)
+	fmt.Fprintf(&buf, "// %s registers the classes of package %s exported to Lua with e, and its\n", funcName, exp.name)
+	fmt.Fprintf(&buf, "// functions as the module %q.\n", module)
+	fmt.Fprintf(&buf, "func %s(e *lua.Engine) *lua.Value {\n%s}\n", funcName, body.String())
+
+	return gofmt.Source(buf.Bytes())
+}
+
+// binding returns the expression for the Lua function of f. Functions taking
+// up to three arguments are bound with lua.Func, wrapping them to return an
+// error if needed, the others are left to gopher-luar's reflection.
+func (f *exportedFunc) binding() string {
+	typ := f.decl.Type
+	name := f.decl.Name.Name
+	params := fieldTypes(typ.Params)
+	results := fieldTypes(typ.Results)
+	if len(params) > 3 || isScriptFunction(typ) {
+		return name
+	}
+	for _, p := range params {
+		if _, ok := p.(*ast.Ellipsis); ok {
+			return name
+		}
+	}
+
+	bind := fmt.Sprintf("lua.Func%d(e, ", len(params))
+	switch {
+	case len(results) == 2 && isIdent(results[1], "error"):
+		return bind + name + ")"
+	case len(results) > 1:
+		return name
+	}
+
+	var args, sig []string
+	for i, p := range params {
+		args = append(args, fmt.Sprintf("a%d", i))
+		sig = append(sig, fmt.Sprintf("a%d %s", i, exprString(p)))
+	}
+	call := fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+	var wrapper string
+	switch {
+	case len(results) == 0:
+		wrapper = fmt.Sprintf("func(%s) (*lua.Value, error) { %s; return lua.Nil, nil }", strings.Join(sig, ", "), call)
+	case isIdent(results[0], "error"):
+		wrapper = fmt.Sprintf("func(%s) (*lua.Value, error) { return lua.Nil, %s }", strings.Join(sig, ", "), call)
+	default:
+		wrapper = fmt.Sprintf("func(%s) (%s, error) { return %s, nil }", strings.Join(sig, ", "), exprString(results[0]), call)
+	}
+
+	return bind + wrapper + ")"
+}
+
+// isScriptFunction returns true for functions with the signature of a
+// lua.ScriptFunction, which RegisterModule handles itself.
+func isScriptFunction(typ *ast.FuncType) bool {
+	params, results := fieldTypes(typ.Params), fieldTypes(typ.Results)
+	if len(params) != 1 || len(results) != 1 || !isIdent(results[0], "int") {
+		return false
+	}
+	star, ok := params[0].(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+
+	return ok && sel.Sel.Name == "Engine"
+}
+
+// stubs returns the Lua stub file describing the classes and the module with
+// EmmyLua annotations.
+func (exp *exportedPackage) stubs(module string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "---@meta\n-- %s\n", generatedHeader)
+
+	for _, c := range exp.classes {
+		buf.WriteString("\n")
+		writeDoc(&buf, c.doc)
+		fmt.Fprintf(&buf, "---@class %s\n", c.name)
+		for _, f := range c.spec.Type.(*ast.StructType).Fields.List {
+			for _, name := range fieldNames(f) {
+				fmt.Fprintf(&buf, "---@field %s %s\n", name, exp.luaType(f.Type))
+			}
+		}
+		fmt.Fprintf(&buf, "%s = {}\n", c.name)
+
+		if c.ctor != nil {
+			buf.WriteString("\n")
+			exp.writeFunc(&buf, c.ctor, c.name+".new")
+		} else {
+			fmt.Fprintf(&buf, "\n---@return %s\nfunction %s.new() end\n", c.name, c.name)
+		}
+		for _, m := range c.methods {
+			buf.WriteString("\n")
+			exp.writeFunc(&buf, m, c.name+":"+lowerFirst(m.Name.Name))
+		}
+	}
+
+	local := module
+	if !isLuaName(local) {
+		local = "M"
+	}
+	fmt.Fprintf(&buf, "\n---@class %s\nlocal %s = {}\n", module, local)
+	for _, f := range exp.funcs {
+		buf.WriteString("\n")
+		name := local + "." + f.name
+		if !isLuaName(f.name) {
+			name = fmt.Sprintf("%s[%q]", local, f.name)
+		}
+		exp.writeFunc(&buf, f.decl, name)
+	}
+	fmt.Fprintf(&buf, "\nreturn %s\n", local)
+
+	return buf.Bytes()
+}
+
+// writeFunc writes the annotated stub of the Go function decl as the Lua
+// function name.
+func (exp *exportedPackage) writeFunc(buf *bytes.Buffer, decl *ast.FuncDecl, name string) {
+	writeDoc(buf, decl.Doc)
+
+	var params []string
+	if isScriptFunction(decl.Type) {
+		buf.WriteString("---@param ... any\n")
+		params = append(params, "...")
+	} else if decl.Type.Params != nil {
+		for i, f := range decl.Type.Params.List {
+			names := []string{fmt.Sprintf("arg%d", i+1)}
+			if len(f.Names) > 0 {
+				names = nil
+				for _, n := range f.Names {
+					names = append(names, n.Name)
+				}
+			}
+			for _, n := range names {
+				if ell, ok := f.Type.(*ast.Ellipsis); ok {
+					fmt.Fprintf(buf, "---@param ... %s\n", exp.luaType(ell.Elt))
+					params = append(params, "...")
+					continue
+				}
+				fmt.Fprintf(buf, "---@param %s %s\n", n, exp.luaType(f.Type))
+				params = append(params, n)
+			}
+		}
+	}
+	if !isScriptFunction(decl.Type) {
+		for _, r := range fieldTypes(decl.Type.Results) {
+			if !isIdent(r, "error") {
+				fmt.Fprintf(buf, "---@return %s\n", exp.luaType(r))
+			}
+		}
+	}
+	fmt.Fprintf(buf, "function %s(%s) end\n", name, strings.Join(params, ", "))
+}
+
+// writeDoc writes doc as Lua annotation comments.
+func writeDoc(buf *bytes.Buffer, doc *ast.CommentGroup) {
+	text := strings.TrimSpace(doc.Text())
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(buf, "---%s\n", line)
+	}
+}
+
+// luaType returns the EmmyLua type for the Go type expression expr.
+func (exp *exportedPackage) luaType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "bool":
+			return "boolean"
+		case "string":
+			return "string"
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "rune":
+			return "integer"
+		case "float32", "float64":
+			return "number"
+		}
+		for _, c := range exp.classes {
+			if c.spec.Name.Name == t.Name {
+				return c.name
+			}
+		}
+		if ts, ok := exp.types[t.Name]; ok {
+			if _, basic := ts.Type.(*ast.Ident); basic {
+				return exp.luaType(ts.Type)
+			}
+		}
+	case *ast.StarExpr:
+		return exp.luaType(t.X)
+	case *ast.ArrayType:
+		return exp.luaType(t.Elt) + "[]"
+	case *ast.MapType:
+		return fmt.Sprintf("table<%s, %s>", exp.luaType(t.Key), exp.luaType(t.Value))
+	case *ast.FuncType:
+		return "function"
+	}
+
+	return "any"
+}
+
+// fieldTypes returns the type of every parameter or result in fields.
+func fieldTypes(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, f := range fields.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, f.Type)
+		}
+	}
+
+	return types
+}
+
+// fieldNames returns the Lua names of the struct field f, honouring its lua
+// tag. Unexported fields have none.
+func fieldNames(f *ast.Field) []string {
+	if f.Tag != nil {
+		tag, _ := strconv.Unquote(f.Tag.Value)
+		switch name := strings.Split(reflect.StructTag(tag).Get("lua"), ",")[0]; name {
+		case "-":
+			return nil
+		case "":
+		default:
+			return []string{name}
+		}
+	}
+
+	var names []string
+	for _, n := range f.Names {
+		if n.IsExported() {
+			names = append(names, lowerFirst(n.Name))
+		}
+	}
+
+	return names
+}
+
+// isIdent returns true if expr is the identifier name.
+func isIdent(expr ast.Expr, name string) bool {
+	id, ok := expr.(*ast.Ident)
+
+	return ok && id.Name == name
+}
+
+// exprString returns the Go source of expr.
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), expr)
+
+	return buf.String()
+}
+
+// lowerFirst returns name with a lower case first letter.
+func lowerFirst(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+
+	return string(r)
+}
+
+// isLuaName returns true if s can be used as a Lua name.
+func isLuaName(s string) bool {
+	for i, r := range s {
+		if r != '_' && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return false
+		}
+	}
+
+	return s != ""
+}
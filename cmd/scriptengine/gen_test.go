@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var update = flag.Bool("update", false, "update the golden files of gen")
+
+var _ = Describe("gen", func() {
+	var (
+		dir    string
+		stderr *bytes.Buffer
+	)
+
+	write := func(name, src string) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644)).To(BeNil())
+	}
+
+	// golden compares the file gen wrote to dir with the one in testdata.
+	golden := func(name, goldenName string) {
+		out, err := ioutil.ReadFile(filepath.Join(dir, name))
+		Expect(err).To(BeNil())
+		fn := filepath.Join("testdata", "gen", goldenName)
+		if *update {
+			Expect(ioutil.WriteFile(fn, out, 0644)).To(BeNil())
+		}
+		want, err := ioutil.ReadFile(fn)
+		Expect(err).To(BeNil())
+		Expect(string(out)).To(Equal(string(want)))
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gen")
+		Expect(err).To(BeNil())
+		stderr = new(bytes.Buffer)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should write the registration code and the Lua stubs", func() {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", "gen", "world", "world.go"))
+		Expect(err).To(BeNil())
+		write("world.go", string(src))
+		write("world_test.go", "package world\n\n//lua:export\nfunc Ignored() {}\n")
+
+		Expect(gen([]string{dir}, stderr)).To(Equal(0))
+		Expect(stderr.String()).To(BeEmpty())
+		golden("lua_export.go", "lua_export.go.golden")
+		golden("world.lua", "world.lua.golden")
+	})
+
+	It("should apply the flags", func() {
+		write("world.go", "package world\n\n//lua:export\nfunc Ping() string { return \"pong\" }\n")
+
+		Expect(gen([]string{"-module", "game.world", "-o", "export.go", "-stub", "stubs.lua", "-func", "Register", dir}, stderr)).To(Equal(0))
+		out, err := ioutil.ReadFile(filepath.Join(dir, "export.go"))
+		Expect(err).To(BeNil())
+		Expect(string(out)).To(ContainSubstring("func Register(e *lua.Engine) *lua.Value {"))
+		Expect(string(out)).To(ContainSubstring(`e.RegisterModule("game.world"`))
+		out, err = ioutil.ReadFile(filepath.Join(dir, "stubs.lua"))
+		Expect(err).To(BeNil())
+		Expect(string(out)).To(ContainSubstring("local M = {}"))
+
+		// the previous output isn't read back as part of the package
+		Expect(gen([]string{"-o", "export.go", dir}, stderr)).To(Equal(0))
+	})
+
+	It("should reject declarations it can't export", func() {
+		write("world.go", "package world\n\n//lua:export\nfunc First[T any](s []T) T { return s[0] }\n")
+		Expect(gen([]string{dir}, stderr)).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("cannot export generic function First"))
+
+		stderr.Reset()
+		write("world.go", "package world\n\n//lua:export\ntype Names []string\n")
+		Expect(gen([]string{dir}, stderr)).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("can only export non-generic struct types, not Names"))
+
+		stderr.Reset()
+		write("other.go", "package other\n")
+		Expect(gen([]string{dir}, stderr)).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("expected one package, found 2"))
+	})
+})
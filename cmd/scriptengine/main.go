@@ -0,0 +1,192 @@
+// Command scriptengine provides tooling for Lua scripts run by the script
+// engine.
+//
+// Usage:
+//
+//	scriptengine check [-secure] [-globals name,...] [-strict] file...
+//	scriptengine fmt [-l] [-w] [path...]
+//	scriptengine gen [-module name] [-o file] [-stub file] [-func name] [dir]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/seer-server/script-engine"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var code int
+	switch os.Args[1] {
+	case "check":
+		code = check(os.Args[2:], os.Stdout, os.Stderr)
+	case "fmt":
+		code = format(os.Args[2:])
+	case "gen":
+		code = gen(os.Args[2:], os.Stderr)
+	default:
+		usage()
+	}
+	os.Exit(code)
+}
+
+// usage prints the available subcommands and exits.
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: scriptengine check [flags] file...")
+	fmt.Fprintln(os.Stderr, "       scriptengine fmt [flags] [path...]")
+	fmt.Fprintln(os.Stderr, "       scriptengine gen [flags] [dir]")
+	os.Exit(2)
+}
+
+// check analyzes each file, printing diagnostics to stdout and failures to
+// stderr. The exit code is 1 if any errors were found.
+func check(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.SetOutput(stderr)
+	secure := flags.Bool("secure", false, "check against the default sandbox")
+	globals := flags.String("globals", "", "comma separated list of additional known globals")
+	strict := flags.Bool("strict", false, "report assignments to undeclared globals")
+	flags.Parse(args)
+
+	var (
+		engine *lua.Engine
+		err    error
+	)
+	if *secure {
+		engine, err = lua.NewSecureEngine()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	} else {
+		engine = lua.NewEngine()
+	}
+	defer engine.Close()
+
+	opts := engine.AnalyzeOptions()
+	if *globals != "" {
+		opts.Globals = append(opts.Globals, strings.Split(*globals, ",")...)
+	}
+	opts.ReportGlobalWrites = *strict
+
+	code := 0
+	for _, fn := range flags.Args() {
+		src, err := ioutil.ReadFile(fn)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 1
+			continue
+		}
+
+		opts.Source = fn
+		diags, err := lua.Analyze(string(src), opts)
+		if err != nil {
+			fmt.Fprintln(stderr, strings.TrimSpace(err.Error()))
+			code = 1
+			continue
+		}
+		for _, d := range diags {
+			fmt.Fprintln(stdout, d)
+			if d.Kind.IsError() {
+				code = 1
+			}
+		}
+	}
+
+	return code
+}
+
+// format formats the given files, and the .lua files found in the given
+// directories, like gofmt. Without paths standard input is formatted to
+// standard output. The exit code is 2 if any file failed to format.
+func format(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	write := flags.Bool("w", false, "write result to the source file instead of standard output")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "cannot use -w with standard input")
+			return 2
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err == nil {
+			err = formatFile("<standard input>", src, os.Stdout, *list, false)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		return 0
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		err := filepath.Walk(path, func(fn string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// directories are searched for Lua files, explicit files are
+			// always formatted
+			if info.IsDir() || fn != path && filepath.Ext(fn) != ".lua" {
+				return nil
+			}
+			src, err := ioutil.ReadFile(fn)
+			if err == nil {
+				err = formatFile(fn, src, os.Stdout, *list, *write)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 2
+			}
+
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 2
+		}
+	}
+
+	return code
+}
+
+// formatFile formats src, read from fn. The file name is printed if list is set
+// and the formatting differs, the file is rewritten if write is set and the
+// formatted source is printed otherwise.
+func formatFile(fn string, src []byte, out *os.File, list, write bool) error {
+	res, err := lua.Format(src)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fn, strings.TrimSpace(err.Error()))
+	}
+
+	changed := !bytes.Equal(src, res)
+	if list && changed {
+		fmt.Fprintln(out, fn)
+	}
+	if write && changed {
+		info, err := os.Stat(fn)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(fn, res, info.Mode().Perm())
+	}
+	if !list && !write {
+		_, err = out.Write(res)
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package world
+
+import (
+	"time"
+
+	lua "github.com/seer-server/script-engine"
+)
+
+// NPC is a character of the world.
+//
+//lua:export
+type NPC struct {
+	Name     string
+	Level    int    `lua:"lvl"`
+	Password string `lua:"-"`
+	mood     string
+}
+
+// NewNPC creates an NPC.
+func NewNPC(name string, level int) *NPC {
+	return &NPC{Name: name, Level: level}
+}
+
+// Greet returns the greeting of the NPC.
+func (n *NPC) Greet() string {
+	return "hello, I'm " + n.Name
+}
+
+// Ping takes no arguments.
+//
+//lua:export
+func Ping() string {
+	return "pong"
+}
+
+// Double takes one argument.
+//
+//lua:export
+func Double(n int) int {
+	return n * 2
+}
+
+// Move takes two arguments and can fail.
+//
+//lua:export
+func Move(from, to string) (bool, error) {
+	return from != to, nil
+}
+
+// Spawn takes three arguments and returns nothing.
+//
+//lua:export
+func Spawn(name string, x, y float64) {}
+
+// Save only returns an error.
+//
+//lua:export
+func Save(path string) error {
+	return nil
+}
+
+// Wait uses types of another package.
+//
+//lua:export
+func Wait(d time.Duration) time.Time {
+	return time.Now().Add(d)
+}
+
+// Teleport takes too many arguments for lua.Func3.
+//
+//lua:export
+func Teleport(name string, x, y, z float64) bool {
+	return true
+}
+
+// Sum is variadic.
+//
+//lua:export
+func Sum(ns ...int) int {
+	return len(ns)
+}
+
+// Split returns several values.
+//
+//lua:export
+func Split(s string) (string, string) {
+	return s, s
+}
+
+// Raw is a ScriptFunction.
+//
+//lua:export
+func Raw(e *lua.Engine) int {
+	return 0
+}
+
+// IsDay is exported with another name.
+//
+//lua:export is-day
+func IsDay() bool {
+	return true
+}
+
+// Hidden isn't exported.
+func Hidden() {}
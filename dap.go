@@ -0,0 +1,528 @@
+package lua
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// dapThreadID is the only thread reported to Debug Adapter Protocol clients,
+// an Engine runs Lua code on a single goroutine.
+const dapThreadID = 1
+
+// maxDAPMessage is the largest Content-Length accepted from a client.
+const maxDAPMessage = 4 << 20
+
+// ErrNotLocal is returned by ListenDAP when asked to listen on an address that
+// isn't a loopback address.
+var ErrNotLocal = errors.New("debug adapter must listen on a loopback address")
+
+// dapMessage covers the fields of the protocol's requests, responses and
+// events that are used here.
+type dapMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+// dapVariable is a variable as reported in a variables response.
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapSession is a single client connected to a Debugger.
+type dapSession struct {
+	debugger *Debugger
+	conn     io.ReadWriter
+
+	mu      sync.Mutex
+	seq     int
+	refs    map[int]func() ([]Variable, error)
+	nextRef int
+
+	// sources and funcs are the breakpoints set by the client, removed when
+	// the session ends.
+	sources map[string]struct{}
+	funcs   []string
+}
+
+// ListenDAP accepts Debug Adapter Protocol clients on addr, which must be a
+// loopback address such as "127.0.0.1:4711". Clients are served in their own
+// goroutines until the returned listener is closed.
+func (d *Debugger) ListenDAP(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, ErrNotLocal
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				d.ServeDAP(conn)
+			}()
+		}
+	}()
+
+	return ln, nil
+}
+
+// ServeDAP speaks the Debug Adapter Protocol over conn until the client
+// disconnects or the connection fails. However the session ends, the
+// breakpoints the client set are removed and a paused Engine is resumed.
+func (d *Debugger) ServeDAP(conn io.ReadWriter) error {
+	s := &dapSession{
+		debugger: d,
+		conn:     conn,
+		refs:     make(map[int]func() ([]Variable, error)),
+		sources:  make(map[string]struct{}),
+	}
+	defer s.end()
+	unregister := d.OnStop(func(ev StopEvent) {
+		s.resetRefs()
+		s.event("stopped", map[string]interface{}{
+			"reason":            dapStopReason(ev.Reason),
+			"threadId":          dapThreadID,
+			"allThreadsStopped": true,
+		})
+	})
+	defer unregister()
+
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readDAPMessage(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		if done := s.handle(msg); done {
+			return nil
+		}
+	}
+}
+
+// end removes the breakpoints set by the client and resumes the Engine, so it
+// doesn't stop again with no client attached.
+func (s *dapSession) end() {
+	d := s.debugger
+	for source := range s.sources {
+		d.ClearBreakpoints(source)
+	}
+	for _, name := range s.funcs {
+		d.ClearFunctionBreakpoint(name)
+	}
+	d.mu.Lock()
+	d.pauseReq = false
+	d.mu.Unlock()
+	d.Continue()
+}
+
+// handle answers a single request, returning true when the client has
+// disconnected.
+func (s *dapSession) handle(req *dapMessage) bool {
+	d := s.debugger
+	var (
+		body interface{}
+		err  error
+	)
+
+	switch req.Command {
+	case "initialize":
+		body = map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsEvaluateForHovers":        true,
+		}
+		s.respond(req, body, nil)
+		s.event("initialized", nil)
+
+		return false
+	case "launch", "attach", "configurationDone":
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "setFunctionBreakpoints":
+		body, err = s.setFunctionBreakpoints(req.Arguments)
+	case "threads":
+		body = map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": "lua"}},
+		}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body, err = s.scopes(req.Arguments)
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "evaluate":
+		body, err = s.evaluate(req.Arguments)
+	case "continue":
+		err = d.Continue()
+		body = map[string]interface{}{"allThreadsContinued": true}
+	case "next":
+		err = d.StepOver()
+	case "stepIn":
+		err = d.StepIn()
+	case "stepOut":
+		err = d.StepOut()
+	case "pause":
+		d.Pause()
+	case "disconnect":
+		s.respond(req, nil, nil)
+
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+	s.respond(req, body, err)
+
+	return false
+}
+
+// setBreakpoints replaces the line breakpoints of a source.
+func (s *dapSession) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	source := args.Source.Path
+	if source == "" {
+		source = args.Source.Name
+	}
+	s.debugger.ClearBreakpoints(source)
+	s.sources[source] = struct{}{}
+	bps := make([]map[string]interface{}, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		s.debugger.SetBreakpoint(source, bp.Line)
+		bps[i] = map[string]interface{}{"verified": true, "line": bp.Line}
+	}
+
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+// setFunctionBreakpoints replaces all function breakpoints.
+func (s *dapSession) setFunctionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			Name string `json:"name"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	d := s.debugger
+	d.ClearFunctionBreakpoints()
+	s.funcs = s.funcs[:0]
+	bps := make([]map[string]interface{}, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		d.SetFunctionBreakpoint(bp.Name)
+		s.funcs = append(s.funcs, bp.Name)
+		bps[i] = map[string]interface{}{"verified": true}
+	}
+
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+// stackTrace reports the stack of the paused Engine, frame ids are the stack
+// level plus one as the protocol reserves zero.
+func (s *dapSession) stackTrace() (interface{}, error) {
+	frames, err := s.debugger.Stack()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]map[string]interface{}, len(frames))
+	for i, f := range frames {
+		out[i] = map[string]interface{}{
+			"id":     f.Level + 1,
+			"name":   f.Function,
+			"line":   f.Line,
+			"column": 1,
+			"source": map[string]interface{}{
+				"name": f.Source,
+				"path": f.Source,
+			},
+		}
+	}
+
+	return map[string]interface{}{"stackFrames": out, "totalFrames": len(out)}, nil
+}
+
+// scopes reports the locals, upvalues and globals of a frame.
+func (s *dapSession) scopes(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	d := s.debugger
+	level := args.FrameID - 1
+	scope := func(name string, fn func(int) ([]Variable, error)) map[string]interface{} {
+		ref := s.addRef(func() ([]Variable, error) { return fn(level) })
+
+		return map[string]interface{}{
+			"name":               name,
+			"variablesReference": ref,
+			"expensive":          false,
+		}
+	}
+
+	return map[string]interface{}{
+		"scopes": []map[string]interface{}{
+			scope("Locals", d.Locals),
+			scope("Upvalues", d.Upvalues),
+			scope("Globals", d.Globals),
+		},
+	}, nil
+}
+
+// variables expands a reference handed out by scopes or a previous variables
+// request.
+func (s *dapSession) variables(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	fn, ok := s.refs[args.VariablesReference]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+	vars, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]dapVariable, len(vars))
+	for i, v := range vars {
+		out[i] = s.variable(v.Name, v.Value)
+	}
+
+	return map[string]interface{}{"variables": out}, nil
+}
+
+// evaluate runs an expression in a paused frame.
+func (s *dapSession) evaluate(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	level := args.FrameID - 1
+	if level < 0 {
+		level = 0
+	}
+	values, err := s.debugger.Evaluate(level, args.Expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return map[string]interface{}{"result": "nil", "variablesReference": 0}, nil
+	}
+
+	v := s.variable("", values[0])
+	results := make([]string, len(values))
+	for i, val := range values {
+		results[i] = val.String()
+	}
+
+	return map[string]interface{}{
+		"result":             strings.Join(results, ", "),
+		"type":               v.Type,
+		"variablesReference": v.VariablesReference,
+	}, nil
+}
+
+// variable describes a value, tables get a reference so they can be expanded.
+func (s *dapSession) variable(name string, v *Value) dapVariable {
+	dv := dapVariable{
+		Name:  name,
+		Value: v.String(),
+		Type:  v.lval.Type().String(),
+	}
+	if v.IsString() {
+		dv.Value = strconv.Quote(v.AsString())
+	}
+	if tbl := v.asTable(); tbl != nil {
+		dv.VariablesReference = s.addRef(func() ([]Variable, error) {
+			var vars []Variable
+			err := s.debugger.inspect(func(*glua.LState) {
+				tbl.ForEach(func(key, val glua.LValue) {
+					vars = append(vars, Variable{Name: key.String(), Value: s.debugger.value(val)})
+				})
+			})
+
+			return vars, err
+		})
+	}
+
+	return dv
+}
+
+// addRef stores fn under a new variables reference.
+func (s *dapSession) addRef(fn func() ([]Variable, error)) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextRef++
+	s.refs[s.nextRef] = fn
+
+	return s.nextRef
+}
+
+// resetRefs drops the references of the previous stop.
+func (s *dapSession) resetRefs() {
+	s.mu.Lock()
+	s.refs = make(map[int]func() ([]Variable, error))
+	s.mu.Unlock()
+}
+
+// respond sends the response to req.
+func (s *dapSession) respond(req *dapMessage, body interface{}, err error) {
+	success := err == nil
+	msg := &dapMessage{
+		Type:       "response",
+		Command:    req.Command,
+		RequestSeq: req.Seq,
+		Success:    &success,
+		Body:       body,
+	}
+	if err != nil {
+		msg.Message = err.Error()
+	}
+	s.send(msg)
+}
+
+// event sends an event to the client.
+func (s *dapSession) event(name string, body interface{}) {
+	s.send(&dapMessage{Type: "event", Event: name, Body: body})
+}
+
+// send writes a message to the client with the next sequence number.
+func (s *dapSession) send(msg *dapMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	msg.Seq = s.seq
+
+	return writeDAPMessage(s.conn, msg)
+}
+
+// readDAPMessage reads a single Content-Length framed message.
+func readDAPMessage(r *bufio.Reader) (*dapMessage, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length < 0 {
+				continue
+			}
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				return nil, err
+			}
+			if length < 0 || length > maxDAPMessage {
+				return nil, fmt.Errorf("invalid Content-Length %d, messages must be at most %d bytes", length, maxDAPMessage)
+			}
+		}
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	msg := new(dapMessage)
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// writeDAPMessage writes a single Content-Length framed message.
+func writeDAPMessage(w io.Writer, msg *dapMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+
+	return err
+}
+
+// dapStopReason maps a StopEvent reason to one defined by the protocol.
+func dapStopReason(reason string) string {
+	switch reason {
+	case StopFunctionBreakpoint:
+		return "function breakpoint"
+	case StopStep:
+		return "step"
+	case StopPause:
+		return "pause"
+	}
+
+	return "breakpoint"
+}
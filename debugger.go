@@ -0,0 +1,642 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// ErrNotPaused is returned when inspecting or resuming a Debugger whose
+// Engine isn't stopped.
+var ErrNotPaused = errors.New("debugger is not paused")
+
+// Reasons given in a StopEvent.
+const (
+	StopBreakpoint         = "breakpoint"
+	StopFunctionBreakpoint = "function breakpoint"
+	StopStep               = "step"
+	StopPause              = "pause"
+)
+
+// stepMode describes what the debugger is waiting for after a resume.
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// StopEvent describes where and why the Engine paused.
+type StopEvent struct {
+	Reason   string
+	Function string
+	Source   string
+	Line     int
+}
+
+// StackFrame describes a function on the call stack of a paused Engine. Level
+// zero is the frame execution stopped in.
+type StackFrame struct {
+	Level    int
+	Function string
+	Source   string
+	Line     int
+}
+
+// Variable is a named value visible from a paused frame.
+type Variable struct {
+	Name  string
+	Value *Value
+}
+
+// debugCommand is sent to the goroutine running the paused Engine, either to
+// run an inspection or to resume execution.
+type debugCommand struct {
+	inspect func(*glua.LState)
+	done    chan struct{}
+	resume  stepMode
+	detach  bool
+}
+
+// pausedState holds the channels used to talk to a paused Engine.
+type pausedState struct {
+	commands chan debugCommand
+	resumed  chan struct{}
+}
+
+// Debugger pauses the Lua code running in an Engine at breakpoints and allows
+// it to be inspected and stepped through. The Engine blocks while it's paused
+// so a Debugger is driven from a goroutine other than the one running code.
+type Debugger struct {
+	engine *Engine
+	hook   *vmHook
+
+	mu          sync.Mutex
+	breakpoints map[int][]string
+	funcBreaks  map[string]struct{}
+	pauseReq    bool
+	step        stepMode
+	stepDepth   int
+	paused      *pausedState
+	listeners   map[int]func(StopEvent)
+	nextID      int
+}
+
+// AttachDebugger attaches a Debugger to the Engine, an Engine only has a single
+// Debugger so further calls return the same one until it's detached. See
+// SetHook for the goroutines it can be called from, the methods of the
+// Debugger itself can be called from any goroutine.
+func (e *Engine) AttachDebugger() *Debugger {
+	if e.debugger != nil {
+		return e.debugger
+	}
+
+	d := &Debugger{
+		engine:      e,
+		breakpoints: make(map[int][]string),
+		funcBreaks:  make(map[string]struct{}),
+		listeners:   make(map[int]func(StopEvent)),
+	}
+	d.hook = &vmHook{mask: glua.MaskCall | glua.MaskLine, fn: d.event}
+	e.debugger = d
+	e.addHook(d.hook)
+
+	return d
+}
+
+// Detach removes the Debugger from its Engine. A paused Engine removes it
+// from its own goroutine before resuming, and Detach waits for that. Otherwise
+// the Engine must not be running code on another goroutine, see SetHook.
+func (d *Debugger) Detach() {
+	d.mu.Lock()
+	d.breakpoints = make(map[int][]string)
+	d.funcBreaks = make(map[string]struct{})
+	d.pauseReq = false
+	d.step = stepNone
+	d.mu.Unlock()
+
+	if p, err := d.deliver(debugCommand{resume: stepNone, detach: true}); err == nil {
+		<-p.resumed
+
+		return
+	}
+	d.detach()
+}
+
+// detach removes the hook of the Debugger from its Engine.
+func (d *Debugger) detach() {
+	if d.engine.debugger == d {
+		d.engine.removeHook(d.hook)
+		d.engine.debugger = nil
+	}
+}
+
+// OnStop registers fn to be called every time the Engine pauses. It's called
+// from the goroutine running the Engine before it blocks. The returned function
+// unregisters fn.
+func (d *Debugger) OnStop(fn func(StopEvent)) func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.nextID
+	d.nextID++
+	d.listeners[id] = fn
+
+	return func() {
+		d.mu.Lock()
+		delete(d.listeners, id)
+		d.mu.Unlock()
+	}
+}
+
+// SetBreakpoint pauses execution when line of source is reached. The source is
+// the chunk name, paths are matched on their trailing elements so a breakpoint
+// on "/srv/scripts/npc.lua" matches a chunk loaded as "scripts/npc.lua".
+func (d *Debugger) SetBreakpoint(source string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, src := range d.breakpoints[line] {
+		if src == source {
+			return
+		}
+	}
+	d.breakpoints[line] = append(d.breakpoints[line], source)
+}
+
+// ClearBreakpoint removes a breakpoint set with SetBreakpoint.
+func (d *Debugger) ClearBreakpoint(source string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.clearBreakpoint(source, line)
+}
+
+// ClearBreakpoints removes all line breakpoints set in source.
+func (d *Debugger) ClearBreakpoints(source string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for line := range d.breakpoints {
+		d.clearBreakpoint(source, line)
+	}
+}
+
+// clearBreakpoint removes a breakpoint, the lock must be held.
+func (d *Debugger) clearBreakpoint(source string, line int) {
+	sources := d.breakpoints[line]
+	for i, src := range sources {
+		if src == source {
+			sources = append(sources[:i], sources[i+1:]...)
+			break
+		}
+	}
+	if len(sources) == 0 {
+		delete(d.breakpoints, line)
+	} else {
+		d.breakpoints[line] = sources
+	}
+}
+
+// SetFunctionBreakpoint pauses execution whenever a function called name is
+// entered.
+func (d *Debugger) SetFunctionBreakpoint(name string) {
+	d.mu.Lock()
+	d.funcBreaks[name] = struct{}{}
+	d.mu.Unlock()
+}
+
+// ClearFunctionBreakpoint removes a breakpoint set with SetFunctionBreakpoint.
+func (d *Debugger) ClearFunctionBreakpoint(name string) {
+	d.mu.Lock()
+	delete(d.funcBreaks, name)
+	d.mu.Unlock()
+}
+
+// ClearFunctionBreakpoints removes all function breakpoints.
+func (d *Debugger) ClearFunctionBreakpoints() {
+	d.mu.Lock()
+	d.funcBreaks = make(map[string]struct{})
+	d.mu.Unlock()
+}
+
+// Pause stops the Engine at the next line executed.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	d.pauseReq = true
+	d.mu.Unlock()
+}
+
+// Paused returns whether the Engine is currently stopped.
+func (d *Debugger) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.paused != nil
+}
+
+// Continue resumes execution until the next breakpoint.
+func (d *Debugger) Continue() error {
+	return d.send(debugCommand{resume: stepNone})
+}
+
+// StepIn resumes execution until the next line, entering function calls.
+func (d *Debugger) StepIn() error {
+	return d.send(debugCommand{resume: stepIn})
+}
+
+// StepOver resumes execution until the next line of the current function.
+func (d *Debugger) StepOver() error {
+	return d.send(debugCommand{resume: stepOver})
+}
+
+// StepOut resumes execution until the current function returns.
+func (d *Debugger) StepOut() error {
+	return d.send(debugCommand{resume: stepOut})
+}
+
+// Stack returns the call stack of the paused Engine.
+func (d *Debugger) Stack() ([]StackFrame, error) {
+	var frames []StackFrame
+	err := d.inspect(func(l *glua.LState) {
+		for level := 0; ; level++ {
+			dbg, ok := l.GetStack(level)
+			if !ok {
+				break
+			}
+			if _, err := l.GetInfo("nSl", dbg, glua.LNil); err != nil {
+				break
+			}
+			frames = append(frames, StackFrame{
+				Level:    level,
+				Function: frameName(l, dbg),
+				Source:   dbg.Source,
+				Line:     dbg.CurrentLine,
+			})
+		}
+	})
+
+	return frames, err
+}
+
+// Locals returns the local variables active in the frame at level.
+func (d *Debugger) Locals(level int) ([]Variable, error) {
+	var (
+		vars []Variable
+		ferr error
+	)
+	err := d.inspect(func(l *glua.LState) {
+		dbg, ok := l.GetStack(level)
+		if !ok {
+			ferr = fmt.Errorf("invalid stack level %d", level)
+			return
+		}
+		vars = frameLocals(d.engine, l, dbg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, ferr
+}
+
+// Upvalues returns the upvalues of the function running at level.
+func (d *Debugger) Upvalues(level int) ([]Variable, error) {
+	var (
+		vars []Variable
+		ferr error
+	)
+	err := d.inspect(func(l *glua.LState) {
+		fn, ok := frameFunction(l, level)
+		if !ok {
+			ferr = fmt.Errorf("invalid stack level %d", level)
+			return
+		}
+		vars = d.upvalues(l, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, ferr
+}
+
+// Globals returns the globals visible to the function running at level, for
+// secured functions these are the contents of the sandbox.
+func (d *Debugger) Globals(level int) ([]Variable, error) {
+	var (
+		vars []Variable
+		ferr error
+	)
+	err := d.inspect(func(l *glua.LState) {
+		fn, ok := frameFunction(l, level)
+		if !ok {
+			ferr = fmt.Errorf("invalid stack level %d", level)
+			return
+		}
+		fn.Env.ForEach(func(key, val glua.LValue) {
+			if name, ok := key.(glua.LString); ok {
+				vars = append(vars, Variable{Name: string(name), Value: d.value(val)})
+			}
+		})
+		sort.Sort(variablesByName(vars))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, ferr
+}
+
+// Evaluate runs the expression in the context of the frame at level, locals
+// and upvalues of the frame are visible to it. Assigning to them has no effect
+// on the running function.
+func (d *Debugger) Evaluate(level int, expr string) ([]*Value, error) {
+	var (
+		values []*Value
+		ferr   error
+	)
+	err := d.inspect(func(l *glua.LState) {
+		values, ferr = d.evaluate(l, level, expr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, ferr
+}
+
+// evaluate compiles and runs expr on the paused state.
+func (d *Debugger) evaluate(l *glua.LState, level int, expr string) ([]*Value, error) {
+	dbg, ok := l.GetStack(level)
+	if !ok {
+		return nil, fmt.Errorf("invalid stack level %d", level)
+	}
+	fn, _ := frameFunction(l, level)
+
+	env := l.NewTable()
+	for _, v := range d.upvalues(l, fn) {
+		env.RawSetH(glua.LString(v.Name), v.Value.lval)
+	}
+	for _, v := range frameLocals(d.engine, l, dbg) {
+		env.RawSetH(glua.LString(v.Name), v.Value.lval)
+	}
+	mt := l.NewTable()
+	mt.RawSetH(glua.LString("__index"), fn.Env)
+	l.SetMetatable(env, mt)
+
+	chunk, err := l.Load(strings.NewReader("return "+expr), "=(eval)")
+	if err != nil {
+		if chunk, err = l.Load(strings.NewReader(expr), "=(eval)"); err != nil {
+			return nil, err
+		}
+	}
+	l.SetFEnv(chunk, env)
+
+	top := l.GetTop()
+	l.Push(chunk)
+	if err := l.PCall(0, glua.MultRet, nil); err != nil {
+		return nil, err
+	}
+	n := l.GetTop() - top
+	values := make([]*Value, n)
+	for i := 0; i < n; i++ {
+		values[i] = d.value(l.Get(top + i + 1))
+	}
+	l.Pop(n)
+
+	return values, nil
+}
+
+// upvalues collects the upvalues of fn.
+func (d *Debugger) upvalues(l *glua.LState, fn *glua.LFunction) []Variable {
+	var vars []Variable
+	for i := 1; i <= len(fn.Upvalues); i++ {
+		name, val := l.GetUpvalue(fn, i)
+		vars = append(vars, Variable{Name: name, Value: d.value(val)})
+	}
+
+	return vars
+}
+
+// value wraps an LValue found while inspecting the Engine.
+func (d *Debugger) value(lv glua.LValue) *Value {
+	return engineValue(d.engine, lv)
+}
+
+// engineValue wraps an LValue found while inspecting e, tables are owned by e
+// so they can be changed.
+func engineValue(e *Engine, lv glua.LValue) *Value {
+	v := newValue(lv)
+	if v.isTable() {
+		v.owner = e
+	}
+
+	return v
+}
+
+// frameLocals collects the named locals of the frame described by dbg.
+func frameLocals(e *Engine, l *glua.LState, dbg *glua.Debug) []Variable {
+	var vars []Variable
+	for i := 1; ; i++ {
+		name, val := l.GetLocal(dbg, i)
+		if name == "" {
+			break
+		}
+		if strings.HasPrefix(name, "(") {
+			continue
+		}
+		vars = append(vars, Variable{Name: name, Value: engineValue(e, val)})
+	}
+
+	return vars
+}
+
+// frameFunction returns the Lua function running at level.
+func frameFunction(l *glua.LState, level int) (*glua.LFunction, bool) {
+	dbg, ok := l.GetStack(level)
+	if !ok {
+		return nil, false
+	}
+	lv, err := l.GetInfo("f", dbg, glua.LNil)
+	if err != nil {
+		return nil, false
+	}
+	fn, ok := lv.(*glua.LFunction)
+
+	return fn, ok
+}
+
+// stackDepth returns the number of frames on the call stack of the state.
+func stackDepth(l *glua.LState) int {
+	depth := 0
+	for {
+		if _, ok := l.GetStack(depth); !ok {
+			return depth
+		}
+		depth++
+	}
+}
+
+// inspect runs fn on the goroutine of the paused Engine and waits for it.
+func (d *Debugger) inspect(fn func(*glua.LState)) error {
+	cmd := debugCommand{inspect: fn, done: make(chan struct{})}
+	p, err := d.deliver(cmd)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-cmd.done:
+		return nil
+	case <-p.resumed:
+		return ErrNotPaused
+	}
+}
+
+// send delivers a command to the paused Engine.
+func (d *Debugger) send(cmd debugCommand) error {
+	_, err := d.deliver(cmd)
+
+	return err
+}
+
+// deliver hands cmd to the paused Engine, returning the pause it was given to.
+func (d *Debugger) deliver(cmd debugCommand) (*pausedState, error) {
+	d.mu.Lock()
+	p := d.paused
+	d.mu.Unlock()
+	if p == nil {
+		return nil, ErrNotPaused
+	}
+
+	select {
+	case p.commands <- cmd:
+		return p, nil
+	case <-p.resumed:
+		return nil, ErrNotPaused
+	}
+}
+
+// event is the VM hook of the debugger, it decides if execution should stop.
+func (d *Debugger) event(l *glua.LState, event glua.HookEvent, line int) {
+	var (
+		dbg  *glua.Debug
+		name string
+	)
+	info := func() *glua.Debug {
+		if dbg == nil {
+			dbg, _ = l.GetStack(0)
+			l.GetInfo("nS", dbg, glua.LNil)
+			name = frameName(l, dbg)
+		}
+
+		return dbg
+	}
+
+	d.mu.Lock()
+	reason := ""
+	switch event {
+	case glua.HookCall:
+		if len(d.funcBreaks) > 0 {
+			info()
+			if _, ok := d.funcBreaks[name]; ok {
+				reason = StopFunctionBreakpoint
+			}
+		}
+	case glua.HookLine:
+		switch {
+		case d.pauseReq:
+			reason = StopPause
+		case d.step == stepIn:
+			reason = StopStep
+		case d.step == stepOver && stackDepth(l) <= d.stepDepth:
+			reason = StopStep
+		case d.step == stepOut && stackDepth(l) < d.stepDepth:
+			reason = StopStep
+		default:
+			for _, src := range d.breakpoints[line] {
+				if sourceMatches(src, info().Source) {
+					reason = StopBreakpoint
+					break
+				}
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	if reason != "" {
+		d.stop(l, StopEvent{
+			Reason:   reason,
+			Source:   info().Source,
+			Function: name,
+			Line:     line,
+		})
+	}
+}
+
+// stop blocks the Engine, serving commands until one resumes execution.
+func (d *Debugger) stop(l *glua.LState, ev StopEvent) {
+	p := &pausedState{
+		commands: make(chan debugCommand),
+		resumed:  make(chan struct{}),
+	}
+
+	d.mu.Lock()
+	d.paused = p
+	d.pauseReq = false
+	d.step = stepNone
+	listeners := make([]func(StopEvent), 0, len(d.listeners))
+	for _, fn := range d.listeners {
+		listeners = append(listeners, fn)
+	}
+	d.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+
+	for cmd := range p.commands {
+		if cmd.inspect != nil {
+			cmd.inspect(l)
+			close(cmd.done)
+			continue
+		}
+
+		d.mu.Lock()
+		d.step = cmd.resume
+		d.stepDepth = stackDepth(l)
+		d.paused = nil
+		d.mu.Unlock()
+		if cmd.detach {
+			d.detach()
+		}
+		break
+	}
+	close(p.resumed)
+}
+
+// sourceMatches returns true if the breakpoint source refers to the chunk
+// name, either exactly or by one being a path suffix of the other.
+func sourceMatches(bp, chunk string) bool {
+	if bp == chunk {
+		return true
+	}
+	bp = strings.Replace(bp, "\\", "/", -1)
+	chunk = strings.Replace(chunk, "\\", "/", -1)
+
+	return strings.HasSuffix(bp, "/"+chunk) || strings.HasSuffix(chunk, "/"+bp)
+}
+
+// variablesByName sorts variables by their name.
+type variablesByName []Variable
+
+func (v variablesByName) Len() int           { return len(v) }
+func (v variablesByName) Less(i, j int) bool { return v[i].Name < v[j].Name }
+func (v variablesByName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
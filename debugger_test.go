@@ -0,0 +1,284 @@
+package lua_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Debugger", func() {
+	var (
+		engine   *Engine
+		debugger *Debugger
+		stops    chan StopEvent
+		done     chan error
+		running  bool
+		script   = `
+			local scale = 10
+
+			function inner(x)
+				local y = x * scale
+				return y
+			end
+
+			function outer(a)
+				local b = a + 1
+				local c = inner(b)
+				return c
+			end
+		`
+	)
+
+	run := func() {
+		running = true
+		go func() {
+			_, err := engine.Call("outer", 1, 2)
+			done <- err
+		}()
+	}
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		Expect(engine.LoadString(script)).To(BeNil())
+		debugger = engine.AttachDebugger()
+		stops = make(chan StopEvent, 10)
+		done = make(chan error, 1)
+		running = false
+		debugger.OnStop(func(ev StopEvent) {
+			stops <- ev
+		})
+	})
+
+	AfterEach(func() {
+		if running {
+			Eventually(func() bool {
+				debugger.Continue()
+				select {
+				case err := <-done:
+					Expect(err).To(BeNil())
+					return true
+				default:
+					return false
+				}
+			}).Should(BeTrue())
+		}
+		debugger.Detach()
+		engine.Close()
+	})
+
+	It("should return the same debugger while attached", func() {
+		Expect(engine.AttachDebugger()).To(BeIdenticalTo(debugger))
+	})
+
+	It("should not inspect while running", func() {
+		_, err := debugger.Stack()
+		Expect(err).To(Equal(ErrNotPaused))
+	})
+
+	Context("when stopped at a breakpoint", func() {
+		BeforeEach(func() {
+			debugger.SetBreakpoint("<string>", 11)
+			run()
+			Eventually(stops).Should(Receive(Equal(StopEvent{
+				Reason:   StopBreakpoint,
+				Function: "outer",
+				Source:   "<string>",
+				Line:     11,
+			})))
+		})
+
+		It("should report the stack", func() {
+			frames, err := debugger.Stack()
+			Expect(err).To(BeNil())
+			Expect(frames[0].Function).To(Equal("outer"))
+			Expect(frames[0].Line).To(Equal(11))
+			Expect(debugger.Continue()).To(BeNil())
+		})
+
+		It("should inspect locals", func() {
+			locals, err := debugger.Locals(0)
+			Expect(err).To(BeNil())
+			Expect(locals).To(HaveLen(2))
+			Expect(locals[0].Name).To(Equal("a"))
+			Expect(locals[1].Name).To(Equal("b"))
+			Expect(locals[1].Value.AsNumber()).To(Equal(float64(3)))
+			Expect(debugger.Continue()).To(BeNil())
+		})
+
+		It("should evaluate expressions in the frame", func() {
+			values, err := debugger.Evaluate(0, "a + b")
+			Expect(err).To(BeNil())
+			Expect(values[0].AsNumber()).To(Equal(float64(5)))
+			Expect(debugger.Continue()).To(BeNil())
+		})
+
+		It("should step into calls", func() {
+			Expect(debugger.StepIn()).To(BeNil())
+			Eventually(stops).Should(Receive(WithTransform(func(ev StopEvent) string {
+				return ev.Function
+			}, Equal("inner"))))
+
+			upvalues, err := debugger.Upvalues(0)
+			Expect(err).To(BeNil())
+			Expect(upvalues[0].Name).To(Equal("scale"))
+
+			Expect(debugger.StepOut()).To(BeNil())
+			Eventually(stops).Should(Receive(WithTransform(func(ev StopEvent) string {
+				return ev.Function
+			}, Equal("outer"))))
+			Expect(debugger.Continue()).To(BeNil())
+		})
+
+		It("should step over calls", func() {
+			Expect(debugger.StepOver()).To(BeNil())
+			Eventually(stops).Should(Receive(Equal(StopEvent{
+				Reason:   StopStep,
+				Function: "outer",
+				Source:   "<string>",
+				Line:     12,
+			})))
+			Expect(debugger.Continue()).To(BeNil())
+		})
+
+		It("should detach from the goroutine of the paused engine", func() {
+			debugger.Detach()
+			Expect(debugger.Paused()).To(BeFalse())
+			Eventually(done).Should(Receive(BeNil()))
+			running = false
+
+			Expect(engine.AttachDebugger()).ToNot(BeIdenticalTo(debugger))
+		})
+	})
+
+	It("should stop on function breakpoints", func() {
+		debugger.SetFunctionBreakpoint("inner")
+		run()
+		Eventually(stops).Should(Receive(WithTransform(func(ev StopEvent) string {
+			return ev.Reason
+		}, Equal(StopFunctionBreakpoint))))
+		Expect(debugger.Continue()).To(BeNil())
+	})
+
+	Context("when serving the debug adapter protocol", func() {
+		var (
+			client net.Conn
+			reader *bufio.Reader
+			seq    int
+		)
+
+		send := func(command string, args interface{}) {
+			seq++
+			data, _ := json.Marshal(map[string]interface{}{
+				"seq":       seq,
+				"type":      "request",
+				"command":   command,
+				"arguments": args,
+			})
+			fmt.Fprintf(client, "Content-Length: %d\r\n\r\n%s", len(data), data)
+		}
+
+		receive := func() map[string]interface{} {
+			length := 0
+			for {
+				line, err := reader.ReadString('\n')
+				Expect(err).To(BeNil())
+				line = strings.TrimSpace(line)
+				if line == "" {
+					break
+				}
+				length, _ = strconv.Atoi(strings.TrimPrefix(line, "Content-Length: "))
+			}
+			data := make([]byte, length)
+			_, err := io.ReadFull(reader, data)
+			Expect(err).To(BeNil())
+			msg := make(map[string]interface{})
+			Expect(json.Unmarshal(data, &msg)).To(BeNil())
+
+			return msg
+		}
+
+		BeforeEach(func() {
+			var server net.Conn
+			client, server = net.Pipe()
+			reader = bufio.NewReader(client)
+			go debugger.ServeDAP(server)
+		})
+
+		AfterEach(func() {
+			client.Close()
+		})
+
+		It("should refuse to listen on non loopback addresses", func() {
+			_, err := debugger.ListenDAP("0.0.0.0:0")
+			Expect(err).To(Equal(ErrNotLocal))
+		})
+
+		It("should reject invalid message lengths", func() {
+			for _, length := range []string{"-1", "1000000000000"} {
+				conn, server := net.Pipe()
+				errs := make(chan error, 1)
+				go func() {
+					errs <- debugger.ServeDAP(server)
+				}()
+				fmt.Fprintf(conn, "Content-Length: %s\r\n\r\n", length)
+				Eventually(errs).Should(Receive(MatchError(ContainSubstring("invalid Content-Length " + length))))
+				conn.Close()
+			}
+		})
+
+		It("should stop at breakpoints set by the client", func() {
+			send("initialize", map[string]interface{}{})
+			Expect(receive()["command"]).To(Equal("initialize"))
+			Expect(receive()["event"]).To(Equal("initialized"))
+
+			send("setBreakpoints", map[string]interface{}{
+				"source":      map[string]interface{}{"path": "<string>"},
+				"breakpoints": []map[string]interface{}{{"line": 5}},
+			})
+			Expect(receive()["success"]).To(BeTrue())
+
+			run()
+			Expect(receive()["event"]).To(Equal("stopped"))
+
+			send("evaluate", map[string]interface{}{"expression": "x", "frameId": 1})
+			resp := receive()
+			Expect(resp["success"]).To(BeTrue())
+			Expect(resp["body"].(map[string]interface{})["result"]).To(Equal("3"))
+
+			send("continue", map[string]interface{}{"threadId": 1})
+			Expect(receive()["success"]).To(BeTrue())
+		})
+
+		It("should resume and clear breakpoints when the client goes away", func() {
+			send("setBreakpoints", map[string]interface{}{
+				"source":      map[string]interface{}{"path": "<string>"},
+				"breakpoints": []map[string]interface{}{{"line": 5}},
+			})
+			Expect(receive()["success"]).To(BeTrue())
+			send("setFunctionBreakpoints", map[string]interface{}{
+				"breakpoints": []map[string]interface{}{{"name": "inner"}},
+			})
+			Expect(receive()["success"]).To(BeTrue())
+
+			run()
+			Expect(receive()["event"]).To(Equal("stopped"))
+			Eventually(stops).Should(Receive())
+			client.Close()
+			Eventually(done).Should(Receive(BeNil()))
+
+			run()
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(stops).ToNot(Receive())
+			running = false
+		})
+	})
+})
@@ -3,7 +3,10 @@
 package lua
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/layeh/gopher-luar"
 	glua "github.com/yuin/gopher-lua"
@@ -25,10 +28,45 @@ var defaultSandbox = Sandbox{
 
 // Engine struct stores a pointer to a gluaLState providing a simplified API.
 type Engine struct {
-	state      *glua.LState
-	Secure     bool
-	sandbox    Sandbox
-	securedFns map[string]struct{}
+	state       *glua.LState
+	Secure      bool
+	CheckOnLoad bool
+
+	// ExposeRegistered makes the functions, types, classes, enums and
+	// channels registered with a secure Engine reachable from the sandbox as
+	// well as from the globals. It applies to the ones registered after it's
+	// set.
+	ExposeRegistered bool
+
+	// ChannelTimeout is how long channel operations of a secure Engine wait
+	// when the script gives no timeout. Zero uses DefaultChannelTimeout, a
+	// negative value lets them wait forever.
+	ChannelTimeout time.Duration
+
+	sandbox       Sandbox
+	securedFns    map[string]struct{}
+	profiler      *Profiler
+	metrics       *metrics
+	tracer        Tracer
+	ctx           context.Context
+	parent        *Engine
+	debugger      *Debugger
+	hooks         []*vmHook
+	hookCount     int
+	userHook      *vmHook
+	types         map[reflect.Type]*typeInfo
+	typesHooked   bool
+	classes       map[*glua.LTable]*class
+	enums         map[reflect.Type]*enum
+	funcTypes     map[*glua.LFunction]reflect.Type
+	docs          map[string]string
+	registrations []*registration
+	registered    map[string]int
+	null          *glua.LUserData
+	codecs        map[string]SnapshotCodec
+	channelFuncs  map[string]*glua.LFunction
+	chanMeta      *glua.LTable
+	checkpoint    *checkpoint
 }
 
 // ScriptFunction is a type alias for a function that receives an Engine and
@@ -41,12 +79,22 @@ type LuaTableMap map[string]interface{}
 
 // NewEngine creates a new engine containing a new lua.LState.
 func NewEngine() *Engine {
-	return &Engine{
+	e := &Engine{
 		state:      glua.NewState(),
 		Secure:     false,
 		sandbox:    defaultSandbox,
 		securedFns: make(map[string]struct{}),
+		types:      make(map[reflect.Type]*typeInfo),
+		classes:    make(map[*glua.LTable]*class),
+		enums:      make(map[reflect.Type]*enum),
+		funcTypes:  make(map[*glua.LFunction]reflect.Type),
+		docs:       make(map[string]string),
 	}
+	e.guardFrozen()
+	e.openJSON()
+	e.openChannel()
+
+	return e
 }
 
 // NewSecureEngine creates a secure engine that will secure each function before
@@ -77,7 +125,7 @@ func NewCustomSecureEngine(sandbox Sandbox) (*Engine, error) {
 // initiateKnockdown runs the SecureScript of the engine, this allows for custom
 // security settings.
 func (e *Engine) initiateKnockdown() error {
-	if err := e.LoadString(e.sandbox.Script); err != nil {
+	if err := e.state.DoString(e.sandbox.Script); err != nil {
 		return err
 	}
 
@@ -89,34 +137,67 @@ func (e *Engine) Close() {
 	e.state.Close()
 }
 
-// LoadFile runs the file through the Lua interpreter.
+// LoadFile runs the file through the Lua interpreter. If CheckOnLoad is set
+// the file is analyzed first and not run if any errors are found.
 func (e *Engine) LoadFile(fn string) error {
-	return e.state.DoFile(fn)
+	if e.CheckOnLoad {
+		if err := e.checkFile(fn); err != nil {
+			return err
+		}
+	}
+
+	_, err := e.trace(e.Context(), fn, nil, func() ([]*Value, error) {
+		return nil, e.state.DoFile(fn)
+	})
+
+	return err
 }
 
-// LoadString runs the given string through the Lua interpreter.
+// LoadString runs the given string through the Lua interpreter. If CheckOnLoad
+// is set the source is analyzed first and not run if any errors are found.
 func (e *Engine) LoadString(src string) error {
-	return e.state.DoString(src)
+	return e.LoadStringContext(e.Context(), src)
 }
 
-// SetGlobal allows for setting global variables in the loaded code.
+// LoadStringContext is LoadString with a context given to the Tracer.
+func (e *Engine) LoadStringContext(ctx context.Context, src string) error {
+	if e.CheckOnLoad {
+		if err := e.checkSource(src, "<string>"); err != nil {
+			return err
+		}
+	}
+
+	_, err := e.trace(ctx, "<string>", nil, func() ([]*Value, error) {
+		return nil, e.state.DoString(src)
+	})
+
+	return err
+}
+
+// SetGlobal allows for setting global variables in the loaded code. It panics
+// with ErrForeignValue if val is a Value of another Engine.
 func (e *Engine) SetGlobal(name string, val interface{}) {
 	v := e.ValueFor(val)
+	e.checkOwner("SetGlobal", v)
 
 	e.state.SetGlobal(name, v.lval)
+	e.record(&registration{kind: "global", name: name, typ: reflect.TypeOf(val)})
 }
 
 // GetGlobal returns the value associated with the given name, or LuaNil
 func (e *Engine) GetGlobal(name string) *Value {
 	lv := e.state.GetGlobal(name)
 
-	return newValue(lv)
+	return e.value(lv)
 }
 
 // SetField applies the value to the given table associated with the given
-// key.
+// key. It panics with ErrForeignValue if tbl or val is a Value of another
+// Engine.
 func (e *Engine) SetField(tbl *Value, key string, val interface{}) {
 	v := e.ValueFor(val)
+	e.checkOwner("SetField", tbl)
+	e.checkOwner("SetField", v)
 	e.state.SetField(tbl.lval, key, v.lval)
 }
 
@@ -128,29 +209,36 @@ func (e *Engine) RegisterFunc(name string, fn interface{}) {
 		lfn = e.genScriptFunc(sf)
 	} else {
 		v := e.ValueFor(fn)
-		lfn = v.lval
+		lfn = e.enumArgs(reflect.TypeOf(fn), v.lval)
 	}
-	e.state.SetGlobal(name, lfn)
+	e.register(name, e.goFunc(name, lfn))
+	e.record(&registration{kind: "function", name: name, typ: reflect.TypeOf(fn)})
 }
 
 // RegisterModule takes the values given, maps them to a LuaTable and then
 // preloads the module with the given name to be consumed in Lua code.
-func (e *Engine) RegisterModule(name string, fields map[string]interface{}) *Value {
+//
+// With the Frozen option scripts get a read-only view of the module, the
+// returned table is the one behind it and can still be changed from Go.
+func (e *Engine) RegisterModule(name string, fields map[string]interface{}, opts ...ModuleOptions) *Value {
 	table := e.NewTable()
+	types := make(map[string]reflect.Type, len(fields))
 	for key, val := range fields {
 		if sf, ok := val.(func(*Engine) int); ok {
-			table.RawSet(key, e.genScriptFunc(sf))
+			table.RawSet(key, e.goFunc(name+"."+key, e.genScriptFunc(sf)))
 		} else {
-			table.RawSet(key, e.ValueFor(val).lval)
+			lv := e.enumArgs(reflect.TypeOf(val), e.ValueFor(val).lval)
+			table.RawSet(key, e.goFunc(name+"."+key, lv))
 		}
+		types[key] = e.luaFuncType(val, e.ValueFor(val).lval)
 	}
+	e.record(&registration{kind: "module", name: name, fields: types})
 
-	loader := func(l *glua.LState) int {
-		l.Push(table.lval)
-
-		return 1
+	module := table.lval
+	if len(opts) > 0 && opts[0].Frozen {
+		module = e.freeze(table.asTable(), name)
 	}
-	e.state.PreloadModule(name, loader)
+	e.preload(name, module)
 
 	return table
 }
@@ -162,12 +250,8 @@ func (e *Engine) RegisterModule(name string, fields map[string]interface{}) *Val
 func (e *Engine) PopArg() *Value {
 	lv := e.state.Get(-1)
 	e.state.Pop(1)
-	val := newValue(lv)
-	if val.isTable() {
-		val.owner = e
-	}
 
-	return val
+	return e.value(lv)
 }
 
 // PushRet pushes the given Value onto the Lua stack.
@@ -230,10 +314,7 @@ func (e *Engine) PopString() string {
 // PopTable is an alias for PopArg, provided for readability when specifying
 // the desired value from the top of the stack.
 func (e *Engine) PopTable() *Value {
-	tbl := e.PopArg()
-	tbl.owner = e
-
-	return tbl
+	return e.PopArg()
 }
 
 // PopInterface returns the top of the stack as an actual Go interface.
@@ -248,65 +329,125 @@ func (e *Engine) PopInterface() interface{} {
 // called should return. These values will be returned in a slice of Value
 // pointers.
 func (e *Engine) Call(name string, retCount int, params ...interface{}) ([]*Value, error) {
+	return e.CallContext(e.Context(), name, retCount, params...)
+}
+
+// CallContext is Call with a context given to the Tracer.
+func (e *Engine) CallContext(ctx context.Context, name string, retCount int, params ...interface{}) ([]*Value, error) {
 	luaParams := make([]glua.LValue, len(params))
 	for i, iface := range params {
 		v := e.ValueFor(iface)
+		if e.foreign(v) {
+			return nil, fmt.Errorf("Call %s: %w", name, ErrForeignValue)
+		}
 		luaParams[i] = v.lval
 	}
 
 	if _, ok := e.securedFns[name]; e.Secure && !ok {
 		secureScript := fmt.Sprintf("setfenv(%s, %s)", name, e.sandbox.EnvName)
-		if err := e.LoadString(secureScript); err != nil {
+		if err := e.state.DoString(secureScript); err != nil {
 			return nil, err
 		}
 		e.securedFns[name] = struct{}{}
 	}
 
-	err := e.state.CallByParam(glua.P{
-		Fn:      e.state.GetGlobal(name),
-		NRet:    retCount,
-		Protect: true,
-	}, luaParams...)
+	return e.trace(ctx, name, luaParams, func() ([]*Value, error) {
+		var done func(bool)
+		if e.metrics != nil {
+			done = e.metrics.start(name, "lua")
+		}
+		err := e.state.CallByParam(glua.P{
+			Fn:      e.state.GetGlobal(name),
+			NRet:    retCount,
+			Protect: true,
+		}, luaParams...)
+		if done != nil {
+			done(err != nil)
+		}
 
-	if err != nil {
-		return nil, err
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	retVals := make([]*Value, retCount)
-	for i := 0; i < retCount; i++ {
-		retVals[i] = newValue(e.state.Get(-1))
-	}
-	e.state.Pop(retCount)
+		retVals := make([]*Value, retCount)
+		for i := 0; i < retCount; i++ {
+			retVals[i] = e.value(e.state.Get(-1))
+		}
+		e.state.Pop(retCount)
 
-	return retVals, nil
+		return retVals, nil
+	})
 }
 
 // RegisterType creates a construtor with the given name that will generate the
 // given type.
-func (e *Engine) RegisterType(name string, val interface{}) {
-	cons := luar.NewType(e.state, val)
-	e.state.SetGlobal(name, cons)
+//
+// Options can be given to limit the methods exposed and to use snake_case names,
+// see TypeOptions.
+func (e *Engine) RegisterType(name string, val interface{}, opts ...TypeOptions) {
+	cons, _ := e.registerType(name, val, opts)
+	e.register(name, cons)
+	e.record(&registration{kind: "type", name: name, typ: reflect.TypeOf(val)})
 }
 
 // RegisterClass assigns a new type, but instead of creating it via "TypeName()"
 // it provides a more OO way of creating the object "TypeName.new()" otherwise
 // it's functionally equivalent to RegisterType.
-func (e *Engine) RegisterClass(name string, val interface{}) {
-	cons := luar.NewType(e.state, val)
-	table := e.NewTable()
-	table.RawSet("new", cons)
-	e.state.SetGlobal(name, table.lval)
+//
+// Scripts can extend the class with "TypeName:extend()" and define or override
+// methods on the subclass, "TypeName:isinstance(value)" checks the class of a
+// value. CallMethod calls methods from Go honouring the overrides.
+func (e *Engine) RegisterClass(name string, val interface{}, opts ...TypeOptions) {
+	cons, info := e.registerType(name, val, opts)
+	e.register(name, e.newClass(name, info, cons, nil))
+	e.record(&registration{kind: "class", name: name, typ: reflect.TypeOf(val)})
 }
 
 // RegisterClassWithCtor does the same thing as RegisterClass excep the new
 // function is mapped to the constructor passed in.
-func (e *Engine) RegisterClassWithCtor(name string, typ interface{}, cons interface{}) {
-	luar.NewType(e.state, typ)
-	lcons := e.ValueFor(cons)
-	table := e.NewTable()
-	table.RawSet("new", lcons)
+func (e *Engine) RegisterClassWithCtor(name string, typ interface{}, cons interface{}, opts ...TypeOptions) {
+	_, info := e.registerType(name, typ, opts)
+	lcons := e.enumArgs(reflect.TypeOf(cons), e.ValueFor(cons).lval)
 
-	e.state.SetGlobal(name, table.lval)
+	e.register(name, e.newClass(name, info, lcons, nil))
+	e.record(&registration{kind: "class", name: name, typ: reflect.TypeOf(typ), ctor: reflect.TypeOf(cons)})
+}
+
+// register sets a global for something registered with the Engine, secure
+// engines only expose it in the sandbox if ExposeRegistered is set.
+func (e *Engine) register(name string, lv glua.LValue) {
+	e.state.SetGlobal(name, lv)
+	if e.ExposeRegistered {
+		e.exposeSandbox(name, lv)
+	}
+}
+
+// provide sets a global the Engine provides to scripts itself, secure engines
+// also expose it in the sandbox so secured functions can reach it.
+func (e *Engine) provide(name string, lv glua.LValue) {
+	e.state.SetGlobal(name, lv)
+	e.exposeSandbox(name, lv)
+}
+
+// exposeSandbox sets name in the sandbox of a secure Engine.
+func (e *Engine) exposeSandbox(name string, lv glua.LValue) {
+	if !e.Secure {
+		return
+	}
+	if env, ok := e.state.GetGlobal(e.sandbox.EnvName).(*glua.LTable); ok {
+		env.RawSetH(glua.LString(name), lv)
+	}
+}
+
+// preload makes require(name) return module. The loader keeps module as an
+// upvalue, so Checkpoint can reach it before it's required.
+func (e *Engine) preload(name string, module glua.LValue) {
+	loader := e.state.NewClosure(func(l *glua.LState) int {
+		l.Push(l.Get(glua.UpvalueIndex(1)))
+
+		return 1
+	}, module)
+	e.state.SetField(e.state.GetField(e.state.GetGlobal("package"), "preload"), name, loader)
 }
 
 // ValueFor takes a Go type and creates a lua equivalent Value for it.
@@ -315,21 +456,19 @@ func (e *Engine) ValueFor(val interface{}) *Value {
 		return v
 	}
 
-	return newValue(luar.New(e.state, val))
+	return e.value(luar.New(e.state, val))
 }
 
 // NewTable creates and returns a new NewTable.
 func (e *Engine) NewTable() *Value {
-	tbl := newValue(e.state.NewTable())
-	tbl.owner = e
-
-	return tbl
+	return e.value(e.state.NewTable())
 }
 
-// wrapScriptFunction turns a ScriptFunction into a lua.LGFunction
+// wrapScriptFunction turns a ScriptFunction into a lua.LGFunction. The Engine
+// given to fn runs on the calling state, its Values belong to e.
 func (e *Engine) wrapScriptFunction(fn ScriptFunction) glua.LGFunction {
 	return func(l *glua.LState) int {
-		e := &Engine{state: l}
+		e := &Engine{state: l, ctx: e.ctx, parent: e.root()}
 
 		return fn(e)
 	}
@@ -340,3 +479,46 @@ func (e *Engine) wrapScriptFunction(fn ScriptFunction) glua.LGFunction {
 func (e *Engine) genScriptFunc(fn ScriptFunction) *glua.LFunction {
 	return e.state.NewFunction(e.wrapScriptFunction(fn))
 }
+
+// goFunc wraps registered Go functions so the time spent in them can be
+// attributed to the given name while the Engine is being profiled, or has
+// metrics enabled, and so a Tracer sees them. Values that aren't Go functions
+// are returned unchanged.
+func (e *Engine) goFunc(name string, lv glua.LValue) glua.LValue {
+	fn, ok := lv.(*glua.LFunction)
+	if !ok || !fn.IsG {
+		return lv
+	}
+	gfn := fn.GFunction
+
+	metered := func(l *glua.LState) int {
+		if e.metrics == nil {
+			return gfn(l)
+		}
+
+		return e.metrics.goCall(l, name, gfn)
+	}
+	traced := func(l *glua.LState) int {
+		if e.tracer == nil {
+			return metered(l)
+		}
+
+		return e.traceGoCall(l, name, metered)
+	}
+
+	return e.state.NewFunction(func(l *glua.LState) int {
+		switch {
+		case !e.instrumented():
+			return gfn(l)
+		case e.profiler == nil:
+			return traced(l)
+		}
+
+		return e.profiler.goCall(l, name, traced)
+	})
+}
+
+// instrumented returns true if calls are profiled, measured or traced.
+func (e *Engine) instrumented() bool {
+	return e.profiler != nil || e.metrics != nil || e.tracer != nil
+}
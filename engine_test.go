@@ -58,4 +58,32 @@ var _ = Describe("Engine", func() {
 			Expect(results[0].AsNumber()).To(Equal(float64(1)))
 		})
 	})
+
+	Context("when secure", func() {
+		BeforeEach(func() {
+			engine, err = NewSecureEngine()
+		})
+
+		It("should not expose registered functions in the sandbox", func() {
+			Expect(err).To(BeNil())
+			engine.RegisterFunc("double", func(x float64) float64 {
+				return x * 2
+			})
+			Expect(engine.LoadString("function f(x) return double(x) end")).To(BeNil())
+			_, err := engine.Call("f", 1, 4)
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("should be able to call registered functions when they're exposed", func() {
+			Expect(err).To(BeNil())
+			engine.ExposeRegistered = true
+			engine.RegisterFunc("double", func(x float64) float64 {
+				return x * 2
+			})
+			Expect(engine.LoadString("function f(x) return double(x) end")).To(BeNil())
+			results, err := engine.Call("f", 1, 4)
+			Expect(err).To(BeNil())
+			Expect(results[0].AsNumber()).To(Equal(float64(8)))
+		})
+	})
 })
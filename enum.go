@@ -0,0 +1,152 @@
+package lua
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// enum describes a set of named integer constants registered with
+// RegisterEnum.
+type enum struct {
+	name   string
+	values map[string]int64
+}
+
+// RegisterEnum exposes values, a map from names to integers, as the read-only
+// table name. Indexing the table with a name returns its value and indexing it
+// with a value returns the name, so Direction.North == 3 and
+// Direction[3] == "North". pairs iterates over the names.
+//
+// When the values have a named integer type, like map[string]Direction, Go
+// functions, methods, fields and properties of that type also accept the names
+// from Lua.
+func (e *Engine) RegisterEnum(name string, values interface{}) error {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("enum %s must be a map with string keys, not %T", name, values)
+	}
+	t := v.Type().Elem()
+	if !isIntegerKind(t.Kind()) {
+		return fmt.Errorf("enum %s must have integer values, not %s", name, t)
+	}
+
+	en := &enum{name: name, values: make(map[string]int64, v.Len())}
+	keys := v.MapKeys()
+	sort.Sort(byString(keys))
+	names := e.state.NewTable()
+	reverse := e.state.NewTable()
+	for _, key := range keys {
+		val := v.MapIndex(key)
+		var n int64
+		if val.Kind() >= reflect.Uint && val.Kind() <= reflect.Uint64 {
+			n = int64(val.Uint())
+		} else {
+			n = val.Int()
+		}
+		en.values[key.String()] = n
+		names.RawSetH(glua.LString(key.String()), glua.LNumber(n))
+		if reverse.RawGet(glua.LNumber(n)) == glua.LNil {
+			reverse.RawSet(glua.LNumber(n), glua.LString(key.String()))
+		}
+	}
+	if t.PkgPath() != "" {
+		e.root().enums[t] = en
+	}
+
+	mt := e.state.NewTable()
+	mt.RawSetH(glua.LString("__index"), e.state.NewFunction(func(l *glua.LState) int {
+		key := l.Get(2)
+		if lv := names.RawGet(key); lv != glua.LNil {
+			l.Push(lv)
+		} else {
+			l.Push(reverse.RawGet(key))
+		}
+
+		return 1
+	}))
+	mt.RawSetH(glua.LString("__newindex"), e.state.NewFunction(func(l *glua.LState) int {
+		l.RaiseError("enum %s is read-only", name)
+
+		return 0
+	}))
+	mt.RawSetH(glua.LString("__pairs"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(l.GetGlobal("next"))
+		l.Push(names)
+		l.Push(glua.LNil)
+
+		return 3
+	}))
+	mt.RawSetH(glua.LString("__metatable"), glua.LFalse)
+
+	table := e.state.NewTable()
+	e.state.SetMetatable(table, mt)
+	e.register(name, table)
+	e.record(&registration{kind: "enum", name: name, values: en.values})
+
+	return nil
+}
+
+// enumArgs wraps lv, the Lua function for a Go function of type t, so that
+// arguments given for parameters of registered enum types can be names.
+// Functions without parameters that could be enums are returned unchanged.
+func (e *Engine) enumArgs(t reflect.Type, lv glua.LValue) glua.LValue {
+	fn, ok := lv.(*glua.LFunction)
+	if !ok || !fn.IsG || t.Kind() != reflect.Func {
+		return lv
+	}
+	params := make([]reflect.Type, t.NumIn())
+	var found bool
+	for i := range params {
+		if p := t.In(i); isIntegerKind(p.Kind()) && p.PkgPath() != "" {
+			params[i] = p
+			found = true
+		}
+	}
+	if !found {
+		return lv
+	}
+	gfn := fn.GFunction
+
+	return e.state.NewFunction(func(l *glua.LState) int {
+		for i, p := range params {
+			if p != nil && i < l.GetTop() {
+				e.enumValue(l, i+1, p)
+			}
+		}
+
+		return gfn(l)
+	})
+}
+
+// enumValue replaces the name at idx with its value if t is a registered enum
+// type, raising an error for unknown names.
+func (e *Engine) enumValue(l *glua.LState, idx int, t reflect.Type) {
+	en, ok := e.root().enums[t]
+	if !ok {
+		return
+	}
+	name, ok := l.Get(idx).(glua.LString)
+	if !ok {
+		return
+	}
+	n, ok := en.values[string(name)]
+	if !ok {
+		l.ArgError(idx, fmt.Sprintf("%q is not a %s", string(name), en.name))
+	}
+	l.Replace(idx, glua.LNumber(n))
+}
+
+// isIntegerKind returns true for the integer kinds.
+func isIntegerKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uint64
+}
+
+// byString sorts reflected strings.
+type byString []reflect.Value
+
+func (s byString) Len() int           { return len(s) }
+func (s byString) Less(i, j int) bool { return s[i].String() < s[j].String() }
+func (s byString) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
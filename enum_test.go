@@ -0,0 +1,114 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type Direction int
+
+const (
+	North Direction = iota + 1
+	East
+	South
+	West
+)
+
+type Walker struct {
+	Facing Direction
+}
+
+func (w *Walker) Turn(d Direction) {
+	w.Facing = d
+}
+
+var _ = Describe("RegisterEnum", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		Expect(engine.RegisterEnum("Direction", map[string]Direction{
+			"North": North, "East": East, "South": South, "West": West,
+		})).To(BeNil())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should look up values and names", func() {
+		Expect(engine.LoadString(`
+			north, name, missing = Direction.North, Direction[3], Direction.Up
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("north").AsNumber()).To(Equal(1.0))
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("South"))
+		Expect(engine.GetGlobal("missing").IsNil()).To(BeTrue())
+	})
+
+	It("should not allow changing the enum", func() {
+		err := engine.LoadString(`Direction.North = 5`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("enum Direction is read-only"))
+		Expect(engine.LoadString(`Direction.Up = 5`)).ToNot(BeNil())
+		Expect(engine.LoadString(`setmetatable(Direction, nil)`)).ToNot(BeNil())
+	})
+
+	It("should iterate over the names", func() {
+		Expect(engine.LoadString(`
+			count, sum = 0, 0
+			for name, value in pairs(Direction) do
+				count, sum = count + 1, sum + value
+			end
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("count").AsNumber()).To(Equal(4.0))
+		Expect(engine.GetGlobal("sum").AsNumber()).To(Equal(10.0))
+	})
+
+	It("should convert names given for enum typed Go values", func() {
+		var got Direction
+		engine.RegisterFunc("face", func(d Direction) {
+			got = d
+		})
+		engine.RegisterType("Walker", Walker{})
+		walker := &Walker{}
+		engine.SetGlobal("walker", walker)
+
+		Expect(engine.LoadString(`face("West")`)).To(BeNil())
+		Expect(got).To(Equal(West))
+		Expect(engine.LoadString(`face(Direction.East)`)).To(BeNil())
+		Expect(got).To(Equal(East))
+		Expect(engine.LoadString(`walker:turn("South")`)).To(BeNil())
+		Expect(walker.Facing).To(Equal(South))
+		Expect(engine.LoadString(`walker.facing = "North"`)).To(BeNil())
+		Expect(walker.Facing).To(Equal(North))
+
+		err := engine.LoadString(`face("Up")`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring(`"Up" is not a Direction`))
+	})
+
+	It("should reject values that aren't integer maps", func() {
+		Expect(engine.RegisterEnum("Bad", map[string]string{"a": "b"})).ToNot(BeNil())
+		Expect(engine.RegisterEnum("Bad", []int{1})).ToNot(BeNil())
+	})
+	It("should register enums from ScriptFunctions", func() {
+		scripted := NewEngine()
+		defer scripted.Close()
+		var got Direction
+		scripted.RegisterFunc("setup", func(se *Engine) int {
+			err := se.RegisterEnum("Direction", map[string]Direction{"North": North, "West": West})
+			se.PushRet(err == nil)
+
+			return 1
+		})
+		scripted.RegisterFunc("face", func(d Direction) {
+			got = d
+		})
+
+		Expect(scripted.LoadString(`ok = setup() face("West")`)).To(BeNil())
+		Expect(scripted.GetGlobal("ok").AsBool()).To(BeTrue())
+		Expect(got).To(Equal(West))
+	})
+})
@@ -0,0 +1,837 @@
+package lua
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// formatIndent is the text used for each level of indentation by Format.
+const formatIndent = "  "
+
+// Operator precedences, from the loosest to the tightest binding.
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precAtom
+)
+
+// luaKeywords are the reserved words that can't be used as names.
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+// Format parses the Lua source and prints it in the canonical style: two space
+// indentation, one statement per line, spaces around binary operators and
+// after commas, double quoted strings (single quoted when that avoids escapes)
+// and only the parentheses required by precedence. Comments and single blank
+// lines between statements are kept.
+func Format(src []byte) ([]byte, error) {
+	text := strings.Replace(string(src), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+
+	// a leading #! line isn't Lua, it's blanked out so line numbers still match
+	shebang := ""
+	if strings.HasPrefix(text, "#") {
+		end := strings.IndexByte(text, '\n')
+		if end < 0 {
+			end = len(text)
+		}
+		shebang = text[:end]
+		text = text[end:]
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(text), "<string>")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &formatter{layout: scanLayout(text), bol: true, fresh: true}
+	if shebang != "" {
+		f.used[1] = true
+		f.write(shebang)
+		f.endLine()
+	}
+	f.stmts(chunk)
+	f.flushComments(len(f.used) + 1)
+
+	return f.buf.Bytes(), nil
+}
+
+// luaComment is a comment found in the source being formatted.
+type luaComment struct {
+	line int
+	text string
+}
+
+// longString identifies a long bracket string literal by the line it starts on
+// and its contents.
+type longString struct {
+	line  int
+	value string
+}
+
+// layout holds the details of the source that the parser discards but the
+// formatter keeps.
+type layout struct {
+	comments []luaComment
+	// used is indexed by line and is true for lines with code or comments
+	used []bool
+	// long maps the long strings to whether their opening bracket was
+	// followed by a newline
+	long map[longString]bool
+	// elses are the lines holding an else keyword
+	elses []int
+	// braces maps a line to the lines closing the braces opened on it, in
+	// the order they were opened
+	braces map[int][]int
+}
+
+// scanLayout walks the source collecting comments, long strings and the lines
+// that aren't blank.
+func scanLayout(src string) *layout {
+	l := &layout{
+		used:   make([]bool, strings.Count(src, "\n")+2),
+		long:   make(map[longString]bool),
+		braces: make(map[int][]int),
+	}
+
+	type brace struct{ line, index int }
+	var open []brace
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			end := len(src)
+			if level := longBracket(src[i+2:]); level >= 0 {
+				end = closeLongBracket(src, i+2, level)
+			} else if nl := strings.IndexByte(src[i:], '\n'); nl >= 0 {
+				end = i + nl
+			}
+			text := strings.TrimRight(src[i:end], " \t\f\v")
+			l.comments = append(l.comments, luaComment{line: line, text: text})
+			line = l.mark(line, src[i:end])
+			i = end
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != c && src[end] != '\n' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(src) {
+				end++
+			}
+			line = l.mark(line, src[i:end])
+			i = end
+		case c == '[' && longBracket(src[i:]) >= 0:
+			level := longBracket(src[i:])
+			end := closeLongBracket(src, i, level)
+			value := src[i+level+2 : end]
+			if strings.HasSuffix(value, "]"+strings.Repeat("=", level)+"]") {
+				value = value[:len(value)-level-2]
+			}
+			newline := strings.HasPrefix(value, "\n")
+			if newline {
+				value = value[1:]
+			}
+			l.long[longString{line: line, value: value}] = newline
+			line = l.mark(line, src[i:end])
+			i = end
+		case isNameByte(c, true):
+			end := i + 1
+			for end < len(src) && isNameByte(src[end], false) {
+				end++
+			}
+			if src[i:end] == "else" {
+				l.elses = append(l.elses, line)
+			}
+			l.used[line] = true
+			i = end
+		case c == '{':
+			open = append(open, brace{line: line, index: len(l.braces[line])})
+			l.braces[line] = append(l.braces[line], line)
+			l.used[line] = true
+			i++
+		case c == '}' && len(open) > 0:
+			b := open[len(open)-1]
+			open = open[:len(open)-1]
+			l.braces[b.line][b.index] = line
+			l.used[line] = true
+			i++
+		default:
+			l.used[line] = true
+			i++
+		}
+	}
+
+	return l
+}
+
+// mark flags the lines covered by text, which starts on line, as used and
+// returns the line it ends on.
+func (l *layout) mark(line int, text string) int {
+	l.used[line] = true
+	for _, c := range text {
+		if c == '\n' {
+			line++
+			l.used[line] = true
+		}
+	}
+
+	return line
+}
+
+// elseLine returns the line of the last else keyword found before or on line.
+func (l *layout) elseLine(line int) int {
+	found := 0
+	for _, el := range l.elses {
+		if el <= line {
+			found = el
+		}
+	}
+
+	return found
+}
+
+// closingBrace returns the line closing the next table constructor opened on
+// line.
+func (l *layout) closingBrace(line int) int {
+	lines := l.braces[line]
+	if len(lines) == 0 {
+		return line
+	}
+	l.braces[line] = lines[1:]
+
+	return lines[0]
+}
+
+// longBracket returns the level of the long bracket opening s, or -1 if s
+// doesn't start with one.
+func longBracket(s string) int {
+	if !strings.HasPrefix(s, "[") {
+		return -1
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1
+	}
+
+	return -1
+}
+
+// closeLongBracket returns the offset just past the long bracket of the given
+// level that closes the one opened at start.
+func closeLongBracket(src string, start, level int) int {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(src[start+level+2:], closing)
+	if end < 0 {
+		return len(src)
+	}
+
+	return start + level + 2 + end + len(closing)
+}
+
+// isNameByte returns true if c can appear in a name, first is set for the
+// first character.
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// isLuaName returns true if s can be written as a bare name.
+func isLuaName(s string) bool {
+	if s == "" || luaKeywords[s] {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameByte(s[i], i == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// quoteString returns s as a Lua short string literal. Double quotes are used
+// unless s contains them and no single quotes.
+func quoteString(s string) string {
+	quote := byte('"')
+	if strings.Contains(s, `"`) && !strings.Contains(s, "'") {
+		quote = '\''
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(quote)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case quote, '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\v':
+			buf.WriteString(`\v`)
+		default:
+			if c < ' ' || c == 0x7f {
+				fmt.Fprintf(&buf, `\%03d`, c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte(quote)
+
+	return buf.String()
+}
+
+// longQuote returns s as a Lua long string literal using the lowest level
+// that doesn't clash with its contents.
+func longQuote(s string, newline bool) string {
+	level := ""
+	for strings.Contains(s+"]", "]"+level+"]") {
+		level += "="
+	}
+	open := "[" + level + "["
+	if newline || strings.HasPrefix(s, "\n") {
+		open += "\n"
+	}
+
+	return open + s + "]" + level + "]"
+}
+
+// formatter prints a parsed chunk in the canonical style.
+type formatter struct {
+	*layout
+	buf    bytes.Buffer
+	indent int
+	// line is the last source line printed
+	line int
+	// bol is set at the beginning of an output line
+	bol bool
+	// fresh is set until something is printed in the current block
+	fresh bool
+}
+
+// write prints s, indenting it if it starts a line.
+func (f *formatter) write(s string) {
+	if f.bol {
+		f.buf.WriteString(strings.Repeat(formatIndent, f.indent))
+		f.bol = false
+	}
+	f.buf.WriteString(s)
+}
+
+// endLine terminates the current output line.
+func (f *formatter) endLine() {
+	f.buf.WriteByte('\n')
+	f.bol = true
+	f.fresh = false
+}
+
+// mark records that the source up to line has been printed.
+func (f *formatter) mark(line int) {
+	if line > f.line {
+		f.line = line
+	}
+}
+
+// newline terminates the current output line, comments found on the source
+// lines printed so far are appended to it.
+func (f *formatter) newline() {
+	var own []luaComment
+	for len(f.comments) > 0 && f.comments[0].line <= f.line {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		if own == nil && !f.bol {
+			f.write(" " + c.text)
+			own = []luaComment{}
+			continue
+		}
+		own = append(own, c)
+	}
+	f.endLine()
+	for _, c := range own {
+		f.write(c.text)
+		f.endLine()
+	}
+}
+
+// blank preserves a blank line found in the source before line.
+func (f *formatter) blank(line int) {
+	if !f.fresh && line > f.line && line-1 < len(f.used) && !f.used[line-1] {
+		f.buf.WriteByte('\n')
+	}
+}
+
+// flushComments prints the comments found before line on lines of their own.
+func (f *formatter) flushComments(line int) {
+	for len(f.comments) > 0 && f.comments[0].line < line {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		f.blank(c.line)
+		f.write(c.text)
+		f.endLine()
+	}
+}
+
+// hasComments returns true if comments found before line are left to print.
+func (f *formatter) hasComments(line int) bool {
+	return len(f.comments) > 0 && f.comments[0].line < line
+}
+
+// stmts prints a list of statements, one per line.
+func (f *formatter) stmts(stmts []ast.Stmt) {
+	for i, stmt := range stmts {
+		f.flushComments(stmt.Line())
+		f.blank(stmt.Line())
+		f.stmt(stmt)
+		// a statement starting with a parenthesis would otherwise be read as a
+		// call continuing this one
+		if i+1 < len(stmts) && startsWithParen(stmts[i+1]) {
+			f.write(";")
+		}
+		// comments are kept with the last statement of a line
+		if i+1 < len(stmts) && stmts[i+1].Line() <= f.line {
+			f.endLine()
+		} else {
+			f.newline()
+		}
+	}
+}
+
+// block prints the body of a statement, last is the line closing it.
+func (f *formatter) block(stmts []ast.Stmt, last int) {
+	f.indent++
+	f.fresh = true
+	f.stmts(stmts)
+	f.flushComments(last)
+	f.indent--
+}
+
+// stmt prints a single statement without the terminating newline.
+func (f *formatter) stmt(stmt ast.Stmt) {
+	f.mark(stmt.Line())
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		f.exprs(s.Lhs)
+		f.write(" = ")
+		f.exprs(s.Rhs)
+	case *ast.LocalAssignStmt:
+		// only local function statements have a last line
+		if s.LastLine() != 0 {
+			f.write("local function " + s.Names[0])
+			f.funcBody(s.Exprs[0].(*ast.FunctionExpr))
+
+			return
+		}
+		f.write("local " + strings.Join(s.Names, ", "))
+		if len(s.Exprs) > 0 {
+			f.write(" = ")
+			f.exprs(s.Exprs)
+		}
+	case *ast.FuncCallStmt:
+		f.expr(s.Expr, 0)
+	case *ast.DoBlockStmt:
+		f.write("do")
+		f.newline()
+		f.block(s.Stmts, s.LastLine())
+		f.end(s.LastLine())
+	case *ast.WhileStmt:
+		f.write("while ")
+		f.expr(s.Condition, 0)
+		f.write(" do")
+		f.newline()
+		f.block(s.Stmts, s.LastLine())
+		f.end(s.LastLine())
+	case *ast.RepeatStmt:
+		f.write("repeat")
+		f.newline()
+		f.block(s.Stmts, s.LastLine())
+		f.write("until ")
+		f.expr(s.Condition, 0)
+	case *ast.IfStmt:
+		f.ifStmt(s)
+	case *ast.NumberForStmt:
+		f.write("for " + s.Name + " = ")
+		f.expr(s.Init, 0)
+		f.write(", ")
+		f.expr(s.Limit, 0)
+		if s.Step != nil {
+			f.write(", ")
+			f.expr(s.Step, 0)
+		}
+		f.write(" do")
+		f.newline()
+		f.block(s.Stmts, s.LastLine())
+		f.end(s.LastLine())
+	case *ast.GenericForStmt:
+		f.write("for " + strings.Join(s.Names, ", ") + " in ")
+		f.exprs(s.Exprs)
+		f.write(" do")
+		f.newline()
+		f.block(s.Stmts, s.LastLine())
+		f.end(s.LastLine())
+	case *ast.FuncDefStmt:
+		f.write("function ")
+		if s.Name.Receiver != nil {
+			f.expr(s.Name.Receiver, 0)
+			f.write(":" + s.Name.Method)
+		} else {
+			f.expr(s.Name.Func, 0)
+		}
+		f.funcBody(s.Func)
+	case *ast.ReturnStmt:
+		f.write("return")
+		if len(s.Exprs) > 0 {
+			f.write(" ")
+			f.exprs(s.Exprs)
+		}
+	case *ast.BreakStmt:
+		f.write("break")
+	}
+}
+
+// ifStmt prints an if statement with its elseif and else branches.
+func (f *formatter) ifStmt(s *ast.IfStmt) {
+	last := s.LastLine()
+	f.write("if ")
+	f.expr(s.Condition, 0)
+	f.write(" then")
+	f.newline()
+	for {
+		// elseif branches are parsed as an if, without an end, nested in else
+		if len(s.Else) == 1 {
+			if next, ok := s.Else[0].(*ast.IfStmt); ok && next.LastLine() == 0 {
+				f.block(s.Then, next.Line())
+				f.mark(next.Line())
+				f.write("elseif ")
+				f.expr(next.Condition, 0)
+				f.write(" then")
+				f.newline()
+				s = next
+
+				continue
+			}
+		}
+		if len(s.Else) == 0 {
+			f.block(s.Then, last)
+
+			break
+		}
+		elseLine := f.elseLine(s.Else[0].Line())
+		f.block(s.Then, elseLine)
+		f.mark(elseLine)
+		f.write("else")
+		f.newline()
+		f.block(s.Else, last)
+
+		break
+	}
+	f.end(last)
+}
+
+// end prints the end keyword closing a block on line.
+func (f *formatter) end(line int) {
+	f.write("end")
+	f.mark(line)
+}
+
+// funcBody prints the parameters and body of a function.
+func (f *formatter) funcBody(fn *ast.FunctionExpr) {
+	params := append([]string{}, fn.ParList.Names...)
+	if fn.ParList.HasVargs {
+		params = append(params, "...")
+	}
+	f.write("(" + strings.Join(params, ", ") + ")")
+
+	if len(fn.Stmts) == 0 && !f.hasComments(fn.LastLine()) {
+		f.write(" ")
+		f.end(fn.LastLine())
+
+		return
+	}
+	f.newline()
+	f.block(fn.Stmts, fn.LastLine())
+	f.end(fn.LastLine())
+}
+
+// exprs prints a comma separated list of expressions.
+func (f *formatter) exprs(exprs []ast.Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expr(e, 0)
+	}
+}
+
+// expr prints e, wrapping it in parentheses if it binds looser than min.
+func (f *formatter) expr(e ast.Expr, min int) {
+	f.mark(e.Line())
+	if exprPrec(e) < min {
+		f.write("(")
+		f.expr(e, 0)
+		f.write(")")
+
+		return
+	}
+
+	switch e := e.(type) {
+	case *ast.TrueExpr:
+		f.write("true")
+	case *ast.FalseExpr:
+		f.write("false")
+	case *ast.NilExpr:
+		f.write("nil")
+	case *ast.Comma3Expr:
+		f.write("...")
+	case *ast.NumberExpr:
+		f.write(e.Value)
+	case *ast.StringExpr:
+		if newline, ok := f.long[longString{line: e.Line(), value: e.Value}]; ok {
+			f.write(longQuote(e.Value, newline))
+		} else {
+			f.write(quoteString(e.Value))
+		}
+	case *ast.IdentExpr:
+		f.write(e.Value)
+	case *ast.AttrGetExpr:
+		f.prefix(e.Object)
+		if key, ok := e.Key.(*ast.StringExpr); ok && isLuaName(key.Value) {
+			f.write("." + key.Value)
+		} else {
+			f.write("[")
+			f.expr(e.Key, 0)
+			f.write("]")
+		}
+	case *ast.TableExpr:
+		f.table(e)
+	case *ast.FuncCallExpr:
+		if e.AdjustRet {
+			f.write("(")
+		}
+		if e.Receiver != nil {
+			f.prefix(e.Receiver)
+			f.write(":" + e.Method)
+		} else {
+			f.prefix(e.Func)
+		}
+		f.write("(")
+		f.exprs(e.Args)
+		f.write(")")
+		if e.AdjustRet {
+			f.write(")")
+		}
+	case *ast.LogicalOpExpr:
+		f.binary(e.Lhs, e.Operator, e.Rhs, exprPrec(e))
+	case *ast.RelationalOpExpr:
+		f.binary(e.Lhs, e.Operator, e.Rhs, exprPrec(e))
+	case *ast.StringConcatOpExpr:
+		f.binary(e.Lhs, "..", e.Rhs, exprPrec(e))
+	case *ast.ArithmeticOpExpr:
+		f.binary(e.Lhs, e.Operator, e.Rhs, exprPrec(e))
+	case *ast.UnaryMinusOpExpr:
+		f.write("-")
+		// a second minus would start a comment
+		if _, ok := e.Expr.(*ast.UnaryMinusOpExpr); ok {
+			f.write(" ")
+		}
+		f.expr(e.Expr, precUnary)
+	case *ast.UnaryNotOpExpr:
+		f.write("not ")
+		f.expr(e.Expr, precUnary)
+	case *ast.UnaryLenOpExpr:
+		f.write("#")
+		f.expr(e.Expr, precUnary)
+	case *ast.FunctionExpr:
+		f.write("function")
+		f.funcBody(e)
+	}
+}
+
+// prefix prints an expression being indexed or called, only names, indexing
+// and calls can be used unparenthesized.
+func (f *formatter) prefix(e ast.Expr) {
+	switch e.(type) {
+	case *ast.IdentExpr, *ast.AttrGetExpr, *ast.FuncCallExpr:
+		f.expr(e, 0)
+	default:
+		f.write("(")
+		f.expr(e, 0)
+		f.write(")")
+	}
+}
+
+// binary prints a binary operation of the given precedence.
+func (f *formatter) binary(lhs ast.Expr, op string, rhs ast.Expr, prec int) {
+	lmin, rmin := prec, prec+1
+	if prec == precConcat || prec == precPow {
+		lmin, rmin = prec+1, prec
+	}
+	// unary operators are always read as the start of the right operand
+	if exprPrec(rhs) == precUnary {
+		rmin = precUnary
+	}
+	f.expr(lhs, lmin)
+	f.write(" " + op + " ")
+	f.expr(rhs, rmin)
+}
+
+// table prints a table constructor, keeping one field per line if the source
+// spread it over several.
+func (f *formatter) table(t *ast.TableExpr) {
+	last := f.closingBrace(t.Line())
+	multiline := false
+	for _, field := range t.Fields {
+		if fieldLine(field) > t.Line() {
+			multiline = true
+		}
+	}
+	if !multiline {
+		f.write("{")
+		for i, field := range t.Fields {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.field(field)
+		}
+		f.write("}")
+
+		return
+	}
+
+	f.write("{")
+	f.newline()
+	f.indent++
+	f.fresh = true
+	for _, field := range t.Fields {
+		f.flushComments(fieldLine(field))
+		f.blank(fieldLine(field))
+		f.field(field)
+		f.write(",")
+		f.newline()
+	}
+	f.flushComments(last)
+	f.indent--
+	f.write("}")
+	f.mark(last)
+}
+
+// field prints a single field of a table constructor.
+func (f *formatter) field(field *ast.Field) {
+	if field.Key != nil {
+		if key, ok := field.Key.(*ast.StringExpr); ok && isLuaName(key.Value) {
+			f.write(key.Value)
+		} else {
+			f.write("[")
+			f.expr(field.Key, 0)
+			f.write("]")
+		}
+		f.write(" = ")
+	}
+	f.expr(field.Value, 0)
+}
+
+// fieldLine returns the line a table field starts on.
+func fieldLine(field *ast.Field) int {
+	if field.Key != nil && field.Key.Line() > 0 {
+		return field.Key.Line()
+	}
+
+	return field.Value.Line()
+}
+
+// exprPrec returns the precedence of the operator applied by e.
+func exprPrec(e ast.Expr) int {
+	switch e := e.(type) {
+	case *ast.LogicalOpExpr:
+		if e.Operator == "or" {
+			return precOr
+		}
+
+		return precAnd
+	case *ast.RelationalOpExpr:
+		return precCompare
+	case *ast.StringConcatOpExpr:
+		return precConcat
+	case *ast.ArithmeticOpExpr:
+		switch e.Operator {
+		case "+", "-":
+			return precAdd
+		case "^":
+			return precPow
+		}
+
+		return precMul
+	case *ast.UnaryMinusOpExpr, *ast.UnaryNotOpExpr, *ast.UnaryLenOpExpr:
+		return precUnary
+	}
+
+	return precAtom
+}
+
+// startsWithParen returns true if stmt is printed starting with a parenthesis.
+func startsWithParen(stmt ast.Stmt) bool {
+	var e ast.Expr
+	switch s := stmt.(type) {
+	case *ast.FuncCallStmt:
+		e = s.Expr
+	case *ast.AssignStmt:
+		e = s.Lhs[0]
+	default:
+		return false
+	}
+
+	for {
+		switch x := e.(type) {
+		case *ast.IdentExpr:
+			return false
+		case *ast.AttrGetExpr:
+			e = x.Object
+		case *ast.FuncCallExpr:
+			if x.AdjustRet {
+				return true
+			}
+			if x.Receiver != nil {
+				e = x.Receiver
+			} else {
+				e = x.Func
+			}
+		default:
+			return true
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Format", func() {
+	format := func(src string) string {
+		out, err := Format([]byte(src))
+		Expect(err).To(BeNil())
+
+		return string(out)
+	}
+
+	It("should report syntax errors", func() {
+		_, err := Format([]byte("x = ("))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should indent blocks and space operators", func() {
+		Expect(format("function f(a,b) if a>b then return a+b*2 else return -a end end")).To(Equal(
+			"function f(a, b)\n" +
+				"  if a > b then\n" +
+				"    return a + b * 2\n" +
+				"  else\n" +
+				"    return -a\n" +
+				"  end\n" +
+				"end\n"))
+	})
+
+	It("should split statements onto their own lines", func() {
+		Expect(format("local a = 1; local b = 2 x = a\n")).To(Equal("local a = 1\nlocal b = 2\nx = a\n"))
+	})
+
+	It("should keep required parentheses only", func() {
+		Expect(format("x = ((a + b)) * c + (d * e) .. (f .. g)\ny = (f())\nz = -(a ^ 2) + (-a) ^ 2")).To(Equal(
+			"x = (a + b) * c + d * e .. f .. g\n" +
+				"y = (f())\n" +
+				"z = -a ^ 2 + (-a) ^ 2\n"))
+	})
+
+	It("should quote strings canonically", func() {
+		Expect(format(`x = 'a' .. 'say "hi"' .. "it's" .. '\9'`)).To(Equal(
+			`x = "a" .. 'say "hi"' .. "it's" .. "\t"` + "\n"))
+		Expect(format("x = [==[\nlong ]] string]==]")).To(Equal("x = [=[\nlong ]] string]=]\n"))
+	})
+
+	It("should preserve comments and blank lines", func() {
+		Expect(format("-- header\n\nlocal t = { -- open\n  a=1,\n\n  -- about b\n  b=2,\n  -- last\n}\nprint(t)  -- trailing\n--[[ end\n]]")).To(Equal(
+			"-- header\n" +
+				"\n" +
+				"local t = { -- open\n" +
+				"  a = 1,\n" +
+				"\n" +
+				"  -- about b\n" +
+				"  b = 2,\n" +
+				"  -- last\n" +
+				"}\n" +
+				"print(t) -- trailing\n" +
+				"--[[ end\n]]\n"))
+	})
+
+	It("should keep the distinction between local functions and assignments", func() {
+		Expect(format("local function f() end local g = function() end")).To(Equal(
+			"local function f() end\nlocal g = function() end\n"))
+	})
+
+	It("should be idempotent and produce equivalent code", func() {
+		src := "local t = {n = 0}\nfunction t:inc(by) self.n = self.n + (by or 1) return self end\n" +
+			"for i=1,3 do t:inc() end\nlocal s = ''\nfor k,v in pairs({1,2}) do s = s .. k .. '=' .. v .. ';' end\n" +
+			"result = t:inc(2 ^ -1).n .. s"
+		out := format(src)
+		Expect(format(out)).To(Equal(out))
+
+		run := func(src string) string {
+			e := NewEngine()
+			defer e.Close()
+			Expect(e.LoadString(src)).To(BeNil())
+
+			return e.GetGlobal("result").AsString()
+		}
+		Expect(run(out)).To(Equal(run(src)))
+	})
+})
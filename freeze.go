@@ -0,0 +1,216 @@
+package lua
+
+import (
+	"fmt"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// ModuleOptions controls how a module given to RegisterModule is exposed to
+// Lua.
+type ModuleOptions struct {
+	// Frozen makes the module table, and every table reachable from it,
+	// read-only, see Engine.Freeze.
+	Frozen bool
+}
+
+// frozenKey is the metatable entry of a proxy holding its frozenTable. Scripts
+// can't reach it, the metatable of proxies is protected.
+const frozenKey = "__frozen"
+
+// frozenTable links a table to the read-only proxy scripts see in its place.
+// The proxies created for the tables read through the same view are shared in
+// views, so they live as long as the view does.
+type frozenTable struct {
+	target *glua.LTable
+	proxy  *glua.LTable
+	path   string
+	views  map[*glua.LTable]*frozenTable
+}
+
+// Freeze returns a read-only view of val if it's a table, other values are
+// returned as they are. The view is recursive, tables read through it are
+// frozen as well, and any assignment raises an error naming the path of the
+// field. pairs, ipairs and # work as they do on the table itself.
+//
+// The table can still be changed from Go, or by scripts holding a reference
+// to it that didn't come from the frozen view. rawset, table.insert,
+// table.remove and table.sort raise an error for views as well.
+func (e *Engine) Freeze(val interface{}) *Value {
+	t, ok := e.ValueFor(val).lval.(*glua.LTable)
+	if !ok {
+		return e.ValueFor(val)
+	}
+
+	return e.value(e.freeze(t, ""))
+}
+
+// freeze returns a new proxy for t, found at path. Frozen proxies are returned
+// unchanged.
+func (e *Engine) freeze(t *glua.LTable, path string) *glua.LTable {
+	if frozenOf(t) != nil {
+		return t
+	}
+
+	return e.newFrozen(t, path, make(map[*glua.LTable]*frozenTable)).proxy
+}
+
+// guardFrozen replaces rawset and the functions of the table library changing
+// tables with ones raising an error for frozen views. They don't use
+// metamethods, so they would write into the proxy, which every script reading
+// the view shares.
+func (e *Engine) guardFrozen() {
+	rawset := e.state.GetGlobal("rawset").(*glua.LFunction).GFunction
+	e.state.SetGlobal("rawset", e.state.NewFunction(func(l *glua.LState) int {
+		if ft := frozenArg(l); ft != nil {
+			l.RaiseError("cannot set %s, the table is frozen", fieldPath(ft.path, l.Get(2)))
+		}
+
+		return rawset(l)
+	}))
+
+	table := e.state.GetGlobal("table").(*glua.LTable)
+	for _, name := range []string{"insert", "remove", "sort"} {
+		name, fn := name, table.RawGetH(glua.LString(name)).(*glua.LFunction).GFunction
+		table.RawSetH(glua.LString(name), e.state.NewFunction(func(l *glua.LState) int {
+			if ft := frozenArg(l); ft != nil {
+				target := ft.path
+				if target == "" {
+					target = "the table"
+				}
+				l.RaiseError("cannot change %s with table.%s, it is frozen", target, name)
+			}
+
+			return fn(l)
+		}))
+	}
+}
+
+// frozenArg returns the frozenTable of the first argument of l, nil if it
+// isn't a frozen proxy.
+func frozenArg(l *glua.LState) *frozenTable {
+	t, ok := l.Get(1).(*glua.LTable)
+	if !ok {
+		return nil
+	}
+
+	return frozenOf(t)
+}
+
+// frozenOf returns the frozenTable of t, nil if t isn't a frozen proxy.
+func frozenOf(t *glua.LTable) *frozenTable {
+	mt, ok := t.Metatable.(*glua.LTable)
+	if !ok {
+		return nil
+	}
+	ud, ok := mt.RawGetH(glua.LString(frozenKey)).(*glua.LUserData)
+	if !ok {
+		return nil
+	}
+	ft, _ := ud.Value.(*frozenTable)
+
+	return ft
+}
+
+// unfrozen returns the table behind t if it's a frozen proxy, t otherwise.
+func unfrozen(t *glua.LTable) *glua.LTable {
+	if ft := frozenOf(t); ft != nil {
+		return ft.target
+	}
+
+	return t
+}
+
+// newFrozen creates the proxy for t, found at path, as part of views.
+func (e *Engine) newFrozen(t *glua.LTable, path string, views map[*glua.LTable]*frozenTable) *frozenTable {
+	ft := &frozenTable{target: t, proxy: e.state.NewTable(), path: path, views: views}
+	views[t] = ft
+	mt := e.state.NewTable()
+	mt.RawSetH(glua.LString("__index"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(e.frozenValue(ft, l.Get(2), t.RawGet(l.Get(2))))
+
+		return 1
+	}))
+	mt.RawSetH(glua.LString("__newindex"), e.state.NewFunction(func(l *glua.LState) int {
+		l.RaiseError("cannot set %s, the table is frozen", fieldPath(ft.path, l.Get(2)))
+
+		return 0
+	}))
+	mt.RawSetH(glua.LString("__len"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(glua.LNumber(t.Len()))
+
+		return 1
+	}))
+	mt.RawSetH(glua.LString("__pairs"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(l.NewFunction(func(l *glua.LState) int {
+			key, val := t.Next(l.Get(2))
+			if key == glua.LNil {
+				l.Push(glua.LNil)
+
+				return 1
+			}
+			l.Push(key)
+			l.Push(e.frozenValue(ft, key, val))
+
+			return 2
+		}))
+		l.Push(ft.proxy)
+		l.Push(glua.LNil)
+
+		return 3
+	}))
+	mt.RawSetH(glua.LString("__ipairs"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(l.NewFunction(func(l *glua.LState) int {
+			i := l.CheckInt(2) + 1
+			val := t.RawGetInt(i)
+			if val == glua.LNil {
+				return 0
+			}
+			l.Push(glua.LNumber(i))
+			l.Push(e.frozenValue(ft, glua.LNumber(i), val))
+
+			return 2
+		}))
+		l.Push(ft.proxy)
+		l.Push(glua.LNumber(0))
+
+		return 3
+	}))
+	mt.RawSetH(glua.LString("__metatable"), glua.LFalse)
+	ud := e.state.NewUserData()
+	ud.Value = ft
+	mt.RawSetH(glua.LString(frozenKey), ud)
+	e.state.SetMetatable(ft.proxy, mt)
+
+	return ft
+}
+
+// frozenValue returns val, read from the field key of ft, frozen if it's a
+// table. Tables read more than once through the view get the same proxy.
+func (e *Engine) frozenValue(ft *frozenTable, key, val glua.LValue) glua.LValue {
+	t, ok := val.(*glua.LTable)
+	if !ok || frozenOf(t) != nil {
+		return val
+	}
+	if view, ok := ft.views[t]; ok {
+		return view.proxy
+	}
+
+	return e.newFrozen(t, fieldPath(ft.path, key), ft.views).proxy
+}
+
+// fieldPath returns the path of the field key in the table at path, using
+// dots for names and brackets for other keys.
+func fieldPath(path string, key glua.LValue) string {
+	s, ok := key.(glua.LString)
+	switch {
+	case ok && isLuaName(string(s)) && path == "":
+		return string(s)
+	case ok && isLuaName(string(s)):
+		return path + "." + string(s)
+	case ok:
+		return fmt.Sprintf("%s[%s]", path, quoteString(string(s)))
+	}
+
+	return fmt.Sprintf("%s[%s]", path, key.String())
+}
@@ -0,0 +1,134 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Freeze", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		Expect(engine.LoadString(`
+			config = {
+				name = "seer",
+				limits = {players = 10, rooms = {"hall", "cellar"}},
+				["max-size"] = {},
+			}
+		`)).To(BeNil())
+		engine.SetGlobal("config", engine.Freeze(engine.GetGlobal("config")))
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should read through frozen tables", func() {
+		Expect(engine.LoadString(`
+			name, players, room = config.name, config.limits.players, config.limits.rooms[2]
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("seer"))
+		Expect(engine.GetGlobal("players").AsNumber()).To(Equal(10.0))
+		Expect(engine.GetGlobal("room").AsString()).To(Equal("cellar"))
+	})
+
+	It("should raise errors naming the path of writes", func() {
+		err := engine.LoadString(`config.limits.players = 100`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot set limits.players, the table is frozen"))
+
+		err = engine.LoadString(`config.limits.rooms[3] = "attic"`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot set limits.rooms[3]"))
+
+		err = engine.LoadString(`config["max-size"].x = 1`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring(`cannot set ["max-size"].x`))
+
+		Expect(engine.LoadString(`setmetatable(config, nil)`)).ToNot(BeNil())
+	})
+
+	It("should keep pairs, ipairs and # working", func() {
+		Expect(engine.LoadString(`
+			keys = 0
+			for k, v in pairs(config) do
+				keys = keys + 1
+			end
+			rooms = ""
+			for i, room in ipairs(config.limits.rooms) do
+				rooms = rooms .. i .. room
+			end
+			count = #config.limits.rooms
+			nested_frozen = not pcall(function()
+				for k, v in pairs(config) do
+					if type(v) == "table" then v.x = 1 end
+				end
+			end)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("keys").AsNumber()).To(Equal(3.0))
+		Expect(engine.GetGlobal("rooms").AsString()).To(Equal("1hall2cellar"))
+		Expect(engine.GetGlobal("count").AsNumber()).To(Equal(2.0))
+		Expect(engine.GetGlobal("nested_frozen").AsBool()).To(BeTrue())
+	})
+
+	It("should keep rawset and the table library from changing the table", func() {
+		err := engine.LoadString(`rawset(config, "name", "other")`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot set name, the table is frozen"))
+
+		err = engine.LoadString(`table.insert(config.limits.rooms, "attic")`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot change limits.rooms with table.insert, it is frozen"))
+
+		Expect(engine.LoadString(`table.remove(config.limits.rooms)`)).ToNot(BeNil())
+		Expect(engine.LoadString(`table.sort(config.limits.rooms)`)).ToNot(BeNil())
+		Expect(engine.LoadString(`
+			name, room, count = config.name, config.limits.rooms[1], #config.limits.rooms
+			local t = {}
+			rawset(t, "x", 1)
+			table.insert(t, "y")
+			plain = t.x == 1 and t[1] == "y"
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("seer"))
+		Expect(engine.GetGlobal("room").AsString()).To(Equal("hall"))
+		Expect(engine.GetGlobal("count").AsNumber()).To(Equal(2.0))
+		Expect(engine.GetGlobal("plain").AsBool()).To(BeTrue())
+	})
+
+	It("should keep sandboxed scripts from changing frozen modules", func() {
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		secure.RegisterModule("config", map[string]interface{}{
+			"name": "seer",
+		}, ModuleOptions{Frozen: true})
+
+		Expect(secure.LoadString(`table.insert(require("config"), "evil")`)).ToNot(BeNil())
+		Expect(secure.LoadString(`rawset(require("config"), 1, "evil")`)).ToNot(BeNil())
+		Expect(secure.LoadString(`
+			local c = require("config")
+			first, count = c[1], #c
+		`)).To(BeNil())
+		Expect(secure.GetGlobal("first").IsNil()).To(BeTrue())
+		Expect(secure.GetGlobal("count").AsNumber()).To(Equal(0.0))
+	})
+
+	It("should freeze modules", func() {
+		engine.RegisterModule("settings", map[string]interface{}{
+			"difficulty": "hard",
+		}, ModuleOptions{Frozen: true})
+
+		Expect(engine.LoadString(`
+			local settings = require("settings")
+			difficulty = settings.difficulty
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("difficulty").AsString()).To(Equal("hard"))
+
+		err := engine.LoadString(`require("settings").difficulty = "easy"`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot set settings.difficulty, the table is frozen"))
+	})
+})
@@ -0,0 +1,252 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// vmHook is a listener for the events raised by the Lua VM. The state only
+// supports a single hook so the Engine multiplexes them between the features
+// (profiling, debugging, ...) that need one. The VM reads the hooks without
+// locking, so they're only added and removed from the goroutine running the
+// Engine, or while it runs nothing.
+type vmHook struct {
+	mask  glua.HookMask
+	count int
+	ticks int
+	fn    glua.HookFunction
+}
+
+// addHook starts delivering VM events to h.
+func (e *Engine) addHook(h *vmHook) {
+	e.hooks = append(e.hooks, h)
+	e.installHooks()
+}
+
+// removeHook stops delivering VM events to h.
+func (e *Engine) removeHook(h *vmHook) {
+	hooks := make([]*vmHook, 0, len(e.hooks))
+	for _, hook := range e.hooks {
+		if hook != h {
+			hooks = append(hooks, hook)
+		}
+	}
+	e.hooks = hooks
+	e.installHooks()
+}
+
+// installHooks sets the VM hook of the state to cover the events required by
+// every registered hook, the count used is the smallest requested.
+func (e *Engine) installHooks() {
+	if len(e.hooks) == 0 {
+		e.state.SetHook(nil, 0, 0)
+
+		return
+	}
+
+	var mask glua.HookMask
+	count := 0
+	for _, h := range e.hooks {
+		mask |= h.mask
+		if h.mask&glua.MaskCount != 0 && (count == 0 || h.count < count) {
+			count = h.count
+		}
+	}
+	e.hookCount = count
+	e.state.SetHook(e.dispatchHook, mask, count)
+}
+
+// dispatchHook is the VM hook of the state, it forwards each event to the
+// hooks interested in it.
+func (e *Engine) dispatchHook(l *glua.LState, event glua.HookEvent, line int) {
+	for _, h := range e.hooks {
+		if h.mask&(glua.HookMask(1)<<uint(event)) == 0 {
+			continue
+		}
+		if event == glua.HookCount {
+			h.ticks += e.hookCount
+			if h.ticks < h.count {
+				continue
+			}
+			h.ticks = 0
+		}
+		h.fn(l, event, line)
+	}
+}
+
+// frameName returns the name of the function running in the frame described by
+// dbg, which must have been filled with GetInfo("n", ...). The VM names
+// functions after the call site, so functions called directly from Go are
+// looked up by value in their environment instead.
+func frameName(l *glua.LState, dbg *glua.Debug) string {
+	if dbg.Name != "main chunk" {
+		return dbg.Name
+	}
+	lv, err := l.GetInfo("f", dbg, glua.LNil)
+	fn, ok := lv.(*glua.LFunction)
+	if err != nil || !ok || fn.IsG || fn.Proto.LineDefined == 0 {
+		return dbg.Name
+	}
+
+	name := ""
+	fn.Env.ForEach(func(key, val glua.LValue) {
+		if k, ok := key.(glua.LString); ok && val == fn && name == "" {
+			name = string(k)
+		}
+	})
+	if name == "" {
+		name = fmt.Sprintf("<%s:%d>", fn.Proto.SourceName, fn.Proto.LineDefined)
+	}
+
+	return name
+}
+
+// HookMask selects the events delivered to the hook set with SetHook.
+type HookMask int
+
+// The events a hook can receive.
+const (
+	// MaskCall delivers an event when a Lua function starts.
+	MaskCall = HookMask(glua.MaskCall)
+	// MaskReturn delivers an event when a Lua function returns.
+	MaskReturn = HookMask(glua.MaskReturn)
+	// MaskLine delivers an event when a new line starts running.
+	MaskLine = HookMask(glua.MaskLine)
+	// MaskCount delivers an event every count instructions.
+	MaskCount = HookMask(glua.MaskCount)
+)
+
+// HookEvent describes the event a hook is called for and the frame raising
+// it. Locals can only be read while the hook runs.
+type HookEvent struct {
+	// Event is "call", "return", "line" or "count".
+	Event    string
+	Source   string
+	Line     int
+	Function string
+
+	engine *Engine
+	l      *glua.LState
+	dbg    *glua.Debug
+}
+
+// Locals returns the named locals of the frame raising the event.
+func (ev HookEvent) Locals() []Variable {
+	return frameLocals(ev.engine, ev.l, ev.dbg)
+}
+
+// Local returns the value of the local name in the frame raising the event,
+// or Nil if there's no such local.
+func (ev HookEvent) Local(name string) *Value {
+	vars := ev.Locals()
+	for i := len(vars) - 1; i >= 0; i-- {
+		if vars[i].Name == name {
+			return vars[i].Value
+		}
+	}
+
+	return Nil
+}
+
+// SetHook calls fn for the events selected by mask as Lua code runs, count
+// events are delivered every count instructions. Like Lua's debug.sethook
+// there's a single hook per Engine, which replaces the previous one, and a nil
+// fn or an empty mask removes it. Go functions don't raise events, and tail
+// calls reuse the frame of the caller so they don't raise call or return
+// events.
+//
+// Hooks aren't synchronised with running scripts. SetHook, like
+// StartProfiler, EnableMetrics and AttachDebugger, must be called from the
+// goroutine running the Engine, for example by a Go function scripts call, or
+// while no script runs.
+func (e *Engine) SetHook(mask HookMask, count int, fn func(HookEvent)) {
+	if e.userHook != nil {
+		e.removeHook(e.userHook)
+		e.userHook = nil
+	}
+	if fn == nil || mask == 0 {
+		return
+	}
+	if count <= 0 {
+		mask &^= MaskCount
+	}
+
+	e.userHook = &vmHook{mask: glua.HookMask(mask), count: count, fn: func(l *glua.LState, event glua.HookEvent, line int) {
+		ev := HookEvent{Event: event.String(), Line: line, engine: e, l: l}
+		if dbg, ok := l.GetStack(0); ok {
+			if _, err := l.GetInfo("nS", dbg, glua.LNil); err == nil {
+				ev.Source = dbg.Source
+				ev.Function = frameName(l, dbg)
+			}
+			ev.dbg = dbg
+		}
+		fn(ev)
+	}}
+	e.addHook(e.userHook)
+}
+
+// EnableLuaHooks adds sethook and gethook to the debug library so scripts can
+// set the hook of the Engine, as in Lua 5.1. The hook is called with the event
+// name and, for line events, the line. Secure engines don't allow it.
+func (e *Engine) EnableLuaHooks() error {
+	if e.Secure {
+		return errors.New("Lua hooks can't be enabled on secure engines")
+	}
+	debug, ok := e.state.GetGlobal("debug").(*glua.LTable)
+	if !ok {
+		return errors.New("the debug library isn't loaded")
+	}
+
+	var hook glua.LValue = glua.LNil
+	var hookMask string
+	var hookCount int
+	debug.RawSetH(glua.LString("sethook"), e.state.NewFunction(func(l *glua.LState) int {
+		fn, ok := l.Get(1).(*glua.LFunction)
+		if !ok {
+			hook, hookMask, hookCount = glua.LNil, "", 0
+			e.SetHook(0, 0, nil)
+
+			return 0
+		}
+		hookMask, hookCount = l.OptString(2, ""), l.OptInt(3, 0)
+		var mask HookMask
+		for _, c := range hookMask {
+			switch c {
+			case 'c':
+				mask |= MaskCall
+			case 'r':
+				mask |= MaskReturn
+			case 'l':
+				mask |= MaskLine
+			}
+		}
+		if hookCount > 0 {
+			mask |= MaskCount
+		}
+		hook = fn
+		e.SetHook(mask, hookCount, func(ev HookEvent) {
+			args := []glua.LValue{glua.LString(ev.Event)}
+			if ev.Event == "line" {
+				args = append(args, glua.LNumber(ev.Line))
+			}
+			ev.l.Push(fn)
+			for _, arg := range args {
+				ev.l.Push(arg)
+			}
+			ev.l.Call(len(args), 0)
+		})
+
+		return 0
+	}))
+	debug.RawSetH(glua.LString("gethook"), e.state.NewFunction(func(l *glua.LState) int {
+		l.Push(hook)
+		l.Push(glua.LString(hookMask))
+		l.Push(glua.LNumber(hookCount))
+
+		return 3
+	}))
+
+	return nil
+}
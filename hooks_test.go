@@ -0,0 +1,115 @@
+package lua_test
+
+import (
+	"fmt"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hooks", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		Expect(engine.LoadString(`
+			function add(a, b)
+				local sum = a + b
+				return sum
+			end
+
+			function run()
+				local sum = add(1, 2)
+				return sum
+			end
+		`)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should deliver call, return and line events", func() {
+		var events []string
+		engine.SetHook(MaskCall|MaskReturn|MaskLine, 0, func(ev HookEvent) {
+			events = append(events, fmt.Sprintf("%s %s %s:%d", ev.Event, ev.Function, ev.Source, ev.Line))
+		})
+		_, err := engine.Call("run", 1)
+		Expect(err).To(BeNil())
+		Expect(events).To(Equal([]string{
+			"call run <string>:8",
+			"line run <string>:8",
+			"call add <string>:3",
+			"line add <string>:3",
+			"line add <string>:4",
+			"return add <string>:4",
+			"line run <string>:9",
+			"return run <string>:9",
+		}))
+
+		events = nil
+		engine.SetHook(0, 0, nil)
+		engine.Call("run", 1)
+		Expect(events).To(BeEmpty())
+	})
+
+	It("should deliver call events after an error", func() {
+		Expect(engine.LoadString(`function fail() error("boom") end`)).To(BeNil())
+		var calls []string
+		engine.SetHook(MaskCall, 0, func(ev HookEvent) {
+			calls = append(calls, ev.Function)
+		})
+		_, err := engine.Call("fail", 0)
+		Expect(err).ToNot(BeNil())
+		_, err = engine.Call("add", 1, 1, 2)
+		Expect(err).To(BeNil())
+		Expect(calls).To(Equal([]string{"fail", "add"}))
+	})
+
+	It("should give access to locals", func() {
+		var sums []float64
+		engine.SetHook(MaskReturn, 0, func(ev HookEvent) {
+			if ev.Function == "add" {
+				sums = append(sums, ev.Local("sum").AsNumber())
+				Expect(ev.Locals()[0].Name).To(Equal("a"))
+				Expect(ev.Local("missing").IsNil()).To(BeTrue())
+			}
+		})
+		_, err := engine.Call("run", 1)
+		Expect(err).To(BeNil())
+		Expect(sums).To(Equal([]float64{3}))
+	})
+
+	It("should deliver count events", func() {
+		count := 0
+		engine.SetHook(MaskCount, 2, func(ev HookEvent) {
+			count++
+		})
+		Expect(engine.LoadString(`for i = 1, 10 do end`)).To(BeNil())
+		Expect(count).To(BeNumerically(">=", 5))
+	})
+
+	It("should let scripts set hooks", func() {
+		Expect(engine.EnableLuaHooks()).To(BeNil())
+		Expect(engine.LoadString(`
+			lines = {}
+			debug.sethook(function(event, line)
+				table.insert(lines, event .. " " .. tostring(line) .. " " .. debug.getinfo(2, "n").name)
+			end, "l")
+			local h, mask = debug.gethook()
+			hookMask = mask
+			add(1, 2)
+			debug.sethook()
+			add(1, 2)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("hookMask").AsString()).To(Equal("l"))
+		Expect(engine.GetGlobal("lines").Dump(DumpOptions{Compact: true})).To(ContainSubstring(`"line 3 add", "line 4 add"`))
+
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		Expect(secure.EnableLuaHooks()).ToNot(BeNil())
+	})
+})
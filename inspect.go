@@ -0,0 +1,261 @@
+package lua
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DumpOptions controls how Value.Dump prints values.
+type DumpOptions struct {
+	// Indent is used for each level of nesting, two spaces if empty.
+	Indent string
+	// Compact prints tables on a single line.
+	Compact bool
+	// MaxDepth is the number of nested tables printed, deeper tables are
+	// printed as {...}. Zero means no limit.
+	MaxDepth int
+}
+
+// Equal returns true if v and other hold the same value. Tables are compared
+// deeply, they're equal if they have the same keys with equal values. Keys
+// themselves, and functions, are compared by identity, userdata by the Go
+// values they hold. Frozen tables are compared by the table behind the view.
+// Cyclic tables are handled.
+func (v *Value) Equal(other *Value) bool {
+	if other == nil {
+		return v.lval == glua.LNil
+	}
+
+	return valuesEqual(v.lval, other.lval, make(map[[2]*glua.LTable]bool))
+}
+
+// valuesEqual compares a and b, seen holds the pairs of tables being compared
+// further up, which are assumed equal.
+func valuesEqual(a, b glua.LValue, seen map[[2]*glua.LTable]bool) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *glua.LTable:
+		b, ok := b.(*glua.LTable)
+		if !ok {
+			return false
+		}
+		a, b = unfrozen(a), unfrozen(b)
+		pair := [2]*glua.LTable{a, b}
+		if seen[pair] {
+			return true
+		}
+		seen[pair] = true
+
+		count, equal := 0, true
+		a.ForEach(func(key, val glua.LValue) {
+			count++
+			if equal && !valuesEqual(val, b.RawGet(key), seen) {
+				equal = false
+			}
+		})
+		if !equal {
+			return false
+		}
+		b.ForEach(func(_, _ glua.LValue) {
+			count--
+		})
+
+		return count == 0
+	case *glua.LUserData:
+		b, ok := b.(*glua.LUserData)
+
+		return ok && reflect.DeepEqual(a.Value, b.Value)
+	}
+
+	return false
+}
+
+// Clone returns a copy of v. Tables are copied with their metatable, when
+// deep is true the tables they hold are copied as well, keeping cycles and
+// tables referenced more than once. Frozen tables are copied from the table
+// behind the view and frozen again. Keys aren't copied, and other values are
+// returned as they are.
+func (v *Value) Clone(deep bool) *Value {
+	t, ok := v.lval.(*glua.LTable)
+	if !ok {
+		return v
+	}
+
+	return v.owner.value(v.owner.cloneTable(t, deep, make(map[*glua.LTable]*glua.LTable)))
+}
+
+// cloneTable copies t, copies holds the tables already copied.
+func (e *Engine) cloneTable(t *glua.LTable, deep bool, copies map[*glua.LTable]*glua.LTable) *glua.LTable {
+	if c, ok := copies[t]; ok {
+		return c
+	}
+	if ft := frozenOf(t); ft != nil {
+		c := e.freeze(e.cloneTable(ft.target, deep, copies), ft.path)
+		copies[t] = c
+
+		return c
+	}
+	c := e.state.CreateTable(t.Len(), 0)
+	c.Metatable = t.Metatable
+	copies[t] = c
+	t.ForEach(func(key, val glua.LValue) {
+		if nested, ok := val.(*glua.LTable); ok && deep {
+			val = e.cloneTable(nested, deep, copies)
+		}
+		c.RawSet(key, val)
+	})
+
+	return c
+}
+
+// Dump returns v as a Lua literal, with table keys sorted so the output is
+// stable. Frozen tables are printed as the table behind the view. Values that
+// can't be written as literals, like functions, and tables already being
+// printed are shown in angle brackets.
+func (v *Value) Dump(opts ...DumpOptions) string {
+	d := &dumper{visited: make(map[*glua.LTable]bool)}
+	if len(opts) > 0 {
+		d.DumpOptions = opts[0]
+	}
+	if d.Indent == "" {
+		d.Indent = "  "
+	}
+	d.value(v.lval, 0)
+
+	return d.buf.String()
+}
+
+// dumper writes values for Value.Dump.
+type dumper struct {
+	DumpOptions
+	buf     bytes.Buffer
+	visited map[*glua.LTable]bool
+}
+
+// value writes lv, at the given nesting depth.
+func (d *dumper) value(lv glua.LValue, depth int) {
+	switch v := lv.(type) {
+	case glua.LString:
+		d.buf.WriteString(quoteString(string(v)))
+	case *glua.LTable:
+		d.table(v, depth)
+	case *glua.LUserData:
+		fmt.Fprintf(&d.buf, "<userdata: %v>", v.Value)
+	case *glua.LNilType, glua.LBool, glua.LNumber:
+		d.buf.WriteString(lv.String())
+	default:
+		fmt.Fprintf(&d.buf, "<%s>", lv.String())
+	}
+}
+
+// table writes t, at the given nesting depth.
+func (d *dumper) table(t *glua.LTable, depth int) {
+	t = unfrozen(t)
+	if d.visited[t] {
+		d.buf.WriteString("<cycle>")
+
+		return
+	}
+	n := 0
+	for t.RawGetInt(n+1) != glua.LNil {
+		n++
+	}
+	var keys []glua.LValue
+	t.ForEach(func(key, _ glua.LValue) {
+		if i, ok := key.(glua.LNumber); !ok || float64(i) != float64(int(i)) || int(i) < 1 || int(i) > n {
+			keys = append(keys, key)
+		}
+	})
+	if n == 0 && len(keys) == 0 {
+		d.buf.WriteString("{}")
+
+		return
+	}
+	if d.MaxDepth > 0 && depth >= d.MaxDepth {
+		d.buf.WriteString("{...}")
+
+		return
+	}
+	sort.Sort(byKey(keys))
+
+	d.visited[t] = true
+	defer delete(d.visited, t)
+
+	d.buf.WriteByte('{')
+	first := true
+	item := func() {
+		if !first {
+			d.buf.WriteByte(',')
+		}
+		first = false
+		if d.Compact {
+			if d.buf.Bytes()[d.buf.Len()-1] != '{' {
+				d.buf.WriteByte(' ')
+			}
+		} else {
+			d.buf.WriteByte('\n')
+			d.buf.WriteString(strings.Repeat(d.Indent, depth+1))
+		}
+	}
+	for i := 1; i <= n; i++ {
+		item()
+		d.value(t.RawGetInt(i), depth+1)
+	}
+	for _, key := range keys {
+		item()
+		if s, ok := key.(glua.LString); ok && isLuaName(string(s)) {
+			d.buf.WriteString(string(s))
+		} else {
+			d.buf.WriteByte('[')
+			d.value(key, depth+1)
+			d.buf.WriteByte(']')
+		}
+		d.buf.WriteString(" = ")
+		d.value(t.RawGet(key), depth+1)
+	}
+	if !d.Compact {
+		d.buf.WriteString(",\n")
+		d.buf.WriteString(strings.Repeat(d.Indent, depth))
+	}
+	d.buf.WriteByte('}')
+}
+
+// byKey sorts table keys, numbers first in numeric order, then strings, then
+// any other keys by type and string form.
+type byKey []glua.LValue
+
+func (s byKey) Len() int      { return len(s) }
+func (s byKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byKey) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.Type() != b.Type() {
+		return keyRank(a) < keyRank(b)
+	}
+	switch a := a.(type) {
+	case glua.LNumber:
+		return a < b.(glua.LNumber)
+	case glua.LString:
+		return a < b.(glua.LString)
+	}
+
+	return a.String() < b.String()
+}
+
+// keyRank orders key types for byKey.
+func keyRank(lv glua.LValue) int {
+	switch lv.Type() {
+	case glua.LTNumber:
+		return 0
+	case glua.LTString:
+		return 1
+	}
+
+	return 2 + int(lv.Type())
+}
@@ -0,0 +1,109 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Inspecting Values", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		Expect(engine.LoadString(`
+			a = {name = "seer", tags = {"x", "y"}, [10] = true}
+			b = {name = "seer", tags = {"x", "y"}, [10] = true}
+			c = {name = "seer", tags = {"x", "z"}, [10] = true}
+			d = {name = "seer", tags = {"x", "y"}}
+			ca = {}; ca.self = ca
+			cb = {}; cb.self = cb
+		`)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should compare values deeply", func() {
+		a := engine.GetGlobal("a")
+		Expect(a.Equal(engine.GetGlobal("b"))).To(BeTrue())
+		Expect(a.Equal(engine.GetGlobal("c"))).To(BeFalse())
+		Expect(a.Equal(engine.GetGlobal("d"))).To(BeFalse())
+		Expect(engine.GetGlobal("d").Equal(a)).To(BeFalse())
+		Expect(engine.GetGlobal("ca").Equal(engine.GetGlobal("cb"))).To(BeTrue())
+		Expect(String("x").Equal(String("x"))).To(BeTrue())
+		Expect(Number(1).Equal(String("1"))).To(BeFalse())
+		Expect(engine.GetGlobal("missing").Equal(nil)).To(BeTrue())
+	})
+
+	It("should clone tables", func() {
+		a := engine.GetGlobal("a")
+		shallow := a.Clone(false)
+		deep := a.Clone(true)
+		engine.SetGlobal("shallow", shallow)
+		engine.SetGlobal("deep", deep)
+		Expect(engine.LoadString(`
+			shallowShared = shallow.tags == a.tags
+			deepShared = deep.tags == a.tags
+			a.tags[1] = "changed"
+			shallow.name = "copy"
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("shallowShared").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("deepShared").AsBool()).To(BeFalse())
+		Expect(engine.GetGlobal("a").Equal(engine.GetGlobal("b"))).To(BeFalse())
+		Expect(deep.Equal(engine.GetGlobal("b"))).To(BeTrue())
+		Expect(engine.GetGlobal("a").Dump(DumpOptions{Compact: true})).To(ContainSubstring(`name = "seer"`))
+
+		cycle := engine.GetGlobal("ca").Clone(true)
+		engine.SetGlobal("cycle", cycle)
+		Expect(engine.LoadString(`same = cycle.self == cycle and cycle ~= ca`)).To(BeNil())
+		Expect(engine.GetGlobal("same").AsBool()).To(BeTrue())
+	})
+
+	It("should clone frozen tables without reading through to them", func() {
+		for _, deep := range []bool{false, true} {
+			engine.SetGlobal("copy", engine.Freeze(engine.GetGlobal("a")).Clone(deep))
+			Expect(engine.LoadString(`
+				local original = a.name
+				a.name = "changed"
+				name = copy.name
+				frozen = not pcall(function() copy.name = "x" end)
+				a.name = original
+			`)).To(BeNil())
+			Expect(engine.GetGlobal("name").AsString()).To(Equal("seer"))
+			Expect(engine.GetGlobal("frozen").AsBool()).To(BeTrue())
+		}
+	})
+
+	It("should compare and dump frozen tables by the table behind them", func() {
+		a, b, c := engine.GetGlobal("a"), engine.GetGlobal("b"), engine.GetGlobal("c")
+		Expect(engine.Freeze(a).Equal(engine.Freeze(b))).To(BeTrue())
+		Expect(engine.Freeze(a).Equal(engine.Freeze(c))).To(BeFalse())
+		Expect(engine.Freeze(a).Equal(c)).To(BeFalse())
+		Expect(engine.Freeze(a).Equal(a)).To(BeTrue())
+		Expect(engine.Freeze(a).Dump()).To(Equal(a.Dump()))
+	})
+
+	It("should dump values as Lua literals", func() {
+		Expect(engine.LoadString(`
+			v = {3, "two", {1}, zeta = false, alpha = 1.5, ["has space"] = 'say "hi"', [20] = {}, f = print}
+			v.nested = {deeper = {deepest = {}}}
+		`)).To(BeNil())
+		v := engine.GetGlobal("v")
+		Expect(v.Dump(DumpOptions{Compact: true, MaxDepth: 2})).To(MatchRegexp(
+			`^\{3, "two", \{1\}, \[20\] = \{\}, alpha = 1\.5, f = <function: .+>, \["has space"\] = 'say "hi"', nested = \{deeper = \{\.\.\.\}\}, zeta = false\}$`))
+
+		Expect(engine.GetGlobal("a").Dump()).To(Equal(`{
+  [10] = true,
+  name = "seer",
+  tags = {
+    "x",
+    "y",
+  },
+}`))
+		Expect(engine.GetGlobal("ca").Dump(DumpOptions{Compact: true})).To(Equal("{self = <cycle>}"))
+		Expect(String("line\n").Dump()).To(Equal(`"line\n"`))
+	})
+})
@@ -0,0 +1,475 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// jsonNull is the Go value behind json.null.
+type jsonNull struct{}
+
+// openJSON registers the json module, both as a global and for require. The
+// module has three fields:
+//
+//	json.encode(value [, {pretty = true, indent = "  "}]) -> string | nil, err
+//	json.decode(string) -> value | nil, err
+//	json.null
+func (e *Engine) openJSON() {
+	module := e.state.NewTable()
+	module.RawSetH(glua.LString("encode"), e.state.NewFunction(func(l *glua.LState) int {
+		indent := ""
+		if opts, ok := l.Get(2).(*glua.LTable); ok && glua.LVAsBool(opts.RawGetH(glua.LString("pretty"))) {
+			indent = "  "
+			if s, ok := opts.RawGetH(glua.LString("indent")).(glua.LString); ok {
+				indent = string(s)
+			}
+		}
+		data, err := e.encodeJSON(l.Get(1), indent)
+		if err != nil {
+			l.Push(glua.LNil)
+			l.Push(glua.LString(err.Error()))
+
+			return 2
+		}
+		l.Push(glua.LString(data))
+
+		return 1
+	}))
+	module.RawSetH(glua.LString("decode"), e.state.NewFunction(func(l *glua.LState) int {
+		lv, err := e.decodeJSON([]byte(l.CheckString(1)))
+		if err != nil {
+			l.Push(glua.LNil)
+			l.Push(glua.LString(err.Error()))
+
+			return 2
+		}
+		l.Push(lv)
+
+		return 1
+	}))
+	module.RawSetH(glua.LString("null"), e.nullValue())
+
+	e.state.SetGlobal("json", module)
+	e.preload("json", module)
+}
+
+// nullValue returns the userdata standing for JSON null in the Engine, it's
+// created the first time it's needed.
+func (e *Engine) nullValue() *glua.LUserData {
+	if e.null == nil {
+		e.null = e.state.NewUserData()
+		e.null.Value = jsonNull{}
+		mt := e.state.NewTable()
+		mt.RawSetH(glua.LString("__tostring"), e.state.NewFunction(func(l *glua.LState) int {
+			l.Push(glua.LString("null"))
+
+			return 1
+		}))
+		mt.RawSetH(glua.LString("__metatable"), glua.LFalse)
+		e.null.Metatable = mt
+	}
+
+	return e.null
+}
+
+// isJSONNull returns true if lv is json.null.
+func isJSONNull(lv glua.LValue) bool {
+	ud, ok := lv.(*glua.LUserData)
+	if !ok {
+		return false
+	}
+	_, ok = ud.Value.(jsonNull)
+
+	return ok
+}
+
+// MarshalJSON encodes the Value as JSON, so Values can be given to
+// encoding/json directly. Tables whose keys are the integers 1 to n become
+// arrays, other tables become objects with their keys sorted. Empty tables
+// are encoded as empty objects.
+//
+// Userdata holding values of registered struct types become objects of the
+// fields Lua sees, under their Lua names, so hidden fields are left out.
+// Functions, threads, cyclic tables, tables with keys other than strings and
+// numbers, and structs of unregistered types can't be encoded, the error names
+// the path to the value.
+func (v *Value) MarshalJSON() ([]byte, error) {
+	data, err := v.owner.encodeJSON(v.lval, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(data), nil
+}
+
+// ValueFromJSON decodes data into a Lua value. Objects and arrays become
+// tables and null becomes json.null, so it's kept in arrays and objects.
+func (e *Engine) ValueFromJSON(data []byte) (*Value, error) {
+	lv, err := e.decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	val := newValue(lv)
+	val.owner = e
+
+	return val, nil
+}
+
+// jsonEncoder writes Lua values as JSON, keeping track of the tables being
+// encoded to detect cycles.
+type jsonEncoder struct {
+	e       *Engine
+	buf     bytes.Buffer
+	visited map[*glua.LTable]bool
+}
+
+// encodeJSON returns lv encoded as JSON, indented with indent if it isn't
+// empty. e may be nil.
+func (e *Engine) encodeJSON(lv glua.LValue, indent string) (string, error) {
+	enc := &jsonEncoder{e: e, visited: make(map[*glua.LTable]bool)}
+	if err := enc.encode(lv, ""); err != nil {
+		return "", err
+	}
+	if indent == "" {
+		return enc.buf.String(), nil
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, enc.buf.Bytes(), "", indent); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// encode writes lv, found at path, to the buffer.
+func (enc *jsonEncoder) encode(lv glua.LValue, path string) error {
+	switch v := lv.(type) {
+	case *glua.LNilType:
+		enc.buf.WriteString("null")
+	case glua.LBool:
+		fmt.Fprint(&enc.buf, bool(v))
+	case glua.LNumber:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return enc.errorf(path, "cannot encode %s", v.String())
+		}
+		data, _ := json.Marshal(f)
+		enc.buf.Write(data)
+	case glua.LString:
+		data, _ := json.Marshal(string(v))
+		enc.buf.Write(data)
+	case *glua.LTable:
+		return enc.table(v, path)
+	case *glua.LUserData:
+		if isJSONNull(v) {
+			enc.buf.WriteString("null")
+
+			return nil
+		}
+		return enc.goValue(reflect.ValueOf(v.Value), path)
+	default:
+		return enc.errorf(path, "cannot encode %s", lv.Type())
+	}
+
+	return nil
+}
+
+// table writes t, found at path, as an array or an object.
+func (enc *jsonEncoder) table(t *glua.LTable, path string) error {
+	if ft := frozenOf(t); ft != nil {
+		t = ft.target
+	}
+	if enc.visited[t] {
+		return enc.errorf(path, "cannot encode cyclic table")
+	}
+	enc.visited[t] = true
+	defer delete(enc.visited, t)
+
+	var keys []glua.LValue
+	t.ForEach(func(key, _ glua.LValue) {
+		keys = append(keys, key)
+	})
+
+	if n := len(keys); n > 0 && isSequence(keys) {
+		enc.buf.WriteByte('[')
+		for i := 1; i <= n; i++ {
+			if i > 1 {
+				enc.buf.WriteByte(',')
+			}
+			if err := enc.encode(t.RawGetInt(i), fieldPath(path, glua.LNumber(i))); err != nil {
+				return err
+			}
+		}
+		enc.buf.WriteByte(']')
+
+		return nil
+	}
+
+	names := make(map[string]glua.LValue, len(keys))
+	sorted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var name string
+		switch k := key.(type) {
+		case glua.LString:
+			name = string(k)
+		case glua.LNumber:
+			name = k.String()
+		default:
+			return enc.errorf(path, "cannot encode %s key", key.Type())
+		}
+		names[name] = key
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	enc.buf.WriteByte('{')
+	for i, name := range sorted {
+		if i > 0 {
+			enc.buf.WriteByte(',')
+		}
+		data, _ := json.Marshal(name)
+		enc.buf.Write(data)
+		enc.buf.WriteByte(':')
+		key := names[name]
+		if err := enc.encode(t.RawGet(key), fieldPath(path, key)); err != nil {
+			return err
+		}
+	}
+	enc.buf.WriteByte('}')
+
+	return nil
+}
+
+// goValue writes the Go value ref, found at path. Structs are only encoded if
+// their type is registered, as objects of the fields Lua sees under their Lua
+// names. Values implementing json.Marshaler encode themselves.
+func (enc *jsonEncoder) goValue(ref reflect.Value, path string) error {
+	if !ref.IsValid() {
+		enc.buf.WriteString("null")
+
+		return nil
+	}
+	if m, ok := ref.Interface().(json.Marshaler); ok && (ref.Kind() != reflect.Ptr || !ref.IsNil()) {
+		data, err := m.MarshalJSON()
+		if err != nil {
+			return enc.errorf(path, "cannot encode %s: %s", ref.Type(), err)
+		}
+		enc.buf.Write(data)
+
+		return nil
+	}
+
+	switch ref.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if ref.IsNil() {
+			enc.buf.WriteString("null")
+
+			return nil
+		}
+
+		return enc.goValue(ref.Elem(), path)
+	case reflect.Struct:
+		return enc.goStruct(ref, path)
+	case reflect.Slice, reflect.Array:
+		if ref.Kind() == reflect.Slice && ref.IsNil() {
+			enc.buf.WriteString("null")
+
+			return nil
+		}
+		enc.buf.WriteByte('[')
+		for i := 0; i < ref.Len(); i++ {
+			if i > 0 {
+				enc.buf.WriteByte(',')
+			}
+			if err := enc.goValue(ref.Index(i), fieldPath(path, glua.LNumber(i+1))); err != nil {
+				return err
+			}
+		}
+		enc.buf.WriteByte(']')
+
+		return nil
+	case reflect.Map:
+		return enc.goMap(ref, path)
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		data, err := json.Marshal(ref.Interface())
+		if err != nil {
+			return enc.errorf(path, "cannot encode %v", ref.Interface())
+		}
+		enc.buf.Write(data)
+
+		return nil
+	}
+
+	return enc.errorf(path, "cannot encode %s", ref.Type())
+}
+
+// goStruct writes the struct ref, found at path, as an object.
+func (enc *jsonEncoder) goStruct(ref reflect.Value, path string) error {
+	var info *typeInfo
+	if enc.e != nil {
+		info = enc.e.root().types[ref.Type()]
+	}
+	if info == nil {
+		return enc.errorf(path, "cannot encode unregistered type %s", ref.Type())
+	}
+
+	names := make([]string, 0, len(info.members))
+	for _, name := range info.members {
+		if _, ok := info.fields[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	enc.buf.WriteByte('{')
+	written := 0
+	for _, name := range names {
+		field, err := ref.FieldByIndexErr(info.fields[name].index)
+		if err != nil {
+			// fields of nil embedded pointers aren't there
+			continue
+		}
+		if written > 0 {
+			enc.buf.WriteByte(',')
+		}
+		written++
+		data, _ := json.Marshal(name)
+		enc.buf.Write(data)
+		enc.buf.WriteByte(':')
+		if err := enc.goValue(field, fieldPath(path, glua.LString(name))); err != nil {
+			return err
+		}
+	}
+	enc.buf.WriteByte('}')
+
+	return nil
+}
+
+// goMap writes the map ref, found at path, as an object with its keys sorted.
+func (enc *jsonEncoder) goMap(ref reflect.Value, path string) error {
+	if ref.IsNil() {
+		enc.buf.WriteString("null")
+
+		return nil
+	}
+	keys := make(map[string]reflect.Value, ref.Len())
+	sorted := make([]string, 0, ref.Len())
+	for _, key := range ref.MapKeys() {
+		var name string
+		switch key.Kind() {
+		case reflect.String:
+			name = key.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			name = fmt.Sprint(key.Interface())
+		default:
+			return enc.errorf(path, "cannot encode %s key", key.Type())
+		}
+		keys[name] = key
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	enc.buf.WriteByte('{')
+	for i, name := range sorted {
+		if i > 0 {
+			enc.buf.WriteByte(',')
+		}
+		data, _ := json.Marshal(name)
+		enc.buf.Write(data)
+		enc.buf.WriteByte(':')
+		if err := enc.goValue(ref.MapIndex(keys[name]), fieldPath(path, glua.LString(name))); err != nil {
+			return err
+		}
+	}
+	enc.buf.WriteByte('}')
+
+	return nil
+}
+
+// errorf returns an error for the value at path.
+func (enc *jsonEncoder) errorf(path, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if path != "" {
+		msg += " at " + path
+	}
+
+	return fmt.Errorf("json: %s", msg)
+}
+
+// isSequence returns true if keys are the integers 1 to len(keys), in any
+// order.
+func isSequence(keys []glua.LValue) bool {
+	seen := make([]bool, len(keys))
+	for _, key := range keys {
+		n, ok := key.(glua.LNumber)
+		if !ok {
+			return false
+		}
+		i := int(n)
+		if glua.LNumber(i) != n || i < 1 || i > len(keys) || seen[i-1] {
+			return false
+		}
+		seen[i-1] = true
+	}
+
+	return true
+}
+
+// decodeJSON decodes data into Lua values.
+func (e *Engine) decodeJSON(data []byte) (glua.LValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return glua.LNil, fmt.Errorf("json: %s", err)
+	}
+	if dec.More() {
+		return glua.LNil, fmt.Errorf("json: invalid data after top-level value")
+	}
+
+	return e.jsonValue(v), nil
+}
+
+// jsonValue converts a value decoded by encoding/json to Lua.
+func (e *Engine) jsonValue(v interface{}) glua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return e.nullValue()
+	case bool:
+		return glua.LBool(v)
+	case json.Number:
+		f, _ := v.Float64()
+
+		return glua.LNumber(f)
+	case string:
+		return glua.LString(v)
+	case []interface{}:
+		t := e.state.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(e.jsonValue(item))
+		}
+
+		return t
+	case map[string]interface{}:
+		t := e.state.CreateTable(0, len(v))
+		for key, item := range v {
+			t.RawSetH(glua.LString(key), e.jsonValue(item))
+		}
+
+		return t
+	}
+
+	return glua.LNil
+}
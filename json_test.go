@@ -0,0 +1,101 @@
+package lua_test
+
+import (
+	"encoding/json"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSON", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should encode tables with sorted keys", func() {
+		Expect(engine.LoadString(`
+			out = json.encode({name = "seer", tags = {"a", "b"}, count = 3, ratio = 0.5, empty = {}, none = json.null})
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("out").AsString()).To(Equal(
+			`{"count":3,"empty":{},"name":"seer","none":null,"ratio":0.5,"tags":["a","b"]}`))
+	})
+
+	It("should encode pretty output", func() {
+		Expect(engine.LoadString(`
+			out = json.encode({a = {1, 2}}, {pretty = true})
+			tabs = json.encode({a = 1}, {pretty = true, indent = "\t"})
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("out").AsString()).To(Equal("{\n  \"a\": [\n    1,\n    2\n  ]\n}"))
+		Expect(engine.GetGlobal("tabs").AsString()).To(Equal("{\n\t\"a\": 1\n}"))
+	})
+
+	It("should encode sparse arrays as objects", func() {
+		Expect(engine.LoadString(`out = json.encode({[1] = "a", [3] = "c"})`)).To(BeNil())
+		Expect(engine.GetGlobal("out").AsString()).To(Equal(`{"1":"a","3":"c"}`))
+	})
+
+	It("should return errors naming the path of values that can't be encoded", func() {
+		Expect(engine.LoadString(`
+			out, err = json.encode({player = {onHit = function() end}})
+			t = {}
+			t.self = t
+			_, cycle = json.encode(t)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("out").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("err").AsString()).To(Equal("json: cannot encode function at player.onHit"))
+		Expect(engine.GetGlobal("cycle").AsString()).To(Equal("json: cannot encode cyclic table at self"))
+	})
+
+	It("should decode into tables", func() {
+		Expect(engine.LoadString(`
+			local v = json.decode('{"name": "seer", "tags": ["a", null, "c"], "n": 1.5}')
+			name, tag, n, isNull = v.name, v.tags[3], v.n, v.tags[2] == json.null
+			_, err = json.decode('{"name":')
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("seer"))
+		Expect(engine.GetGlobal("tag").AsString()).To(Equal("c"))
+		Expect(engine.GetGlobal("n").AsNumber()).To(Equal(1.5))
+		Expect(engine.GetGlobal("isNull").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("err").AsString()).To(HavePrefix("json: "))
+	})
+
+	It("should be available with require", func() {
+		Expect(engine.LoadString(`out = require("json").encode({1, 2})`)).To(BeNil())
+		Expect(engine.GetGlobal("out").AsString()).To(Equal("[1,2]"))
+	})
+
+	It("should marshal Values and decode JSON from Go", func() {
+		val, err := engine.ValueFromJSON([]byte(`{"b": [true, false], "a": "x"}`))
+		Expect(err).To(BeNil())
+		Expect(val.IsTable()).To(BeTrue())
+
+		data, err := json.Marshal(map[string]interface{}{"payload": val})
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal(`{"payload":{"a":"x","b":[true,false]}}`))
+
+		_, err = engine.ValueFromJSON([]byte(`[1,`))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should be available to secure engines", func() {
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		Expect(secure.LoadString(`
+			function encode()
+				return json.encode({ok = true})
+			end
+		`)).To(BeNil())
+		ret, err := secure.Call("encode", 1)
+		Expect(err).To(BeNil())
+		Expect(ret[0].AsString()).To(Equal(`{"ok":true}`))
+	})
+})
@@ -0,0 +1,207 @@
+package lua
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// LogOptions controls how scripts log through SetLogger.
+type LogOptions struct {
+	// Level is the minimum level logged, Info if nil. A *slog.LevelVar can be
+	// used to change it while scripts run.
+	Level slog.Leveler
+	// RedirectPrint replaces print with a function logging its arguments at
+	// the Info level. Secure engines also make it available in the sandbox.
+	RedirectPrint bool
+	// RateLimit is the number of records per second scripts can log, with
+	// bursts of up to Burst records. Records over the limit are dropped and
+	// counted in the dropped attribute of the next record logged. Zero means
+	// no limit.
+	RateLimit float64
+	Burst     int
+}
+
+// scriptLogger sends the records of scripts to a slog.Logger.
+type scriptLogger struct {
+	e       *Engine
+	logger  *slog.Logger
+	opts    LogOptions
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// SetLogger registers the log module, which logs to logger. It has a
+// function for each level, taking a message and an optional table of fields:
+//
+//	log.info("player joined", {name = name, room = 12})
+//
+// Records are annotated with the chunk and line of the call, and with env
+// when it's made from the sandbox of a secure engine.
+func (e *Engine) SetLogger(logger *slog.Logger, opts ...LogOptions) {
+	sl := &scriptLogger{e: e, logger: logger, last: time.Now()}
+	if len(opts) > 0 {
+		sl.opts = opts[0]
+	}
+	if sl.opts.Level == nil {
+		sl.opts.Level = slog.LevelInfo
+	}
+	if sl.opts.RateLimit > 0 && sl.opts.Burst < 1 {
+		sl.opts.Burst = int(sl.opts.RateLimit + 0.5)
+		if sl.opts.Burst < 1 {
+			sl.opts.Burst = 1
+		}
+	}
+	sl.tokens = float64(sl.opts.Burst)
+
+	module := e.state.NewTable()
+	levels := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for name, level := range levels {
+		level := level
+		module.RawSetH(glua.LString(name), e.state.NewFunction(func(l *glua.LState) int {
+			if sl.enabled(level) {
+				fields, _ := l.Get(2).(*glua.LTable)
+				sl.log(l, level, l.CheckString(1), fields)
+			}
+
+			return 0
+		}))
+	}
+	e.provide("log", module)
+	e.preload("log", module)
+
+	if sl.opts.RedirectPrint {
+		tostring := e.state.GetGlobal("tostring")
+		e.provide("print", e.state.NewFunction(func(l *glua.LState) int {
+			if !sl.enabled(slog.LevelInfo) {
+				return 0
+			}
+			args := make([]string, l.GetTop())
+			for i := range args {
+				l.Push(tostring)
+				l.Push(l.Get(i + 1))
+				l.Call(1, 1)
+				args[i] = l.ToString(-1)
+				l.Pop(1)
+			}
+			sl.log(l, slog.LevelInfo, strings.Join(args, "\t"), nil)
+
+			return 0
+		}))
+	}
+}
+
+// enabled returns true if records at level are logged.
+func (sl *scriptLogger) enabled(level slog.Level) bool {
+	return level >= sl.opts.Level.Level() && sl.logger.Enabled(context.Background(), level)
+}
+
+// allow returns true if the rate limit lets a record through, along with the
+// number of records dropped since the last one that was.
+func (sl *scriptLogger) allow() (bool, int) {
+	if sl.opts.RateLimit <= 0 {
+		return true, 0
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := time.Now()
+	sl.tokens += now.Sub(sl.last).Seconds() * sl.opts.RateLimit
+	if max := float64(sl.opts.Burst); sl.tokens > max {
+		sl.tokens = max
+	}
+	sl.last = now
+	if sl.tokens < 1 {
+		sl.dropped++
+
+		return false, 0
+	}
+	sl.tokens--
+	dropped := sl.dropped
+	sl.dropped = 0
+
+	return true, dropped
+}
+
+// log logs msg with the fields given by the script calling l.
+func (sl *scriptLogger) log(l *glua.LState, level slog.Level, msg string, fields *glua.LTable) {
+	ok, dropped := sl.allow()
+	if !ok {
+		return
+	}
+
+	attrs := sl.caller(l)
+	if dropped > 0 {
+		attrs = append(attrs, slog.Int("dropped", dropped))
+	}
+	if fields != nil {
+		var keys []string
+		values := make(map[string]glua.LValue)
+		fields.ForEach(func(key, val glua.LValue) {
+			keys = append(keys, key.String())
+			values[key.String()] = val
+		})
+		sort.Strings(keys)
+		for _, key := range keys {
+			attrs = append(attrs, logAttr(key, values[key]))
+		}
+	}
+	sl.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// caller returns the attributes describing the script function calling l.
+func (sl *scriptLogger) caller(l *glua.LState) []slog.Attr {
+	dbg, ok := l.GetStack(1)
+	if !ok {
+		return nil
+	}
+	lv, err := l.GetInfo("Slf", dbg, glua.LNil)
+	if err != nil {
+		return nil
+	}
+	attrs := []slog.Attr{
+		slog.String("chunk", dbg.Source),
+		slog.Int("line", dbg.CurrentLine),
+	}
+	if fn, ok := lv.(*glua.LFunction); ok && sl.e.Secure {
+		if env := l.GetGlobal(sl.e.sandbox.EnvName); env != glua.LNil && glua.LValue(fn.Env) == env {
+			attrs = append(attrs, slog.String("env", sl.e.sandbox.EnvName))
+		}
+	}
+
+	return attrs
+}
+
+// logAttr converts a field logged by a script to an attribute.
+func logAttr(key string, lv glua.LValue) slog.Attr {
+	switch v := lv.(type) {
+	case glua.LString:
+		return slog.String(key, string(v))
+	case glua.LNumber:
+		if n := int64(v); glua.LNumber(n) == v {
+			return slog.Int64(key, n)
+		}
+
+		return slog.Float64(key, float64(v))
+	case glua.LBool:
+		return slog.Bool(key, bool(v))
+	case *glua.LUserData:
+		return slog.Any(key, v.Value)
+	case *glua.LTable:
+		return slog.String(key, newValue(v).Dump(DumpOptions{Compact: true, MaxDepth: 3}))
+	}
+
+	return slog.String(key, lv.String())
+}
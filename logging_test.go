@@ -0,0 +1,116 @@
+package lua_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"time"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		engine *Engine
+		buf    *bytes.Buffer
+		logger *slog.Logger
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		buf = &bytes.Buffer{}
+		logger = slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+
+				return a
+			},
+		}))
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	lines := func() []string {
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	It("should log records with fields and the caller", func() {
+		engine.SetLogger(logger)
+		Expect(engine.LoadString(`
+			log.info("player joined", {name = "ana", room = 12, ratio = 0.5, items = {"sword"}})
+			log.debug("hidden")
+			require("log").error("failed")
+		`)).To(BeNil())
+		Expect(lines()).To(Equal([]string{
+			`level=INFO msg="player joined" chunk=<string> line=2 items="{\"sword\"}" name=ana ratio=0.5 room=12`,
+			`level=ERROR msg=failed chunk=<string> line=4`,
+		}))
+	})
+
+	It("should log the contents of frozen tables", func() {
+		engine.SetLogger(logger)
+		Expect(engine.LoadString(`items = {"sword"}`)).To(BeNil())
+		engine.SetGlobal("items", engine.Freeze(engine.GetGlobal("items")))
+		Expect(engine.LoadString(`log.info("loot", {items = items})`)).To(BeNil())
+		Expect(lines()).To(Equal([]string{
+			`level=INFO msg=loot chunk=<string> line=1 items="{\"sword\"}"`,
+		}))
+	})
+
+	It("should filter by level and redirect print", func() {
+		level := &slog.LevelVar{}
+		level.Set(slog.LevelDebug)
+		engine.SetLogger(logger, LogOptions{Level: level, RedirectPrint: true})
+		Expect(engine.LoadString(`
+			log.debug("shown")
+			print("hello", 1, nil, true)
+		`)).To(BeNil())
+		level.Set(slog.LevelWarn)
+		Expect(engine.LoadString(`
+			log.info("hidden")
+			print("hidden")
+		`)).To(BeNil())
+		Expect(lines()).To(Equal([]string{
+			`level=DEBUG msg=shown chunk=<string> line=2`,
+			`level=INFO msg="hello\t1\tnil\ttrue" chunk=<string> line=3`,
+		}))
+	})
+
+	It("should rate limit records", func() {
+		engine.SetLogger(logger, LogOptions{RateLimit: 50, Burst: 2})
+		Expect(engine.LoadString(`
+			for i = 1, 10 do
+				log.info("spam")
+			end
+		`)).To(BeNil())
+		Expect(lines()).To(HaveLen(2))
+		time.Sleep(50 * time.Millisecond)
+		Expect(engine.LoadString(`log.info("again")`)).To(BeNil())
+		Expect(lines()[2]).To(ContainSubstring("msg=again chunk=<string> line=1 dropped=8"))
+	})
+
+	It("should annotate records from the sandbox", func() {
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		secure.SetLogger(logger, LogOptions{RedirectPrint: true})
+		Expect(secure.LoadString(`
+			function greet()
+				print("hi")
+			end
+		`)).To(BeNil())
+		_, err = secure.Call("greet", 0)
+		Expect(err).To(BeNil())
+		Expect(lines()).To(Equal([]string{
+			`level=INFO msg=hi chunk=<string> line=3 env=__sandbox_env`,
+		}))
+	})
+})
@@ -0,0 +1,180 @@
+package lua
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// FunctionStats holds the metrics recorded for a function. Durations and
+// instruction counts include the functions it calls.
+type FunctionStats struct {
+	Name string
+	// Kind is "lua" for functions run with Call and "go" for registered Go
+	// functions.
+	Kind         string
+	Calls        int64
+	Errors       int64
+	Total        time.Duration
+	Max          time.Duration
+	Instructions int64
+}
+
+// Metrics is a snapshot of the metrics recorded by an Engine.
+type Metrics struct {
+	Since     time.Time
+	Functions []FunctionStats
+}
+
+// metrics records the calls made while metrics are enabled.
+type metrics struct {
+	instructions int64
+	mu           sync.Mutex
+	hook         *vmHook
+	since        time.Time
+	funcs        map[string]*FunctionStats
+}
+
+// EnableMetrics starts recording call counts, errors, durations and VM
+// instructions for the functions run with Call and for registered Go
+// functions. Counting instructions runs a hook after every instruction, which
+// slows scripts down. Enabling metrics again keeps the recorded values. See
+// SetHook for the goroutines it can be called from.
+func (e *Engine) EnableMetrics() {
+	if e.metrics != nil {
+		return
+	}
+	m := &metrics{since: time.Now(), funcs: make(map[string]*FunctionStats)}
+	m.hook = &vmHook{mask: glua.MaskCount, count: 1, fn: m.count}
+	e.metrics = m
+	e.addHook(m.hook)
+}
+
+// DisableMetrics stops recording metrics and discards the values recorded.
+func (e *Engine) DisableMetrics() {
+	if e.metrics == nil {
+		return
+	}
+	e.removeHook(e.metrics.hook)
+	e.metrics = nil
+}
+
+// Stats returns the metrics recorded since EnableMetrics, sorted by name. It
+// can be called while scripts run in another goroutine.
+func (e *Engine) Stats() *Metrics {
+	m := e.metrics
+	if m == nil {
+		return &Metrics{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &Metrics{Since: m.since, Functions: make([]FunctionStats, 0, len(m.funcs))}
+	for _, fs := range m.funcs {
+		stats.Functions = append(stats.Functions, *fs)
+	}
+	sort.Slice(stats.Functions, func(i, j int) bool {
+		a, b := stats.Functions[i], stats.Functions[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.Kind < b.Kind
+	})
+
+	return stats
+}
+
+// count is run as a count hook of the Lua state for each instruction.
+func (m *metrics) count(*glua.LState, glua.HookEvent, int) {
+	atomic.AddInt64(&m.instructions, 1)
+}
+
+// start returns the function recording the end of a call to name.
+func (m *metrics) start(name, kind string) func(failed bool) {
+	start := time.Now()
+	instructions := atomic.LoadInt64(&m.instructions)
+
+	return func(failed bool) {
+		elapsed := time.Since(start)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		key := kind + "\x00" + name
+		fs, ok := m.funcs[key]
+		if !ok {
+			fs = &FunctionStats{Name: name, Kind: kind}
+			m.funcs[key] = fs
+		}
+		fs.Calls++
+		if failed {
+			fs.Errors++
+		}
+		fs.Total += elapsed
+		if elapsed > fs.Max {
+			fs.Max = elapsed
+		}
+		fs.Instructions += atomic.LoadInt64(&m.instructions) - instructions
+	}
+}
+
+// goCall runs the Go function fn on behalf of the Lua state, recording it
+// under name. Errors are raised as panics, which are recorded and passed on.
+func (m *metrics) goCall(l *glua.LState, name string, fn glua.LGFunction) int {
+	done := m.start(name, "go")
+	failed := true
+	defer func() {
+		done(failed)
+	}()
+	n := fn(l)
+	failed = false
+
+	return n
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format,
+// with metric names starting with lua_ and the function name and kind as
+// labels.
+func (s *Metrics) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name, typ, help string
+		value           func(FunctionStats) string
+	}{
+		{"lua_function_calls_total", "counter", "Number of calls of the function.",
+			func(fs FunctionStats) string { return fmt.Sprint(fs.Calls) }},
+		{"lua_function_errors_total", "counter", "Number of calls of the function that raised an error.",
+			func(fs FunctionStats) string { return fmt.Sprint(fs.Errors) }},
+		{"lua_function_duration_seconds_total", "counter", "Time spent running the function.",
+			func(fs FunctionStats) string { return fmt.Sprint(fs.Total.Seconds()) }},
+		{"lua_function_duration_seconds_max", "gauge", "Longest run of the function.",
+			func(fs FunctionStats) string { return fmt.Sprint(fs.Max.Seconds()) }},
+		{"lua_function_instructions_total", "counter", "Number of VM instructions run by the function.",
+			func(fs FunctionStats) string { return fmt.Sprint(fs.Instructions) }},
+	}
+
+	for _, metric := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.typ); err != nil {
+			return err
+		}
+		for _, fs := range s.Functions {
+			_, err := fmt.Fprintf(w, "%s{function=\"%s\",kind=\"%s\"} %s\n",
+				metric.name, labelValue(fs.Name), fs.Kind, metric.value(fs))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// labelValue escapes s for use as a Prometheus label value.
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,85 @@
+package lua_test
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		Bind1(engine, "check", func(n int) (int, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+
+			return n, nil
+		})
+		Expect(engine.LoadString(`
+			function update(n)
+				local total = 0
+				for i = 1, 100 do
+					total = total + i
+				end
+				return check(n)
+			end
+		`)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should record calls of Lua and Go functions", func() {
+		_, err := engine.Call("update", 1, 1)
+		Expect(err).To(BeNil())
+		Expect(engine.Stats().Functions).To(BeEmpty())
+
+		engine.EnableMetrics()
+		for _, n := range []int{1, 2, -1} {
+			engine.Call("update", 1, n)
+		}
+
+		stats := engine.Stats().Functions
+		Expect(stats).To(HaveLen(2))
+		check, update := stats[0], stats[1]
+		Expect(check.Name).To(Equal("check"))
+		Expect(check.Kind).To(Equal("go"))
+		Expect(check.Calls).To(Equal(int64(3)))
+		Expect(check.Errors).To(Equal(int64(1)))
+		Expect(check.Instructions).To(Equal(int64(0)))
+
+		Expect(update.Name).To(Equal("update"))
+		Expect(update.Kind).To(Equal("lua"))
+		Expect(update.Calls).To(Equal(int64(3)))
+		Expect(update.Errors).To(Equal(int64(1)))
+		Expect(update.Instructions).To(BeNumerically(">", 3*100))
+		Expect(update.Max).To(BeNumerically(">", 0))
+		Expect(update.Total).To(BeNumerically(">=", update.Max))
+
+		engine.DisableMetrics()
+		Expect(engine.Stats().Functions).To(BeEmpty())
+	})
+
+	It("should write Prometheus text", func() {
+		engine.EnableMetrics()
+		engine.Call("update", 1, 1)
+
+		var buf bytes.Buffer
+		Expect(engine.Stats().WritePrometheus(&buf)).To(BeNil())
+		out := buf.String()
+		Expect(out).To(ContainSubstring("# TYPE lua_function_calls_total counter\n" +
+			"lua_function_calls_total{function=\"check\",kind=\"go\"} 1\n" +
+			"lua_function_calls_total{function=\"update\",kind=\"lua\"} 1\n"))
+		Expect(out).To(ContainSubstring("lua_function_errors_total{function=\"update\",kind=\"lua\"} 0\n"))
+		Expect(out).To(ContainSubstring("# TYPE lua_function_duration_seconds_max gauge\n"))
+		Expect(out).To(MatchRegexp(`lua_function_instructions_total\{function="update",kind="lua"\} \d+\n`))
+	})
+})
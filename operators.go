@@ -0,0 +1,249 @@
+package lua
+
+import (
+	"strings"
+
+	"github.com/layeh/gopher-luar"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// The binary operator interfaces are given the other operand as a Go value:
+// userdata as the value it holds, numbers as float64, strings as string and
+// tables and functions as *Value. reversed is true when the receiver is the
+// right operand, as in 2 * v. Results are converted with gopher-luar and
+// returned errors are raised in Lua.
+
+// LuaAdder is implemented by registered types that support + in Lua.
+type LuaAdder interface {
+	LuaAdd(other interface{}, reversed bool) (interface{}, error)
+}
+
+// LuaSubtracter is implemented by registered types that support binary - in
+// Lua.
+type LuaSubtracter interface {
+	LuaSub(other interface{}, reversed bool) (interface{}, error)
+}
+
+// LuaMultiplier is implemented by registered types that support * in Lua.
+type LuaMultiplier interface {
+	LuaMul(other interface{}, reversed bool) (interface{}, error)
+}
+
+// LuaConcater is implemented by registered types that support .. in Lua.
+type LuaConcater interface {
+	LuaConcat(other interface{}, reversed bool) (interface{}, error)
+}
+
+// LuaNegater is implemented by registered types that support unary - in Lua.
+type LuaNegater interface {
+	LuaNeg() (interface{}, error)
+}
+
+// LuaLener is implemented by registered types that support # in Lua.
+type LuaLener interface {
+	LuaLen() int
+}
+
+// LuaComparer is implemented by registered types that can be compared with ==,
+// < and <= in Lua. LuaCompare returns a negative number, zero or a positive
+// number when the receiver is less than, equal to or greater than other.
+type LuaComparer interface {
+	LuaCompare(other interface{}) (int, error)
+}
+
+// binaryOp calls the Go implementation of an operator on recv, it returns
+// false if recv doesn't implement it.
+type binaryOp func(recv, other interface{}, reversed bool) (interface{}, bool, error)
+
+// binaryOps are the operator metamethods of registered types with two
+// operands, other than the comparisons.
+var binaryOps = map[string]binaryOp{
+	"__add": func(recv, other interface{}, reversed bool) (interface{}, bool, error) {
+		if op, ok := recv.(LuaAdder); ok {
+			res, err := op.LuaAdd(other, reversed)
+
+			return res, true, err
+		}
+
+		return nil, false, nil
+	},
+	"__sub": func(recv, other interface{}, reversed bool) (interface{}, bool, error) {
+		if op, ok := recv.(LuaSubtracter); ok {
+			res, err := op.LuaSub(other, reversed)
+
+			return res, true, err
+		}
+
+		return nil, false, nil
+	},
+	"__mul": func(recv, other interface{}, reversed bool) (interface{}, bool, error) {
+		if op, ok := recv.(LuaMultiplier); ok {
+			res, err := op.LuaMul(other, reversed)
+
+			return res, true, err
+		}
+
+		return nil, false, nil
+	},
+	"__concat": func(recv, other interface{}, reversed bool) (interface{}, bool, error) {
+		if op, ok := recv.(LuaConcater); ok {
+			res, err := op.LuaConcat(other, reversed)
+
+			return res, true, err
+		}
+
+		return nil, false, nil
+	},
+}
+
+// typeBinaryOp returns the metamethod for the operator event of registered
+// types. The left operand is tried before the right one, each using a method
+// of its Lua class before the Go interface.
+func (e *Engine) typeBinaryOp(event string, op binaryOp) func(glua.LGFunction) glua.LGFunction {
+	return func(fallback glua.LGFunction) glua.LGFunction {
+		return func(l *glua.LState) int {
+			for i := 1; i <= 2; i++ {
+				ud, ok := l.Get(i).(*glua.LUserData)
+				if !ok {
+					continue
+				}
+				info, _ := e.typeOf(l, i)
+				if info == nil {
+					continue
+				}
+				if fn := e.classMember(ud, event); fn != glua.LNil {
+					return callMeta(l, fn)
+				}
+				res, ok, err := op(ud.Value, operand(l.Get(3-i)), i == 2)
+				if !ok {
+					continue
+				}
+				if err != nil {
+					l.RaiseError("%s", err.Error())
+				}
+				l.Push(operatorResult(l, res))
+
+				return 1
+			}
+			if fallback != nil {
+				return fallback(l)
+			}
+			l.RaiseError("cannot perform %s operation between %s and %s",
+				strings.TrimLeft(event, "_"), l.Get(1).Type(), l.Get(2).Type())
+
+			return 0
+		}
+	}
+}
+
+// typeUnm returns the __unm metamethod for registered types, it uses an __unm
+// method of the Lua class or the LuaNegater interface.
+func (e *Engine) typeUnm(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		if info, ref := e.typeOf(l, 1); info != nil {
+			if fn := e.classMember(l.ToUserData(1), "__unm"); fn != glua.LNil {
+				return callMeta(l, fn)
+			}
+			if neg, ok := ref.Interface().(LuaNegater); ok {
+				res, err := neg.LuaNeg()
+				if err != nil {
+					l.RaiseError("%s", err.Error())
+				}
+				l.Push(operatorResult(l, res))
+
+				return 1
+			}
+		}
+		if fallback != nil {
+			return fallback(l)
+		}
+		l.RaiseError("__unm undefined")
+
+		return 0
+	}
+}
+
+// typeLen returns the __len metamethod for registered types, it uses a __len
+// method of the Lua class or the LuaLener interface.
+func (e *Engine) typeLen(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		if info, ref := e.typeOf(l, 1); info != nil {
+			if fn := e.classMember(l.ToUserData(1), "__len"); fn != glua.LNil {
+				return callMeta(l, fn)
+			}
+			if lener, ok := ref.Interface().(LuaLener); ok {
+				l.Push(glua.LNumber(lener.LuaLen()))
+
+				return 1
+			}
+		}
+		if fallback != nil {
+			return fallback(l)
+		}
+		l.RaiseError("__len undefined")
+
+		return 0
+	}
+}
+
+// typeLe returns the __le metamethod for registered types, it uses an __le
+// method of the Lua class or the LuaComparer interface. Other values are
+// compared as not b < a, like Lua does when there's no __le metamethod.
+func (e *Engine) typeLe(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		if info, ref := e.typeOf(l, 1); info != nil {
+			if fn := e.classMember(l.ToUserData(1), "__le"); fn != glua.LNil {
+				return callMeta(l, fn)
+			}
+			if cmp, ok := ref.Interface().(LuaComparer); ok {
+				l.Push(glua.LBool(compare(l, cmp) <= 0))
+
+				return 1
+			}
+		}
+		if fallback != nil {
+			return fallback(l)
+		}
+		l.Push(glua.LBool(!l.LessThan(l.Get(2), l.Get(1))))
+
+		return 1
+	}
+}
+
+// compare compares the operands of the comparison being run with cmp, the
+// implementation of the left one.
+func compare(l *glua.LState, cmp LuaComparer) int {
+	c, err := cmp.LuaCompare(l.CheckUserData(2).Value)
+	if err != nil {
+		l.RaiseError("%s", err.Error())
+	}
+
+	return c
+}
+
+// operand returns the Go value given to operator interfaces for lv.
+func operand(lv glua.LValue) interface{} {
+	switch v := lv.(type) {
+	case *glua.LNilType:
+		return nil
+	case glua.LBool:
+		return bool(v)
+	case glua.LNumber:
+		return float64(v)
+	case glua.LString:
+		return string(v)
+	case *glua.LUserData:
+		return v.Value
+	}
+
+	return newValue(lv)
+}
+
+// operatorResult converts the result of an operator interface to a Lua value.
+func operatorResult(l *glua.LState, res interface{}) glua.LValue {
+	if v, ok := res.(*Value); ok {
+		return v.lval
+	}
+
+	return luar.New(l, res)
+}
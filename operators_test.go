@@ -0,0 +1,150 @@
+package lua_test
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type Vector struct {
+	X, Y float64
+}
+
+func (v *Vector) LuaAdd(other interface{}, reversed bool) (interface{}, error) {
+	o, ok := other.(*Vector)
+	if !ok {
+		return nil, errors.New("can only add vectors")
+	}
+
+	return &Vector{v.X + o.X, v.Y + o.Y}, nil
+}
+
+func (v *Vector) LuaSub(other interface{}, reversed bool) (interface{}, error) {
+	o, ok := other.(*Vector)
+	if !ok {
+		return nil, errors.New("can only subtract vectors")
+	}
+	if reversed {
+		return &Vector{o.X - v.X, o.Y - v.Y}, nil
+	}
+
+	return &Vector{v.X - o.X, v.Y - o.Y}, nil
+}
+
+func (v *Vector) LuaMul(other interface{}, reversed bool) (interface{}, error) {
+	n, ok := other.(float64)
+	if !ok {
+		return nil, errors.New("can only scale vectors by numbers")
+	}
+
+	return &Vector{v.X * n, v.Y * n}, nil
+}
+
+func (v *Vector) LuaNeg() (interface{}, error) {
+	return &Vector{-v.X, -v.Y}, nil
+}
+
+func (v *Vector) LuaLen() int {
+	return 2
+}
+
+func (v *Vector) LuaConcat(other interface{}, reversed bool) (interface{}, error) {
+	s := fmt.Sprintf("(%g, %g)", v.X, v.Y)
+	if reversed {
+		return fmt.Sprint(other) + s, nil
+	}
+
+	return s + fmt.Sprint(other), nil
+}
+
+func (v *Vector) LuaCompare(other interface{}) (int, error) {
+	o, ok := other.(*Vector)
+	if !ok {
+		return 0, errors.New("can only compare vectors")
+	}
+	a, b := v.X*v.X+v.Y*v.Y, o.X*o.X+o.Y*o.Y
+	switch {
+	case a < b:
+		return -1, nil
+	case a > b:
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+var _ = Describe("Operators", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		engine.RegisterClassWithCtor("Vector", Vector{}, func(x, y float64) *Vector {
+			return &Vector{x, y}
+		})
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should support arithmetic", func() {
+		Expect(engine.LoadString(`
+			a, b = Vector.new(1, 2), Vector.new(3, 5)
+			sum, diff, scaled, rscaled, neg = a + b, b - a, a * 3, 2 * a, -a
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("sum").Interface()).To(Equal(&Vector{4, 7}))
+		Expect(engine.GetGlobal("diff").Interface()).To(Equal(&Vector{2, 3}))
+		Expect(engine.GetGlobal("scaled").Interface()).To(Equal(&Vector{3, 6}))
+		Expect(engine.GetGlobal("rscaled").Interface()).To(Equal(&Vector{2, 4}))
+		Expect(engine.GetGlobal("neg").Interface()).To(Equal(&Vector{-1, -2}))
+	})
+
+	It("should support length and concatenation", func() {
+		Expect(engine.LoadString(`
+			v = Vector.new(1, 2)
+			len, left, right = #v, "at " .. v, v .. "!"
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("len").AsNumber()).To(Equal(float64(2)))
+		Expect(engine.GetGlobal("left").AsString()).To(Equal("at (1, 2)"))
+		Expect(engine.GetGlobal("right").AsString()).To(Equal("(1, 2)!"))
+	})
+
+	It("should support comparisons", func() {
+		Expect(engine.LoadString(`
+			a, b, c = Vector.new(1, 0), Vector.new(0, 2), Vector.new(0, 1)
+			checks = {a < b, b < a, a <= c, b <= a, a > c, b >= a}
+		`)).To(BeNil())
+		checks := engine.GetGlobal("checks")
+		var got []bool
+		checks.ForEach(func(_, v *Value) {
+			got = append(got, v.AsBool())
+		})
+		Expect(got).To(Equal([]bool{true, false, true, false, false, true}))
+	})
+
+	It("should raise errors returned by operators", func() {
+		err := engine.LoadString(`v = Vector.new(1, 2) + 1`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("can only add vectors"))
+	})
+
+	It("should let Lua classes override operators", func() {
+		Expect(engine.LoadString(`
+			Point = Vector:extend()
+			function Point.__add(a, b) return "points" end
+			sum = Point.new(1, 2) + Vector.new(1, 1)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("sum").AsString()).To(Equal("points"))
+	})
+
+	It("should keep failing for types without operators", func() {
+		engine.RegisterClass("NPC", NPC{})
+		err := engine.LoadString(`v = NPC.new() + NPC.new()`)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot perform add operation"))
+	})
+})
@@ -0,0 +1,173 @@
+package lua
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// The pprof format is a gzipped protocol buffer, the encoder below only
+// implements the small subset of the wire format that the profile.proto
+// messages need so no protobuf dependency is required.
+
+// protoBuffer accumulates an encoded protocol buffer message.
+type protoBuffer struct {
+	data []byte
+}
+
+// varint appends an unsigned varint to the buffer.
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// key appends a field key with the given wire type.
+func (b *protoBuffer) key(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// uint64Field appends a varint field, zero values are omitted.
+func (b *protoBuffer) uint64Field(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(x)
+}
+
+// int64Field appends a signed (non-zigzag) varint field.
+func (b *protoBuffer) int64Field(field int, x int64) {
+	b.uint64Field(field, uint64(x))
+}
+
+// packedField appends a packed repeated varint field.
+func (b *protoBuffer) packedField(field int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(field, packed.data)
+}
+
+// bytesField appends a length delimited field.
+func (b *protoBuffer) bytesField(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// pprofStrings builds the string table of a pprof profile.
+type pprofStrings struct {
+	index map[string]int64
+	table []string
+}
+
+// newPprofStrings creates a string table, the first entry of which must be
+// the empty string.
+func newPprofStrings() *pprofStrings {
+	return &pprofStrings{
+		index: map[string]int64{"": 0},
+		table: []string{""},
+	}
+}
+
+// id returns the string table index for s, adding it if necessary.
+func (s *pprofStrings) id(str string) int64 {
+	if i, ok := s.index[str]; ok {
+		return i
+	}
+	i := int64(len(s.table))
+	s.index[str] = i
+	s.table = append(s.table, str)
+
+	return i
+}
+
+// writePprof encodes the profile as gzipped profile.proto data.
+func writePprof(w io.Writer, p *Profile) error {
+	var (
+		buf       protoBuffer
+		strs      = newPprofStrings()
+		functions = make(map[ProfileFrame]uint64)
+		locations = make(map[ProfileFrame]uint64)
+		funcOrder []ProfileFrame
+		locOrder  []ProfileFrame
+	)
+
+	valueType := func(typ, unit string) []byte {
+		var vt protoBuffer
+		vt.int64Field(1, strs.id(typ))
+		vt.int64Field(2, strs.id(unit))
+
+		return vt.data
+	}
+
+	buf.bytesField(1, valueType("samples", "count"))
+	buf.bytesField(1, valueType("calls", "count"))
+	buf.bytesField(1, valueType("instructions", "count"))
+	buf.bytesField(1, valueType("time", "nanoseconds"))
+
+	for _, sample := range p.Samples {
+		ids := make([]uint64, len(sample.Stack))
+		for i, frame := range sample.Stack {
+			fn := ProfileFrame{Function: frame.Function, Source: frame.Source, LineDefined: frame.LineDefined}
+			if _, ok := functions[fn]; !ok {
+				functions[fn] = uint64(len(functions) + 1)
+				funcOrder = append(funcOrder, fn)
+			}
+			if _, ok := locations[frame]; !ok {
+				locations[frame] = uint64(len(locations) + 1)
+				locOrder = append(locOrder, frame)
+			}
+			ids[i] = locations[frame]
+		}
+
+		var s protoBuffer
+		s.packedField(1, ids)
+		s.packedField(2, []uint64{uint64(sample.Count), uint64(sample.Calls), uint64(sample.Instructions), uint64(sample.Nanoseconds)})
+		buf.bytesField(2, s.data)
+	}
+
+	for _, frame := range locOrder {
+		fn := ProfileFrame{Function: frame.Function, Source: frame.Source, LineDefined: frame.LineDefined}
+		var line protoBuffer
+		line.uint64Field(1, functions[fn])
+		line.int64Field(2, int64(frame.Line))
+
+		var loc protoBuffer
+		loc.uint64Field(1, locations[frame])
+		loc.bytesField(4, line.data)
+		buf.bytesField(4, loc.data)
+	}
+
+	for _, fn := range funcOrder {
+		var f protoBuffer
+		f.uint64Field(1, functions[fn])
+		f.int64Field(2, strs.id(fn.Function))
+		f.int64Field(3, strs.id(fn.Function))
+		f.int64Field(4, strs.id(fn.Source))
+		f.int64Field(5, int64(fn.LineDefined))
+		buf.bytesField(5, f.data)
+	}
+
+	periodType := valueType("instructions", "count")
+	for _, str := range strs.table {
+		buf.bytesField(6, []byte(str))
+	}
+	buf.int64Field(9, p.Start.UnixNano())
+	buf.int64Field(10, int64(p.Duration))
+	buf.bytesField(11, periodType)
+	buf.int64Field(12, int64(p.Rate))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(buf.data); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,234 @@
+package lua
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DefaultProfileRate is the number of VM instructions executed between stack
+// samples when StartProfiler is given a non-positive rate.
+const DefaultProfileRate = 1000
+
+// goFrameSource is the source name used for frames representing registered Go
+// functions in a profile.
+const goFrameSource = "[Go]"
+
+var (
+	// ErrProfilerRunning is returned when starting a profiler on an Engine that
+	// is already being profiled.
+	ErrProfilerRunning = errors.New("profiler already running")
+
+	// ErrProfilerNotRunning is returned when stopping a profiler on an Engine
+	// that isn't being profiled.
+	ErrProfilerNotRunning = errors.New("profiler not running")
+)
+
+// ProfileFrame describes a single function call in a sampled stack.
+type ProfileFrame struct {
+	Function    string
+	Source      string
+	Line        int
+	LineDefined int
+}
+
+// IsGo returns true if the frame represents a registered Go function.
+func (f ProfileFrame) IsGo() bool {
+	return f.Source == goFrameSource
+}
+
+// ProfileSample is an aggregated set of samples taken with the same stack. The
+// first frame in Stack is the innermost call.
+type ProfileSample struct {
+	Stack []ProfileFrame
+	// Count is the number of VM samples taken with the stack.
+	Count int64
+	// Calls is the number of calls of the registered Go function at the top
+	// of the stack, it's zero for stacks of Lua frames.
+	Calls        int64
+	Instructions int64
+	Nanoseconds  int64
+}
+
+// Profile is the result of profiling an Engine.
+type Profile struct {
+	Start    time.Time
+	Duration time.Duration
+	Rate     int
+	Samples  []ProfileSample
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format
+// understood by `go tool pprof`.
+func (p *Profile) WritePprof(w io.Writer) error {
+	return writePprof(w, p)
+}
+
+// Profiler samples the Lua call stack of an Engine every Rate instructions and
+// records the time spent in registered Go functions separately.
+type Profiler struct {
+	mu      sync.Mutex
+	hook    *vmHook
+	rate    int
+	start   time.Time
+	last    time.Time
+	goTime  time.Duration
+	samples map[string]*ProfileSample
+	order   []string
+}
+
+// newProfiler creates a profiler sampling every rate instructions.
+func newProfiler(rate int) *Profiler {
+	if rate <= 0 {
+		rate = DefaultProfileRate
+	}
+	now := time.Now()
+	p := &Profiler{
+		rate:    rate,
+		start:   now,
+		last:    now,
+		samples: make(map[string]*ProfileSample),
+	}
+	p.hook = &vmHook{mask: glua.MaskCount, count: rate, fn: p.sample}
+
+	return p
+}
+
+// StartProfiler begins sampling the Lua code running in the Engine. A rate of
+// zero or less uses DefaultProfileRate. See SetHook for the goroutines it can
+// be called from.
+func (e *Engine) StartProfiler(rate int) error {
+	if e.profiler != nil {
+		return ErrProfilerRunning
+	}
+	e.profiler = newProfiler(rate)
+	e.addHook(e.profiler.hook)
+
+	return nil
+}
+
+// StopProfiler stops sampling and returns the collected profile.
+func (e *Engine) StopProfiler() (*Profile, error) {
+	if e.profiler == nil {
+		return nil, ErrProfilerNotRunning
+	}
+	e.removeHook(e.profiler.hook)
+	prof := e.profiler.profile()
+	e.profiler = nil
+
+	return prof, nil
+}
+
+// sample is run as a count hook of the Lua state and records a sample of the
+// current stack.
+func (p *Profiler) sample(l *glua.LState, _ glua.HookEvent, _ int) {
+	now := time.Now()
+	stack := luaStack(l)
+
+	p.mu.Lock()
+	elapsed := now.Sub(p.last) - p.goTime
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	p.last = now
+	p.goTime = 0
+	p.record(stack, 1, 0, int64(p.rate), int64(elapsed))
+	p.mu.Unlock()
+}
+
+// goCall runs the Go function fn on behalf of the Lua state, attributing the
+// time spent to a Go frame named name.
+func (p *Profiler) goCall(l *glua.LState, name string, fn glua.LGFunction) int {
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		// the innermost frame is the anonymous wrapper being run, it's replaced
+		// by a frame carrying the registered name
+		stack := luaStack(l)
+		if len(stack) > 0 && stack[0].IsGo() {
+			stack = stack[1:]
+		}
+		stack = append([]ProfileFrame{{Function: name, Source: goFrameSource}}, stack...)
+
+		p.mu.Lock()
+		p.goTime += elapsed
+		p.record(stack, 0, 1, 0, int64(elapsed))
+		p.mu.Unlock()
+	}()
+
+	return fn(l)
+}
+
+// record adds the values to the sample for stack, the lock must be held.
+func (p *Profiler) record(stack []ProfileFrame, count, calls, instructions, nanos int64) {
+	key := stackKey(stack)
+	sample, ok := p.samples[key]
+	if !ok {
+		sample = &ProfileSample{Stack: stack}
+		p.samples[key] = sample
+		p.order = append(p.order, key)
+	}
+	sample.Count += count
+	sample.Calls += calls
+	sample.Instructions += instructions
+	sample.Nanoseconds += nanos
+}
+
+// profile builds a Profile from the samples collected so far.
+func (p *Profiler) profile() *Profile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prof := &Profile{
+		Start:    p.start,
+		Duration: time.Since(p.start),
+		Rate:     p.rate,
+		Samples:  make([]ProfileSample, 0, len(p.order)),
+	}
+	for _, key := range p.order {
+		prof.Samples = append(prof.Samples, *p.samples[key])
+	}
+
+	return prof
+}
+
+// luaStack walks the call stack of the Lua state, innermost frame first.
+func luaStack(l *glua.LState) []ProfileFrame {
+	var stack []ProfileFrame
+	for level := 0; ; level++ {
+		dbg, ok := l.GetStack(level)
+		if !ok {
+			break
+		}
+		if _, err := l.GetInfo("nSl", dbg, glua.LNil); err != nil {
+			break
+		}
+		frame := ProfileFrame{
+			Function:    frameName(l, dbg),
+			Source:      dbg.Source,
+			Line:        dbg.CurrentLine,
+			LineDefined: dbg.LineDefined,
+		}
+		if dbg.What == "G" {
+			frame.Source = goFrameSource
+		}
+		stack = append(stack, frame)
+	}
+
+	return stack
+}
+
+// stackKey produces a map key unique to the given stack.
+func stackKey(stack []ProfileFrame) string {
+	parts := make([]string, len(stack))
+	for i, f := range stack {
+		parts[i] = strings.Join([]string{f.Function, f.Source, strconv.Itoa(f.Line), strconv.Itoa(f.LineDefined)}, "\x00")
+	}
+
+	return strings.Join(parts, "\x01")
+}
@@ -0,0 +1,101 @@
+package lua_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiler", func() {
+	var (
+		engine *Engine
+		script = `
+			function fib(n)
+				if n < 2 then
+					return n
+				end
+				return fib(n - 2) + fib(n - 1)
+			end
+
+			function slow_add(a, b)
+				return add(a, b)
+			end
+		`
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		engine.RegisterFunc("add", func(a, b float64) float64 {
+			return a + b
+		})
+		Expect(engine.LoadString(script)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should not stop when it isn't running", func() {
+		_, err := engine.StopProfiler()
+		Expect(err).To(Equal(ErrProfilerNotRunning))
+	})
+
+	It("should not start twice", func() {
+		Expect(engine.StartProfiler(10)).To(BeNil())
+		Expect(engine.StartProfiler(10)).To(Equal(ErrProfilerRunning))
+	})
+
+	Context("when sampling Lua code", func() {
+		var profile *Profile
+
+		BeforeEach(func() {
+			Expect(engine.StartProfiler(10)).To(BeNil())
+			_, err := engine.Call("fib", 1, 15)
+			Expect(err).To(BeNil())
+			_, err = engine.Call("slow_add", 1, 1, 2)
+			Expect(err).To(BeNil())
+			profile, err = engine.StopProfiler()
+			Expect(err).To(BeNil())
+		})
+
+		It("should record Lua frames", func() {
+			found := false
+			for _, sample := range profile.Samples {
+				Expect(sample.Stack).ToNot(BeEmpty())
+				if sample.Stack[0].Function == "fib" {
+					found = true
+					Expect(sample.Instructions).To(BeNumerically(">", 0))
+				}
+			}
+			Expect(found).To(BeTrue())
+		})
+
+		It("should record registered Go functions separately", func() {
+			found := false
+			for _, sample := range profile.Samples {
+				if sample.Stack[0].IsGo() && sample.Stack[0].Function == "add" {
+					found = true
+					Expect(sample.Count).To(Equal(int64(0)))
+					Expect(sample.Calls).To(Equal(int64(1)))
+					Expect(sample.Instructions).To(Equal(int64(0)))
+				}
+			}
+			Expect(found).To(BeTrue())
+		})
+
+		It("should write gzipped pprof data", func() {
+			var buf bytes.Buffer
+			Expect(profile.WritePprof(&buf)).To(BeNil())
+			zr, err := gzip.NewReader(&buf)
+			Expect(err).To(BeNil())
+			data, err := ioutil.ReadAll(zr)
+			Expect(err).To(BeNil())
+			Expect(data).ToNot(BeEmpty())
+		})
+	})
+})
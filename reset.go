@@ -0,0 +1,205 @@
+package lua
+
+import (
+	"errors"
+	"reflect"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// ErrNoCheckpoint is returned when resetting an Engine that has no
+// checkpoint.
+var ErrNoCheckpoint = errors.New("engine has no checkpoint")
+
+// checkpoint is the state of an Engine recorded by Checkpoint.
+type checkpoint struct {
+	tables        map[*glua.LTable]*tableState
+	envs          map[*glua.LFunction]*glua.LTable
+	upvalues      map[*glua.Upvalue]glua.LValue
+	securedFns    map[string]struct{}
+	registrations []*registration
+	registered    map[string]int
+	docs          map[string]string
+	typesHooked   bool
+	types         map[reflect.Type]*typeInfo
+	typeClasses   map[*typeInfo]*glua.LTable
+	enums         map[reflect.Type]*enum
+	classes       map[*glua.LTable]*class
+	funcTypes     map[*glua.LFunction]reflect.Type
+}
+
+// tableState is the contents of a table recorded by Checkpoint.
+type tableState struct {
+	keys, values []glua.LValue
+	metatable    glua.LValue
+}
+
+// Checkpoint records the state of the Engine for Reset to return to. It's
+// meant to be called once the Engine is set up, with its functions, types and
+// modules registered and its scripts loaded. Calling it again replaces the
+// recorded state.
+//
+// The contents of every table reachable from the globals and package.loaded
+// are recorded, along with the environments of the functions reachable from
+// them and the local variables those functions capture.
+func (e *Engine) Checkpoint() {
+	cp := &checkpoint{
+		tables:        make(map[*glua.LTable]*tableState),
+		envs:          make(map[*glua.LFunction]*glua.LTable),
+		upvalues:      make(map[*glua.Upvalue]glua.LValue),
+		securedFns:    make(map[string]struct{}, len(e.securedFns)),
+		registrations: append([]*registration(nil), e.registrations...),
+		registered:    make(map[string]int, len(e.registered)),
+		docs:          make(map[string]string, len(e.docs)),
+		typesHooked:   e.typesHooked,
+		types:         make(map[reflect.Type]*typeInfo, len(e.types)),
+		typeClasses:   make(map[*typeInfo]*glua.LTable, len(e.types)),
+		enums:         make(map[reflect.Type]*enum, len(e.enums)),
+		classes:       make(map[*glua.LTable]*class, len(e.classes)),
+		funcTypes:     make(map[*glua.LFunction]reflect.Type, len(e.funcTypes)),
+	}
+	for name := range e.securedFns {
+		cp.securedFns[name] = struct{}{}
+	}
+	for key, i := range e.registered {
+		cp.registered[key] = i
+	}
+	for name, doc := range e.docs {
+		cp.docs[name] = doc
+	}
+	for t, info := range e.types {
+		cp.types[t] = info
+		cp.typeClasses[info] = info.class
+	}
+	for t, en := range e.enums {
+		cp.enums[t] = en
+	}
+	for t, c := range e.classes {
+		cp.classes[t] = c
+	}
+	for fn, t := range e.funcTypes {
+		cp.funcTypes[fn] = t
+	}
+
+	cp.record(e.state.G.Global)
+	cp.record(e.state.Get(glua.RegistryIndex))
+	cp.record(e.state.GetMetatable(glua.LString("")))
+	e.checkpoint = cp
+}
+
+// Reset returns the Engine to the state recorded by Checkpoint, without
+// creating a new Lua state. Globals, modules and sandbox environments get
+// back the values they had, tables and coroutines created since are dropped,
+// the stack is emptied and functions are secured again when they're called.
+// Types, enums and classes registered or extended since are forgotten.
+//
+// Go values held by userdata aren't reset, nor are tables kept only by Go
+// code, and coroutines that existed at the checkpoint keep running from where
+// they are. Reset must not be called while scripts run.
+func (e *Engine) Reset() error {
+	cp := e.checkpoint
+	if cp == nil {
+		return ErrNoCheckpoint
+	}
+
+	for t, st := range cp.tables {
+		var keys []glua.LValue
+		t.ForEach(func(key, _ glua.LValue) {
+			keys = append(keys, key)
+		})
+		for _, key := range keys {
+			t.RawSet(key, glua.LNil)
+		}
+		for i, key := range st.keys {
+			t.RawSet(key, st.values[i])
+		}
+		t.Metatable = st.metatable
+	}
+	for fn, env := range cp.envs {
+		fn.Env = env
+	}
+	for uv, lv := range cp.upvalues {
+		uv.SetValue(lv)
+	}
+
+	e.securedFns = make(map[string]struct{}, len(cp.securedFns))
+	for name := range cp.securedFns {
+		e.securedFns[name] = struct{}{}
+	}
+	e.registrations = append(e.registrations[:0:0], cp.registrations...)
+	e.registered = make(map[string]int, len(cp.registered))
+	for key, i := range cp.registered {
+		e.registered[key] = i
+	}
+	e.docs = make(map[string]string, len(cp.docs))
+	for name, doc := range cp.docs {
+		e.docs[name] = doc
+	}
+	// the metatables of gopher-luar are restored along with the registry
+	e.typesHooked = cp.typesHooked
+	e.types = make(map[reflect.Type]*typeInfo, len(cp.types))
+	for t, info := range cp.types {
+		e.types[t] = info
+		info.class = cp.typeClasses[info]
+	}
+	e.enums = make(map[reflect.Type]*enum, len(cp.enums))
+	for t, en := range cp.enums {
+		e.enums[t] = en
+	}
+	e.classes = make(map[*glua.LTable]*class, len(cp.classes))
+	for t, c := range cp.classes {
+		e.classes[t] = c
+	}
+	e.funcTypes = make(map[*glua.LFunction]reflect.Type, len(cp.funcTypes))
+	for fn, t := range cp.funcTypes {
+		e.funcTypes[fn] = t
+	}
+	e.state.SetTop(0)
+	e.ctx = nil
+
+	return nil
+}
+
+// record records lv and the values reachable from it.
+func (cp *checkpoint) record(lv glua.LValue) {
+	switch v := lv.(type) {
+	case *glua.LTable:
+		if _, ok := cp.tables[v]; ok {
+			return
+		}
+		st := &tableState{metatable: v.Metatable}
+		cp.tables[v] = st
+		v.ForEach(func(key, val glua.LValue) {
+			st.keys = append(st.keys, key)
+			st.values = append(st.values, val)
+		})
+		for i, key := range st.keys {
+			cp.record(key)
+			cp.record(st.values[i])
+		}
+		cp.record(v.Metatable)
+	case *glua.LFunction:
+		if _, ok := cp.envs[v]; ok {
+			return
+		}
+		cp.envs[v] = v.Env
+		if v.Env != nil {
+			cp.record(v.Env)
+		}
+		for _, uv := range v.Upvalues {
+			if uv == nil || !uv.IsClosed() {
+				continue
+			}
+			if _, ok := cp.upvalues[uv]; ok {
+				continue
+			}
+			cp.upvalues[uv] = uv.Value()
+			cp.record(uv.Value())
+		}
+	case *glua.LUserData:
+		cp.record(v.Metatable)
+		if v.Env != nil {
+			cp.record(v.Env)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resetting Engines", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		var err error
+		engine, err = NewSecureEngine()
+		Expect(err).To(BeNil())
+		engine.RegisterModule("config", map[string]interface{}{"level": 1})
+		Expect(engine.LoadString(`
+			local visits = 0
+			local seen = {}
+			function visit(name)
+				visits = visits + 1
+				seen[#seen + 1] = name
+				return visits, #seen
+			end
+		`)).To(BeNil())
+		engine.Checkpoint()
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should fail without a checkpoint", func() {
+		e := NewEngine()
+		defer e.Close()
+		Expect(e.Reset()).To(Equal(ErrNoCheckpoint))
+	})
+
+	It("should restore globals, modules and captured locals", func() {
+		Expect(engine.LoadString(`
+			leaked = true
+			string.shout = string.upper
+			local config = require("config")
+			config.level = 9
+		`)).To(BeNil())
+		_, err := engine.Call("visit", 2, "orc")
+		Expect(err).To(BeNil())
+		engine.SetGlobal("extra", 1)
+
+		Expect(engine.Reset()).To(BeNil())
+		Expect(engine.GetGlobal("leaked").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("extra").IsNil()).To(BeTrue())
+		Expect(engine.LoadString(`
+			shout = string.shout
+			level = require("config").level
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("shout").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("level").AsNumber()).To(Equal(1.0))
+
+		ret, err := engine.Call("visit", 2, "elf")
+		Expect(err).To(BeNil())
+		Expect(ret[0].AsNumber()).To(Equal(1.0))
+		Expect(ret[1].AsNumber()).To(Equal(1.0))
+	})
+
+	It("should restore the sandbox of secure engines", func() {
+		Expect(engine.LoadString(`
+			function poison()
+				os = {exit = function() end}
+				stash = "secret"
+				co = coroutine.create(function() coroutine.yield(1) end)
+				coroutine.resume(co)
+			end
+		`)).To(BeNil())
+		_, err := engine.Call("poison", 0)
+		Expect(err).To(BeNil())
+
+		Expect(engine.Reset()).To(BeNil())
+		Expect(engine.GetGlobal("poison").IsNil()).To(BeTrue())
+		Expect(engine.LoadString(`
+			function peek()
+				return stash, os.exit, co
+			end
+		`)).To(BeNil())
+		ret, err := engine.Call("peek", 3)
+		Expect(err).To(BeNil())
+		Expect(ret[0].IsNil()).To(BeTrue())
+		Expect(ret[1].IsNil()).To(BeTrue())
+		Expect(ret[2].IsNil()).To(BeTrue())
+	})
+	It("should forget types and classes registered since the checkpoint", func() {
+		e := NewEngine()
+		defer e.Close()
+		e.Checkpoint()
+		e.RegisterClass("Player", Player{})
+		Expect(e.LoadString(`Hero = Player:extend()`)).To(BeNil())
+		Expect(e.Reset()).To(BeNil())
+		Expect(e.GetGlobal("Player").IsNil()).To(BeTrue())
+
+		e.RegisterType("Player", Player{})
+		e.SetGlobal("player", &Player{Name: "bob", Password: "hunter2"})
+		Expect(e.LoadString(`name, password = player.name, player.Password`)).To(BeNil())
+		Expect(e.GetGlobal("name").AsString()).To(Equal("bob"))
+		Expect(e.GetGlobal("password").IsNil()).To(BeTrue())
+	})
+})
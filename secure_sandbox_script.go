@@ -12,6 +12,9 @@ __sandbox_env = {
   tostring = tostring,
   type = type,
   unpack = unpack,
+  json = { encode = json.encode, decode = json.decode, null = json.null },
+  channel = { make = channel.make, receive = channel.receive,
+      send = channel.send, close = channel.close, select = channel.select },
   coroutine = { create = coroutine.create, resume = coroutine.resume,
       running = coroutine.running, status = coroutine.status,
       wrap = coroutine.wrap },
@@ -0,0 +1,323 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+
+	"github.com/layeh/gopher-luar"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// snapshotVersion is the version of the format written by Snapshot.
+const snapshotVersion = 1
+
+// SnapshotCodec converts the Go values held by userdata to bytes and back, so
+// they can be included in snapshots.
+type SnapshotCodec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// snapshot is the serialized form of a set of globals.
+type snapshot struct {
+	Version  int
+	Roots    []snapshotRoot
+	Tables   []snapshotTable
+	Userdata []snapshotUserdata
+}
+
+// snapshotRoot is a global saved in a snapshot.
+type snapshotRoot struct {
+	Name  string
+	Value snapshotValue
+}
+
+// snapshotTable holds the entries of a table, tables are stored once and
+// referenced by index so shared and cyclic tables are kept.
+type snapshotTable struct {
+	Keys, Values []snapshotValue
+}
+
+// snapshotUserdata holds the Go value of a userdata as encoded by the codec
+// registered for its type.
+type snapshotUserdata struct {
+	Type string
+	Data []byte
+}
+
+// snapshotValue is a single value, Ref indexes Tables or Userdata.
+type snapshotValue struct {
+	Kind   glua.LValueType
+	Bool   bool
+	Number float64
+	String string
+	Ref    int
+	Null   bool
+}
+
+// RegisterCodec sets the codec used to save userdata holding values of the
+// same type as typ in snapshots. Engines restoring the snapshot need the same
+// codec registered.
+func (e *Engine) RegisterCodec(typ interface{}, codec SnapshotCodec) {
+	if e.codecs == nil {
+		e.codecs = make(map[string]SnapshotCodec)
+	}
+	e.codecs[typeTag(reflect.TypeOf(typ))] = codec
+}
+
+// Snapshot serializes the globals named by roots and everything reachable
+// from them: strings, numbers, booleans, tables, including shared and cyclic
+// ones, and userdata whose type has a codec registered with RegisterCodec.
+// Metatables aren't saved. Functions, and other values that can't be saved,
+// return an error naming their path.
+//
+// Secure engines save the globals as secured functions see them.
+func (e *Engine) Snapshot(roots ...string) ([]byte, error) {
+	s := &snapshotWriter{
+		e:        e,
+		snap:     &snapshot{Version: snapshotVersion},
+		tables:   make(map[*glua.LTable]int),
+		userdata: make(map[*glua.LUserData]int),
+	}
+	for _, name := range roots {
+		val, err := s.value(e.scriptGlobal(name), name)
+		if err != nil {
+			return nil, err
+		}
+		s.snap.Roots = append(s.snap.Roots, snapshotRoot{Name: name, Value: val})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s.snap); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Restore sets the globals saved in data by Snapshot. Nothing is changed if
+// the snapshot can't be read.
+func (e *Engine) Restore(data []byte) error {
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return fmt.Errorf("snapshot: %s", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("snapshot: unsupported version %d", snap.Version)
+	}
+
+	r := &snapshotReader{e: e}
+	r.tables = make([]*glua.LTable, len(snap.Tables))
+	for i := range r.tables {
+		r.tables[i] = e.state.NewTable()
+	}
+	r.userdata = make([]glua.LValue, len(snap.Userdata))
+	for i, ud := range snap.Userdata {
+		codec, ok := e.codecs[ud.Type]
+		if !ok {
+			return fmt.Errorf("snapshot: no codec registered for %s", ud.Type)
+		}
+		v, err := codec.Decode(ud.Data)
+		if err != nil {
+			return fmt.Errorf("snapshot: decoding %s: %s", ud.Type, err)
+		}
+		r.userdata[i] = luar.New(e.state, v)
+	}
+	for i, t := range snap.Tables {
+		if len(t.Keys) != len(t.Values) {
+			return fmt.Errorf("snapshot: table %d is corrupt", i)
+		}
+		for j := range t.Keys {
+			key, err := r.value(t.Keys[j])
+			if err != nil {
+				return err
+			}
+			val, err := r.value(t.Values[j])
+			if err != nil {
+				return err
+			}
+			r.tables[i].RawSet(key, val)
+		}
+	}
+
+	values := make([]glua.LValue, len(snap.Roots))
+	for i, root := range snap.Roots {
+		val, err := r.value(root.Value)
+		if err != nil {
+			return err
+		}
+		values[i] = val
+	}
+	for i, root := range snap.Roots {
+		e.provide(root.Name, values[i])
+	}
+
+	return nil
+}
+
+// scriptGlobal returns the global name as scripts see it, for secure engines
+// that's the value in the sandbox if it's set there.
+func (e *Engine) scriptGlobal(name string) glua.LValue {
+	if e.Secure {
+		if env, ok := e.state.GetGlobal(e.sandbox.EnvName).(*glua.LTable); ok {
+			if lv := env.RawGetH(glua.LString(name)); lv != glua.LNil {
+				return lv
+			}
+		}
+	}
+
+	return e.state.GetGlobal(name)
+}
+
+// snapshotWriter builds a snapshot, tables and userdata map to their index in
+// it.
+type snapshotWriter struct {
+	e        *Engine
+	snap     *snapshot
+	tables   map[*glua.LTable]int
+	userdata map[*glua.LUserData]int
+}
+
+// value converts lv, found at path.
+func (s *snapshotWriter) value(lv glua.LValue, path string) (snapshotValue, error) {
+	val := snapshotValue{Kind: lv.Type()}
+	switch v := lv.(type) {
+	case *glua.LNilType:
+	case glua.LBool:
+		val.Bool = bool(v)
+	case glua.LNumber:
+		val.Number = float64(v)
+	case glua.LString:
+		val.String = string(v)
+	case *glua.LTable:
+		ref, err := s.table(v, path)
+		if err != nil {
+			return val, err
+		}
+		val.Ref = ref
+	case *glua.LUserData:
+		if isJSONNull(v) {
+			val.Null = true
+
+			return val, nil
+		}
+		ref, err := s.userdatum(v, path)
+		if err != nil {
+			return val, err
+		}
+		val.Ref = ref
+	default:
+		return val, fmt.Errorf("snapshot: cannot save %s at %s", lv.Type(), path)
+	}
+
+	return val, nil
+}
+
+// table adds t, found at path, to the snapshot unless it's already there and
+// returns its index.
+func (s *snapshotWriter) table(t *glua.LTable, path string) (int, error) {
+	if ft := frozenOf(t); ft != nil {
+		t = ft.target
+	}
+	if ref, ok := s.tables[t]; ok {
+		return ref, nil
+	}
+	ref := len(s.snap.Tables)
+	s.tables[t] = ref
+	s.snap.Tables = append(s.snap.Tables, snapshotTable{})
+
+	var keys, values []glua.LValue
+	t.ForEach(func(key, val glua.LValue) {
+		keys = append(keys, key)
+		values = append(values, val)
+	})
+	var st snapshotTable
+	for i, key := range keys {
+		k, err := s.value(key, fmt.Sprintf("%s[%s]", path, key.String()))
+		if err != nil {
+			return 0, err
+		}
+		v, err := s.value(values[i], fieldPath(path, key))
+		if err != nil {
+			return 0, err
+		}
+		st.Keys = append(st.Keys, k)
+		st.Values = append(st.Values, v)
+	}
+	s.snap.Tables[ref] = st
+
+	return ref, nil
+}
+
+// userdatum adds ud, found at path, to the snapshot unless it's already there
+// and returns its index.
+func (s *snapshotWriter) userdatum(ud *glua.LUserData, path string) (int, error) {
+	if ref, ok := s.userdata[ud]; ok {
+		return ref, nil
+	}
+	tag := typeTag(reflect.TypeOf(ud.Value))
+	codec, ok := s.e.codecs[tag]
+	if !ok {
+		return 0, fmt.Errorf("snapshot: cannot save %s at %s, no codec registered", tag, path)
+	}
+	data, err := codec.Encode(ud.Value)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot: encoding %s at %s: %s", tag, path, err)
+	}
+	ref := len(s.snap.Userdata)
+	s.userdata[ud] = ref
+	s.snap.Userdata = append(s.snap.Userdata, snapshotUserdata{Type: tag, Data: data})
+
+	return ref, nil
+}
+
+// snapshotReader rebuilds the values of a snapshot.
+type snapshotReader struct {
+	e        *Engine
+	tables   []*glua.LTable
+	userdata []glua.LValue
+}
+
+// value converts val back to a Lua value.
+func (r *snapshotReader) value(val snapshotValue) (glua.LValue, error) {
+	switch val.Kind {
+	case glua.LTNil:
+		return glua.LNil, nil
+	case glua.LTBool:
+		return glua.LBool(val.Bool), nil
+	case glua.LTNumber:
+		return glua.LNumber(val.Number), nil
+	case glua.LTString:
+		return glua.LString(val.String), nil
+	case glua.LTTable:
+		if val.Ref >= 0 && val.Ref < len(r.tables) {
+			return r.tables[val.Ref], nil
+		}
+	case glua.LTUserData:
+		if val.Null {
+			return r.e.nullValue(), nil
+		}
+		if val.Ref >= 0 && val.Ref < len(r.userdata) {
+			return r.userdata[val.Ref], nil
+		}
+	}
+
+	return glua.LNil, fmt.Errorf("snapshot: invalid %s value", val.Kind)
+}
+
+// typeTag names t in snapshots.
+func typeTag(t reflect.Type) string {
+	if t == nil {
+		return "nil"
+	}
+	if t.Kind() == reflect.Ptr && t.Elem().Name() != "" {
+		return "*" + t.Elem().PkgPath() + "." + t.Elem().Name()
+	}
+	if t.Name() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+
+	return t.String()
+}
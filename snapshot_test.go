@@ -0,0 +1,127 @@
+package lua_test
+
+import (
+	"encoding/json"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type vectorCodec struct{}
+
+func (vectorCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (vectorCodec) Decode(data []byte) (interface{}, error) {
+	v := &Vector{}
+
+	return v, json.Unmarshal(data, v)
+}
+
+var _ = Describe("Snapshot", func() {
+	var engine, restored *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		restored = NewEngine()
+		engine.RegisterType("Vector", Vector{})
+		restored.RegisterType("Vector", Vector{})
+		engine.RegisterCodec(&Vector{}, vectorCodec{})
+		restored.RegisterCodec(&Vector{}, vectorCodec{})
+	})
+
+	AfterEach(func() {
+		engine.Close()
+		restored.Close()
+	})
+
+	It("should restore globals with shared and cyclic tables", func() {
+		Expect(engine.LoadString(`
+			counters = {kills = 3, deaths = 1, [1] = "first", [true] = 0.5}
+			quests = {active = {name = "rats", done = false}}
+			quests.current = quests.active
+			quests.self = quests
+			level = 7
+		`)).To(BeNil())
+		data, err := engine.Snapshot("counters", "quests", "level", "missing")
+		Expect(err).To(BeNil())
+
+		Expect(restored.Restore(data)).To(BeNil())
+		Expect(restored.GetGlobal("counters").Equal(engine.GetGlobal("counters"))).To(BeTrue())
+		Expect(restored.GetGlobal("level").AsNumber()).To(Equal(7.0))
+		Expect(restored.LoadString(`
+			shared = quests.current == quests.active and quests.self == quests
+			name = quests.active.name
+		`)).To(BeNil())
+		Expect(restored.GetGlobal("shared").AsBool()).To(BeTrue())
+		Expect(restored.GetGlobal("name").AsString()).To(Equal("rats"))
+	})
+
+	It("should save userdata with a codec", func() {
+		Expect(engine.LoadString(`home = {pos = Vector(); visits = 2}`)).To(BeNil())
+		Expect(engine.LoadString(`home.pos.X = 4`)).To(BeNil())
+		data, err := engine.Snapshot("home")
+		Expect(err).To(BeNil())
+
+		Expect(restored.Restore(data)).To(BeNil())
+		Expect(restored.LoadString(`x = home.pos.X`)).To(BeNil())
+		Expect(restored.GetGlobal("x").AsNumber()).To(Equal(4.0))
+
+		bare := NewEngine()
+		defer bare.Close()
+		err = bare.Restore(data)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("no codec registered"))
+	})
+
+	It("should reject functions naming their path", func() {
+		Expect(engine.LoadString(`
+			state = {hooks = {onHit = function() end}}
+			ud = {file = io.stdout}
+		`)).To(BeNil())
+		_, err := engine.Snapshot("state")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("snapshot: cannot save function at state.hooks.onHit"))
+
+		_, err = engine.Snapshot("ud")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("at ud.file, no codec registered"))
+	})
+
+	It("should reject invalid data", func() {
+		Expect(restored.Restore([]byte("nope"))).ToNot(BeNil())
+	})
+
+	It("should save the globals of secured functions", func() {
+		secure, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer secure.Close()
+		Expect(secure.LoadString(`
+			function bump()
+				count = (count or 0) + 1
+				return count
+			end
+		`)).To(BeNil())
+		_, err = secure.Call("bump", 1)
+		Expect(err).To(BeNil())
+		data, err := secure.Snapshot("count")
+		Expect(err).To(BeNil())
+
+		other, err := NewSecureEngine()
+		Expect(err).To(BeNil())
+		defer other.Close()
+		Expect(other.LoadString(`
+			function bump()
+				count = (count or 0) + 1
+				return count
+			end
+		`)).To(BeNil())
+		Expect(other.Restore(data)).To(BeNil())
+		ret, err := other.Call("bump", 1)
+		Expect(err).To(BeNil())
+		Expect(ret[0].AsNumber()).To(Equal(2.0))
+	})
+})
@@ -0,0 +1,447 @@
+package lua
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// Storage is a durable key-value store for the store module. Values are the
+// JSON encoding of the Lua values scripts store, Increment works on values
+// holding a number. Implementations must be safe to share between engines.
+type Storage interface {
+	// Get returns the value of key, and false if it isn't set.
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	// Increment adds delta to the number stored at key, which counts as 0 if
+	// it isn't set, and returns the result.
+	Increment(key string, delta float64) (float64, error)
+	// Keys returns the keys starting with prefix, sorted.
+	Keys(prefix string) ([]string, error)
+}
+
+// storeSeparator separates the namespace from keys in Storage.
+const storeSeparator = ":"
+
+// RegisterStore exposes storage to scripts as the store module, with the keys
+// scripts use prefixed with namespace so engines, or sandboxes, given
+// different namespaces can't read each other's keys.
+//
+//	local store = require("store")
+//	store.set("player:42", {gold = 10})
+//	store.get("player:42").gold        --> 10
+//	store.increment("visits")          --> 1
+//	store.increment("visits", 5)       --> 6
+//	store.list("player:")              --> {"player:42"}
+//	store.delete("player:42")
+//
+// Setting a key to nil deletes it. Storage errors are raised as Lua errors.
+func (e *Engine) RegisterStore(storage Storage, namespace string) error {
+	if namespace == "" || strings.Contains(namespace, storeSeparator) {
+		return fmt.Errorf("invalid store namespace %q", namespace)
+	}
+	prefix := namespace + storeSeparator
+
+	set := func(l *glua.LState, key string, lv glua.LValue) {
+		var err error
+		if lv == glua.LNil {
+			err = storage.Delete(prefix + key)
+		} else {
+			var data string
+			if data, err = e.encodeJSON(lv, ""); err == nil {
+				err = storage.Set(prefix+key, []byte(data))
+			}
+		}
+		if err != nil {
+			l.RaiseError("store: %s", err)
+		}
+	}
+
+	e.RegisterModule("store", map[string]interface{}{
+		"get": e.state.NewFunction(func(l *glua.LState) int {
+			data, ok, err := storage.Get(prefix + l.CheckString(1))
+			if err != nil {
+				l.RaiseError("store: %s", err)
+			}
+			if !ok {
+				l.Push(glua.LNil)
+
+				return 1
+			}
+			lv, err := e.decodeJSON(data)
+			if err != nil {
+				l.RaiseError("store: %s", err)
+			}
+			l.Push(lv)
+
+			return 1
+		}),
+		"set": e.state.NewFunction(func(l *glua.LState) int {
+			set(l, l.CheckString(1), l.Get(2))
+
+			return 0
+		}),
+		"delete": e.state.NewFunction(func(l *glua.LState) int {
+			set(l, l.CheckString(1), glua.LNil)
+
+			return 0
+		}),
+		"increment": e.state.NewFunction(func(l *glua.LState) int {
+			n, err := storage.Increment(prefix+l.CheckString(1), float64(l.OptNumber(2, 1)))
+			if err != nil {
+				l.RaiseError("store: %s", err)
+			}
+			l.Push(glua.LNumber(n))
+
+			return 1
+		}),
+		"list": e.state.NewFunction(func(l *glua.LState) int {
+			keys, err := storage.Keys(prefix + l.OptString(1, ""))
+			if err != nil {
+				l.RaiseError("store: %s", err)
+			}
+			t := l.CreateTable(len(keys), 0)
+			for _, key := range keys {
+				t.Append(glua.LString(strings.TrimPrefix(key, prefix)))
+			}
+			l.Push(t)
+
+			return 1
+		}),
+	})
+
+	return nil
+}
+
+// MemoryStorage is a Storage keeping values in memory, for tests and state
+// that doesn't need to outlive the process.
+type MemoryStorage struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{values: make(map[string][]byte)}
+}
+
+// Get implements Storage.
+func (s *MemoryStorage) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.values[key]
+
+	return val, ok, nil
+}
+
+// Set implements Storage.
+func (s *MemoryStorage) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+
+	return nil
+}
+
+// Delete implements Storage.
+func (s *MemoryStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+
+	return nil
+}
+
+// Increment implements Storage.
+func (s *MemoryStorage) Increment(key string, delta float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, data, err := increment(key, s.values[key], delta)
+	if err != nil {
+		return 0, err
+	}
+	s.values[key] = data
+
+	return n, nil
+}
+
+// Keys implements Storage.
+func (s *MemoryStorage) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return keysWithPrefix(s.values, prefix), nil
+}
+
+// FileStorage is a Storage keeping values in memory and logging every change
+// to a file, which is replayed when it's opened again. The log is compacted
+// when most of it is made of overwritten entries. Changes reach the disk when
+// the system flushes the file, or when Sync, Compact or Close is called.
+type FileStorage struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	values  map[string][]byte
+	entries int
+}
+
+// fileEntry is a line of the FileStorage log.
+type fileEntry struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// compactAfter is the number of log entries below which FileStorage doesn't
+// compact.
+const compactAfter = 1024
+
+// OpenFileStorage opens the FileStorage logging to path, creating the file if
+// it doesn't exist. A last entry missing its end of line was cut short while
+// being written, it's dropped from the file.
+func OpenFileStorage(path string) (*FileStorage, error) {
+	s := &FileStorage{path: path, values: make(map[string][]byte)}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		n, err := s.replay(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %s", path, err)
+		}
+		if n < len(data) {
+			if err := os.Truncate(path, int64(n)); err != nil {
+				return nil, err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+
+	return s, nil
+}
+
+// replay applies the complete entries of the log data, returning the length
+// of the data they're in.
+func (s *FileStorage) replay(data []byte) (int, error) {
+	n := 0
+	for {
+		end := bytes.IndexByte(data[n:], '\n')
+		if end < 0 {
+			return n, nil
+		}
+		var entry fileEntry
+		if err := json.Unmarshal(data[n:n+end], &entry); err != nil {
+			return 0, err
+		}
+		s.apply(entry)
+		n += end + 1
+	}
+}
+
+// Get implements Storage.
+func (s *FileStorage) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.values[key]
+
+	return val, ok, nil
+}
+
+// Set implements Storage.
+func (s *FileStorage) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(fileEntry{Key: key, Value: value})
+}
+
+// Delete implements Storage.
+func (s *FileStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; !ok {
+		return nil
+	}
+
+	return s.write(fileEntry{Key: key, Deleted: true})
+}
+
+// Increment implements Storage.
+func (s *FileStorage) Increment(key string, delta float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, data, err := increment(key, s.values[key], delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, s.write(fileEntry{Key: key, Value: data})
+}
+
+// Keys implements Storage.
+func (s *FileStorage) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return keysWithPrefix(s.values, prefix), nil
+}
+
+// Compact rewrites the log with only the current values.
+func (s *FileStorage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// Sync flushes the log file to disk.
+func (s *FileStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Sync()
+}
+
+// Close flushes the log file to disk and closes it.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+
+		return err
+	}
+
+	return s.file.Close()
+}
+
+// apply records entry in memory.
+func (s *FileStorage) apply(entry fileEntry) {
+	if entry.Deleted {
+		delete(s.values, entry.Key)
+	} else {
+		s.values[entry.Key] = entry.Value
+	}
+	s.entries++
+}
+
+// write logs entry and applies it, compacting the log if it's grown too
+// large.
+func (s *FileStorage) write(entry fileEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.apply(entry)
+	if s.entries > compactAfter && s.entries > 2*len(s.values) {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// compact writes the current values to a new log and replaces the old one
+// with it.
+func (s *FileStorage) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, key := range keysWithPrefix(s.values, "") {
+		line, err := json.Marshal(fileEntry{Key: key, Value: s.values[key]})
+		if err != nil {
+			f.Close()
+
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+
+		return err
+	}
+	// the new log must be on disk before it replaces the old one
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	s.file.Close()
+	if s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	s.entries = len(s.values)
+
+	return nil
+}
+
+// syncDir flushes the entries of the directory dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// increment adds delta to data, the stored value of key, returning the sum
+// and its encoding.
+func increment(key string, data []byte, delta float64) (float64, []byte, error) {
+	var n float64
+	if data != nil {
+		var err error
+		if n, err = strconv.ParseFloat(string(data), 64); err != nil {
+			return 0, nil, fmt.Errorf("value of %q is not a number", key)
+		}
+	}
+	n += delta
+	data, err := json.Marshal(n)
+
+	return n, data, err
+}
+
+// keysWithPrefix returns the keys of values starting with prefix, sorted.
+func keysWithPrefix(values map[string][]byte, prefix string) []string {
+	var keys []string
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
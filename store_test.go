@@ -0,0 +1,155 @@
+package lua_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		engine  *Engine
+		storage *MemoryStorage
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		storage = NewMemoryStorage()
+		Expect(engine.RegisterStore(storage, "quests")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should get, set and delete values", func() {
+		Expect(engine.LoadString(`
+			local store = require("store")
+			store.set("player:42", {gold = 10, items = {"sword"}})
+			store.set("player:7", "seven")
+			gold = store.get("player:42").gold
+			item = store.get("player:42").items[1]
+			missing = store.get("nope")
+			store.delete("player:7")
+			deleted = store.get("player:7")
+			store.set("player:42", nil)
+			cleared = store.get("player:42")
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("gold").AsNumber()).To(Equal(10.0))
+		Expect(engine.GetGlobal("item").AsString()).To(Equal("sword"))
+		Expect(engine.GetGlobal("missing").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("deleted").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("cleared").IsNil()).To(BeTrue())
+	})
+
+	It("should increment and list keys", func() {
+		Expect(engine.LoadString(`
+			local store = require("store")
+			first = store.increment("visits")
+			second = store.increment("visits", 5)
+			store.set("player:2", true)
+			store.set("player:1", true)
+			store.set("name", "x")
+			keys = store.list("player:")
+			count = #store.list()
+			ok, err = pcall(store.increment, "name")
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("first").AsNumber()).To(Equal(1.0))
+		Expect(engine.GetGlobal("second").AsNumber()).To(Equal(6.0))
+		Expect(engine.GetGlobal("keys").Dump(DumpOptions{Compact: true})).To(Equal(`{"player:1", "player:2"}`))
+		Expect(engine.GetGlobal("count").AsNumber()).To(Equal(4.0))
+		Expect(engine.GetGlobal("err").AsString()).To(ContainSubstring(`store: value of "quests:name" is not a number`))
+	})
+
+	It("should keep namespaces apart", func() {
+		other := NewEngine()
+		defer other.Close()
+		Expect(other.RegisterStore(storage, "shops")).To(BeNil())
+		Expect(engine.LoadString(`require("store").set("secret", 1)`)).To(BeNil())
+		Expect(other.LoadString(`
+			local store = require("store")
+			secret, keys = store.get("secret"), #store.list()
+		`)).To(BeNil())
+		Expect(other.GetGlobal("secret").IsNil()).To(BeTrue())
+		Expect(other.GetGlobal("keys").AsNumber()).To(Equal(0.0))
+
+		Expect(other.RegisterStore(storage, "a:b")).ToNot(BeNil())
+	})
+
+	It("should persist values in a file", func() {
+		dir, err := ioutil.TempDir("", "store")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "store.log")
+
+		fs, err := OpenFileStorage(path)
+		Expect(err).To(BeNil())
+		for i := 0; i < 3000; i++ {
+			_, err = fs.Increment("n", 1)
+			Expect(err).To(BeNil())
+		}
+		Expect(fs.Set("gone", []byte(`1`))).To(BeNil())
+		Expect(fs.Delete("gone")).To(BeNil())
+		Expect(fs.Set("empty", []byte{})).To(BeNil())
+		Expect(fs.Close()).To(BeNil())
+
+		info, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(info.Size()).To(BeNumerically("<", 1024*40))
+
+		fs, err = OpenFileStorage(path)
+		Expect(err).To(BeNil())
+		defer fs.Close()
+		val, ok, err := fs.Get("n")
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+		Expect(string(val)).To(Equal("3000"))
+		_, ok, _ = fs.Get("gone")
+		Expect(ok).To(BeFalse())
+		_, ok, _ = fs.Get("empty")
+		Expect(ok).To(BeTrue())
+
+		Expect(fs.Compact()).To(BeNil())
+		keys, err := fs.Keys("")
+		Expect(err).To(BeNil())
+		Expect(keys).To(Equal([]string{"empty", "n"}))
+	})
+	It("should drop a last entry cut short while being written", func() {
+		dir, err := ioutil.TempDir("", "store")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "store.log")
+
+		fs, err := OpenFileStorage(path)
+		Expect(err).To(BeNil())
+		Expect(fs.Set("kept", []byte(`1`))).To(BeNil())
+		Expect(fs.Sync()).To(BeNil())
+		Expect(fs.Close()).To(BeNil())
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).To(BeNil())
+		_, err = f.WriteString(`{"key":"torn","val`)
+		Expect(err).To(BeNil())
+		Expect(f.Close()).To(BeNil())
+
+		fs, err = OpenFileStorage(path)
+		Expect(err).To(BeNil())
+		Expect(fs.Set("next", []byte(`2`))).To(BeNil())
+		Expect(fs.Close()).To(BeNil())
+
+		fs, err = OpenFileStorage(path)
+		Expect(err).To(BeNil())
+		defer fs.Close()
+		keys, err := fs.Keys("")
+		Expect(err).To(BeNil())
+		Expect(keys).To(Equal([]string{"kept", "next"}))
+
+		Expect(ioutil.WriteFile(path, []byte("{\"key\":\n{\"key\":\"a\"}\n"), 0644)).To(BeNil())
+		_, err = OpenFileStorage(path)
+		Expect(err).ToNot(BeNil())
+	})
+})
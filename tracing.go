@@ -0,0 +1,108 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// Tracer is notified as scripts run, so their execution can be recorded in
+// traces. Calls into Lua are functions run with Call and chunks run with
+// LoadString or LoadFile, Go functions are the ones registered with the
+// Engine.
+//
+// The contexts returned by OnCallStart and OnGoFuncEnter are given to the
+// matching end and error callbacks, and to the calls made while they run, so
+// spans nest. OnError is called before OnCallEnd or OnGoFuncExit, which are
+// called whether the call failed or not.
+type Tracer interface {
+	OnCallStart(ctx context.Context, name string, args []*Value) context.Context
+	OnCallEnd(ctx context.Context, name string, results []*Value)
+	OnGoFuncEnter(ctx context.Context, name string, args []*Value) context.Context
+	OnGoFuncExit(ctx context.Context, name string, results []*Value)
+	OnError(ctx context.Context, name string, err error)
+}
+
+// SetTracer sets the Tracer notified of calls, nil removes it.
+func (e *Engine) SetTracer(t Tracer) {
+	e.tracer = t
+}
+
+// Context returns the context of the call running in the Engine, as returned
+// by the Tracer, or the background context outside of calls. Go functions
+// called from Lua can use it to pass the trace on.
+func (e *Engine) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+
+	return e.ctx
+}
+
+// trace runs fn, a call into Lua named name, with ctx as the context of the
+// Engine, notifying the Tracer if there is one.
+func (e *Engine) trace(ctx context.Context, name string, args []glua.LValue, fn func() ([]*Value, error)) ([]*Value, error) {
+	if e.tracer != nil {
+		ctx = e.tracer.OnCallStart(ctx, name, values(args))
+	}
+	prev := e.ctx
+	e.ctx = ctx
+	rets, err := fn()
+	e.ctx = prev
+
+	if e.tracer != nil {
+		if err != nil {
+			e.tracer.OnError(ctx, name, err)
+		}
+		e.tracer.OnCallEnd(ctx, name, rets)
+	}
+
+	return rets, err
+}
+
+// traceGoCall runs the Go function fn, registered as name, on behalf of the
+// Lua state, notifying the Tracer. Errors are raised as panics, which are
+// reported and passed on.
+func (e *Engine) traceGoCall(l *glua.LState, name string, fn glua.LGFunction) int {
+	args := make([]glua.LValue, l.GetTop())
+	for i := range args {
+		args[i] = l.Get(i + 1)
+	}
+	ctx := e.tracer.OnGoFuncEnter(e.Context(), name, values(args))
+	tracer := e.tracer
+	prev := e.ctx
+	e.ctx = ctx
+
+	var n int
+	defer func() {
+		e.ctx = prev
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("%v", r)
+			}
+			tracer.OnError(ctx, name, err)
+			tracer.OnGoFuncExit(ctx, name, nil)
+			panic(r)
+		}
+		rets := make([]glua.LValue, n)
+		for i := range rets {
+			rets[i] = l.Get(l.GetTop() - n + 1 + i)
+		}
+		tracer.OnGoFuncExit(ctx, name, values(rets))
+	}()
+	n = fn(l)
+
+	return n
+}
+
+// values wraps each of lvs in a Value.
+func values(lvs []glua.LValue) []*Value {
+	vals := make([]*Value, len(lvs))
+	for i, lv := range lvs {
+		vals[i] = newValue(lv)
+	}
+
+	return vals
+}
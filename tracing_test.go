@@ -0,0 +1,139 @@
+package lua_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type spanKey struct{}
+
+// recordingTracer logs each callback with the span it belongs to, spans are
+// named after their parents.
+type recordingTracer struct {
+	events []string
+}
+
+func (t *recordingTracer) span(ctx context.Context) string {
+	s, _ := ctx.Value(spanKey{}).(string)
+
+	return s
+}
+
+func (t *recordingTracer) join(vals []*Value) string {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = v.String()
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (t *recordingTracer) OnCallStart(ctx context.Context, name string, args []*Value) context.Context {
+	t.events = append(t.events, fmt.Sprintf("start %s(%s) in %q", name, t.join(args), t.span(ctx)))
+
+	return context.WithValue(ctx, spanKey{}, t.span(ctx)+"/"+name)
+}
+
+func (t *recordingTracer) OnCallEnd(ctx context.Context, name string, results []*Value) {
+	t.events = append(t.events, fmt.Sprintf("end %s = %s in %q", name, t.join(results), t.span(ctx)))
+}
+
+func (t *recordingTracer) OnGoFuncEnter(ctx context.Context, name string, args []*Value) context.Context {
+	t.events = append(t.events, fmt.Sprintf("enter %s(%s) in %q", name, t.join(args), t.span(ctx)))
+
+	return context.WithValue(ctx, spanKey{}, t.span(ctx)+"/"+name)
+}
+
+func (t *recordingTracer) OnGoFuncExit(ctx context.Context, name string, results []*Value) {
+	t.events = append(t.events, fmt.Sprintf("exit %s = %s in %q", name, t.join(results), t.span(ctx)))
+}
+
+func (t *recordingTracer) OnError(ctx context.Context, name string, err error) {
+	t.events = append(t.events, fmt.Sprintf("error %s in %q", name, t.span(ctx)))
+}
+
+var _ = Describe("Tracing", func() {
+	var (
+		engine *Engine
+		tracer *recordingTracer
+		spans  []string
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		tracer = &recordingTracer{}
+		spans = nil
+		engine.RegisterFunc("double", func(e *Engine) int {
+			spans = append(spans, tracer.span(e.Context()))
+			e.PushRet(e.PopInt() * 2)
+
+			return 1
+		})
+		Bind1(engine, "check", func(n int) (int, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+
+			return n, nil
+		})
+		Expect(engine.LoadString(`
+			function run(n)
+				return check(double(n))
+			end
+		`)).To(BeNil())
+		engine.SetTracer(tracer)
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	It("should trace calls into Lua and Go functions", func() {
+		ctx := context.WithValue(context.Background(), spanKey{}, "request")
+		ret, err := engine.CallContext(ctx, "run", 1, 2)
+		Expect(err).To(BeNil())
+		Expect(ret[0].AsNumber()).To(Equal(4.0))
+		Expect(tracer.events).To(Equal([]string{
+			`start run(2) in "request"`,
+			`enter double(2) in "request/run"`,
+			`exit double = 4 in "request/run/double"`,
+			`enter check(4) in "request/run"`,
+			`exit check = 4 in "request/run/check"`,
+			`end run = 4 in "request/run"`,
+		}))
+		Expect(spans).To(Equal([]string{"request/run/double"}))
+		Expect(tracer.span(engine.Context())).To(Equal(""))
+	})
+
+	It("should report errors", func() {
+		_, err := engine.Call("run", 1, -1)
+		Expect(err).ToNot(BeNil())
+		Expect(tracer.events).To(Equal([]string{
+			`start run(-1) in ""`,
+			`enter double(-1) in "/run"`,
+			`exit double = -2 in "/run/double"`,
+			`enter check(-2) in "/run"`,
+			`error check in "/run/check"`,
+			`exit check =  in "/run/check"`,
+			`error run in "/run"`,
+			`end run =  in "/run"`,
+		}))
+	})
+
+	It("should trace loaded chunks", func() {
+		Expect(engine.LoadStringContext(context.Background(), `x = check(1)`)).To(BeNil())
+		Expect(tracer.events).To(Equal([]string{
+			`start <string>() in ""`,
+			`enter check(1) in "/<string>"`,
+			`exit check = 1 in "/<string>/check"`,
+			`end <string> =  in "/<string>"`,
+		}))
+	})
+})
@@ -0,0 +1,161 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// ErrForeignValue is returned, or raised as a panic by methods that can't
+// return errors, when a Value holding a table, function, userdata, thread or
+// channel of an Engine is used with another one.
+var ErrForeignValue = errors.New("value belongs to another Engine, use Import to copy it")
+
+// Shareable is implemented by Go values that can be used by several Engines,
+// and so from several goroutines, at once. Import only copies userdata whose
+// value is Shareable and returns true.
+type Shareable interface {
+	Shareable() bool
+}
+
+// Import returns a copy of v, a Value of another Engine, that can be used
+// with this one. Tables are copied deeply, keeping cycles and frozen views,
+// json.null stays json.null and Shareable userdata hold the same Go value.
+// Functions, threads, channels, tables with metatables and userdata that
+// isn't Shareable can't be imported, the error names the path to the value.
+func (e *Engine) Import(v *Value) (*Value, error) {
+	if !e.foreign(v) {
+		return v, nil
+	}
+
+	imp := &importer{e: e, copies: make(map[*glua.LTable]*glua.LTable)}
+	lv, err := imp.copy(v.lval, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return e.value(lv), nil
+}
+
+// importer copies values from an Engine into another one.
+type importer struct {
+	e      *Engine
+	copies map[*glua.LTable]*glua.LTable
+}
+
+// copy returns a copy of lv, found at path, for the Engine importing it.
+func (imp *importer) copy(lv glua.LValue, path string) (glua.LValue, error) {
+	switch v := lv.(type) {
+	case *glua.LNilType, glua.LBool, glua.LNumber, glua.LString:
+		return lv, nil
+	case *glua.LTable:
+		return imp.table(v, path)
+	case *glua.LUserData:
+		if isJSONNull(v) {
+			return imp.e.nullValue(), nil
+		}
+		if s, ok := v.Value.(Shareable); ok && s.Shareable() {
+			return imp.e.ValueFor(v.Value).lval, nil
+		}
+
+		return nil, importError(path, "cannot import userdata %T, it isn't Shareable", v.Value)
+	}
+
+	return nil, importError(path, "cannot import %s", lv.Type())
+}
+
+// table returns a copy of t, found at path.
+func (imp *importer) table(t *glua.LTable, path string) (glua.LValue, error) {
+	frozen := frozenOf(t)
+	if frozen != nil {
+		t = frozen.target
+	} else if t.Metatable != glua.LNil {
+		return nil, importError(path, "cannot import table with a metatable")
+	}
+
+	if c, ok := imp.copies[t]; ok {
+		return imp.result(c, frozen), nil
+	}
+	c := imp.e.state.CreateTable(0, 0)
+	imp.copies[t] = c
+
+	var err error
+	t.ForEach(func(key, val glua.LValue) {
+		if err != nil {
+			return
+		}
+		var k, v glua.LValue
+		if k, err = imp.copy(key, path); err != nil {
+			return
+		}
+		if v, err = imp.copy(val, fieldPath(path, key)); err != nil {
+			return
+		}
+		c.RawSet(k, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return imp.result(c, frozen), nil
+}
+
+// result returns c, the copy of a table, frozen again if the table was.
+func (imp *importer) result(c *glua.LTable, frozen *frozenTable) glua.LValue {
+	if frozen == nil {
+		return c
+	}
+
+	return imp.e.freeze(c, frozen.path)
+}
+
+// importError returns an error for the value at path.
+func importError(path, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if path != "" {
+		msg += " at " + path
+	}
+
+	return fmt.Errorf("import: %s", msg)
+}
+
+// value wraps lv in a Value belonging to e.
+func (e *Engine) value(lv glua.LValue) *Value {
+	v := newValue(lv)
+	v.owner = e.root()
+
+	return v
+}
+
+// root returns the Engine owning the Lua state of e. The Engines given to
+// ScriptFunctions share the state of the Engine calling them.
+func (e *Engine) root() *Engine {
+	if e.parent != nil {
+		return e.parent
+	}
+
+	return e
+}
+
+// foreign returns true if v holds a value belonging to an Engine other than
+// e.
+func (e *Engine) foreign(v *Value) bool {
+	if e == nil || v.owner == nil || v.owner.root() == e.root() {
+		return false
+	}
+	switch v.lval.(type) {
+	case *glua.LTable, *glua.LFunction, *glua.LUserData, *glua.LState, glua.LChannel:
+		return true
+	}
+
+	return false
+}
+
+// checkOwner panics with ErrForeignValue, naming the method op, if v belongs
+// to another Engine.
+func (e *Engine) checkOwner(op string, v *Value) {
+	if e.foreign(v) {
+		panic(fmt.Errorf("%s: %w", op, ErrForeignValue))
+	}
+}
@@ -0,0 +1,120 @@
+package lua_test
+
+import (
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type Counter struct {
+	N int
+}
+
+type SharedCounter struct {
+	N int
+}
+
+func (c *SharedCounter) Shareable() bool {
+	return true
+}
+
+var _ = Describe("Importing Values", func() {
+	var (
+		from   *Engine
+		engine *Engine
+	)
+
+	BeforeEach(func() {
+		from = NewEngine()
+		engine = NewEngine()
+	})
+
+	AfterEach(func() {
+		from.Close()
+		engine.Close()
+	})
+
+	It("should deep copy tables", func() {
+		Expect(from.LoadString(`
+			state = {name = "orc", tags = {"green", "angry"}, stats = {hp = 10}, nothing = json.null}
+			state.self = state
+		`)).To(BeNil())
+
+		state, err := engine.Import(from.GetGlobal("state"))
+		Expect(err).To(BeNil())
+		engine.SetGlobal("state", state)
+		Expect(engine.LoadString(`
+			state.stats.hp = 5
+			same = state.self == state
+			second = state.tags[2]
+			isNull = state.nothing == json.null
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("same").AsBool()).To(BeTrue())
+		Expect(engine.GetGlobal("second").AsString()).To(Equal("angry"))
+		Expect(engine.GetGlobal("isNull").AsBool()).To(BeTrue())
+		Expect(from.LoadString(`hp = state.stats.hp`)).To(BeNil())
+		Expect(from.GetGlobal("hp").AsNumber()).To(Equal(10.0))
+	})
+
+	It("should keep frozen tables frozen", func() {
+		config := from.Freeze(from.NewTable())
+		config, err := engine.Import(config)
+		Expect(err).To(BeNil())
+		engine.SetGlobal("config", config)
+		Expect(engine.LoadString(`config.debug = true`)).ToNot(BeNil())
+	})
+
+	It("should reject values that can't be shared", func() {
+		Expect(from.LoadString(`
+			hooks = {onHit = function() end}
+		`)).To(BeNil())
+		_, err := engine.Import(from.GetGlobal("hooks"))
+		Expect(err).To(MatchError("import: cannot import function at onHit"))
+
+		_, err = engine.Import(from.ValueFor(&Counter{}))
+		Expect(err).To(MatchError("import: cannot import userdata *lua_test.Counter, it isn't Shareable"))
+
+		shared := &SharedCounter{N: 3}
+		counter, err := engine.Import(from.ValueFor(shared))
+		Expect(err).To(BeNil())
+		Expect(counter.Interface()).To(BeIdenticalTo(shared))
+	})
+
+	It("should detect Values of other Engines", func() {
+		table := from.NewTable()
+		Expect(func() {
+			engine.SetGlobal("t", table)
+		}).To(PanicWith(MatchError(ErrForeignValue)))
+		Expect(func() {
+			engine.NewTable().Set("t", table)
+		}).To(PanicWith(MatchError(ErrForeignValue)))
+
+		Expect(engine.LoadString(`function size(t) return #t end`)).To(BeNil())
+		_, err := engine.Call("size", 1, table)
+		Expect(err).To(MatchError(ErrForeignValue))
+
+		engine.SetGlobal("name", from.ValueFor("orc"))
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("orc"))
+	})
+
+	It("should let ScriptFunctions use Values of the Engine calling them", func() {
+		config := engine.NewTable()
+		engine.SetGlobal("config", config)
+		engine.RegisterFunc("configure", func(se *Engine) int {
+			v := se.PopArg()
+			se.SetField(config, "level", v)
+			config.Set("name", se.ValueFor("orc"))
+			se.SetGlobal("config2", config)
+
+			return 0
+		})
+		Expect(engine.LoadString(`
+			configure(3)
+			level, name, same = config.level, config.name, config2 == config
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("level").AsNumber()).To(Equal(3.0))
+		Expect(engine.GetGlobal("name").AsString()).To(Equal("orc"))
+		Expect(engine.GetGlobal("same").AsBool()).To(BeTrue())
+	})
+})
@@ -0,0 +1,545 @@
+package lua
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/layeh/gopher-luar"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// luarMetatableKey is the registry key under which gopher-luar keeps the
+// metatables it shares between all values of the same kind.
+const luarMetatableKey = "github.com/layeh/gopher-luar"
+
+// TypeOptions controls how the fields and methods of a type given to
+// RegisterType or RegisterClass are exposed to Lua.
+//
+// Fields can also be configured with a `lua` struct tag: `lua:"name"` renames
+// the field, `lua:"-"` hides it and `lua:",readonly"` prevents scripts from
+// setting it.
+type TypeOptions struct {
+	// Methods lists the Go names of the methods scripts may call, nil allows
+	// every exported method.
+	Methods []string
+
+	// SnakeCase exposes fields and methods with snake_case names, so MaxHealth
+	// is accessed as max_health.
+	SnakeCase bool
+
+	// Properties exposes methods named GetX and SetX as the property x. Getters
+	// take no arguments and setters take the new value, either may also return
+	// an error which is raised in Lua. Properties take precedence over fields
+	// of the same name, so setters can validate every assignment.
+	Properties bool
+}
+
+// typeInfo describes how a registered struct type is exposed to Lua.
+type typeInfo struct {
+	name    string
+	typ     reflect.Type
+	fields  map[string]*fieldInfo
+	methods map[string]string
+	props   map[string]*propInfo
+	// members are the preferred Lua names of the fields, properties and
+	// methods in the order they're declared
+	members []string
+	// class is the table created by RegisterClass, if any
+	class *glua.LTable
+}
+
+// fieldInfo describes a struct field exposed to Lua.
+type fieldInfo struct {
+	index    []int
+	readonly bool
+}
+
+// propInfo describes a property backed by getter and setter methods, either
+// name may be empty.
+type propInfo struct {
+	get string
+	set string
+}
+
+// errorType is the reflected type of the error interface.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// newTypeInfo builds the Lua view of the struct type t registered as name.
+func newTypeInfo(name string, t reflect.Type, opts TypeOptions) *typeInfo {
+	info := &typeInfo{
+		name:    name,
+		typ:     t,
+		fields:  make(map[string]*fieldInfo),
+		methods: make(map[string]string),
+		props:   make(map[string]*propInfo),
+	}
+	info.addFields(t, nil, opts)
+
+	var allowed map[string]bool
+	if opts.Methods != nil {
+		allowed = make(map[string]bool, len(opts.Methods))
+		for _, m := range opts.Methods {
+			allowed[m] = true
+		}
+	}
+	ptr := reflect.PtrTo(t)
+	for i := 0; i < ptr.NumMethod(); i++ {
+		m := ptr.Method(i)
+		if allowed != nil && !allowed[m.Name] {
+			continue
+		}
+		names := luaNames(m.Name, opts)
+		info.members = append(info.members, names[len(names)-1])
+		for _, name := range names {
+			info.methods[name] = m.Name
+		}
+		if opts.Properties {
+			info.addProperty(m, opts)
+		}
+	}
+
+	return info
+}
+
+// addProperty adds m to the property it's the getter or setter of, if any.
+func (info *typeInfo) addProperty(m reflect.Method, opts TypeOptions) {
+	if len(m.Name) <= 3 {
+		return
+	}
+	t := m.Type
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	var getter bool
+	switch {
+	case strings.HasPrefix(m.Name, "Get") && t.NumIn() == 1 &&
+		(t.NumOut() == 1 && !returnsError || t.NumOut() == 2 && returnsError):
+		getter = true
+	case strings.HasPrefix(m.Name, "Set") && t.NumIn() == 2 &&
+		(t.NumOut() == 0 || t.NumOut() == 1 && returnsError):
+	default:
+		return
+	}
+
+	names := luaNames(m.Name[3:], opts)
+	if _, ok := info.props[names[len(names)-1]]; !ok {
+		info.members = append(info.members, names[len(names)-1])
+	}
+	for _, name := range names {
+		prop, ok := info.props[name]
+		if !ok {
+			prop = &propInfo{}
+			info.props[name] = prop
+		}
+		if getter {
+			prop.get = m.Name
+		} else {
+			prop.set = m.Name
+		}
+	}
+}
+
+// addFields adds the exported fields of t, found at index, including the ones
+// promoted from embedded structs. Fields of the outer struct win over promoted
+// ones.
+func (info *typeInfo) addFields(t reflect.Type, index []int, opts TypeOptions) {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			f.Index = append(append([]int{}, index...), i)
+			embedded = append(embedded, f)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := strings.Split(f.Tag.Get("lua"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		field := &fieldInfo{index: append(append([]int{}, index...), i)}
+		for _, flag := range tag[1:] {
+			if flag == "readonly" {
+				field.readonly = true
+			}
+		}
+		names := luaNames(f.Name, opts)
+		if tag[0] != "" {
+			names = []string{tag[0]}
+		}
+		if _, ok := info.fields[names[len(names)-1]]; !ok {
+			info.members = append(info.members, names[len(names)-1])
+		}
+		for _, name := range names {
+			if _, ok := info.fields[name]; !ok {
+				info.fields[name] = field
+			}
+		}
+	}
+
+	for _, f := range embedded {
+		info.addFields(f.Type, f.Index, opts)
+	}
+}
+
+// luaNames returns the names a Go field or method is known by in Lua. Without
+// snake case both the Go name and the name starting in lower case are accepted,
+// as gopher-luar does.
+func luaNames(name string, opts TypeOptions) []string {
+	if opts.SnakeCase {
+		return []string{snakeCase(name)}
+	}
+	lower := []rune(name)
+	lower[0] = unicode.ToLower(lower[0])
+
+	return []string{name, string(lower)}
+}
+
+// snakeCase converts a Go name like MaxHealth or HTTPServer to max_health and
+// http_server.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// a new word starts at an upper case letter following a lower case
+			// one, or ending a run of upper case letters
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				out = append(out, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out = append(out, r)
+	}
+
+	return string(out)
+}
+
+// registerType records the options for the type of val and returns the
+// constructor gopher-luar creates for it, along with the registration for
+// struct types.
+func (e *Engine) registerType(name string, val interface{}, opts []TypeOptions) (glua.LValue, *typeInfo) {
+	cons := luar.NewType(e.state, val)
+
+	t := reflect.TypeOf(val)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return cons, nil
+	}
+
+	var o TypeOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	info := newTypeInfo(name, t, o)
+	e.root().types[t] = info
+	e.hookTypes()
+
+	return cons, info
+}
+
+// hookTypes replaces the metamethods gopher-luar shares between all structs
+// and pointers with ones that apply the options, classes and interfaces of
+// registered types. Values of other types are still handled by gopher-luar.
+func (e *Engine) hookTypes() {
+	e = e.root()
+	if e.typesHooked {
+		return
+	}
+	e.typesHooked = true
+
+	wrappers := map[string]func(glua.LGFunction) glua.LGFunction{
+		"__index":    e.typeIndex,
+		"__newindex": e.typeNewIndex,
+		"__tostring": e.typeToString,
+		"__eq":       e.typeEq,
+		"__lt":       e.typeLt,
+		"__le":       e.typeLe,
+		"__unm":      e.typeUnm,
+		"__len":      e.typeLen,
+	}
+	for event, op := range binaryOps {
+		wrappers[event] = e.typeBinaryOp(event, op)
+	}
+	metatables := e.state.G.Registry.RawGetH(glua.LString(luarMetatableKey)).(*glua.LTable)
+	for _, kind := range []string{"ptr", "struct"} {
+		mt := metatables.RawGetH(glua.LString(kind)).(*glua.LTable)
+		for event, wrap := range wrappers {
+			var fallback glua.LGFunction
+			if fn, ok := mt.RawGetH(glua.LString(event)).(*glua.LFunction); ok {
+				fallback = fn.GFunction
+			}
+			mt.RawSetH(glua.LString(event), e.state.NewFunction(wrap(fallback)))
+		}
+	}
+}
+
+// typeOf returns the registration of the struct held by, or pointed to by, the
+// userdata at idx along with the reflected value.
+func (e *Engine) typeOf(l *glua.LState, idx int) (*typeInfo, reflect.Value) {
+	ud := l.CheckUserData(idx)
+	ref := reflect.ValueOf(ud.Value)
+	t := ref.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return e.root().types[t], ref
+}
+
+// typeIndex returns the __index metamethod for registered types, fallback
+// handles values of other types.
+func (e *Engine) typeIndex(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		info, ref := e.typeOf(l, 1)
+		if info == nil {
+			return fallback(l)
+		}
+		key, ok := l.Get(2).(glua.LString)
+		if !ok {
+			return 0
+		}
+
+		// members defined in Lua classes override the ones from Go
+		if lv := e.classMember(l.ToUserData(1), string(key)); lv != glua.LNil {
+			l.Push(lv)
+
+			return 1
+		}
+		if lv := info.member(l, ref, string(key)); lv != glua.LNil {
+			if name, ok := info.methods[string(key)]; ok {
+				m, _ := ref.Type().MethodByName(name)
+				lv = e.enumArgs(m.Type, lv)
+			}
+			l.Push(lv)
+
+			return 1
+		}
+
+		return 0
+	}
+}
+
+// member returns the method, property or field of ref exposed to Lua as key.
+func (info *typeInfo) member(l *glua.LState, ref reflect.Value, key string) glua.LValue {
+	if name, ok := info.methods[key]; ok {
+		if m, ok := ref.Type().MethodByName(name); ok {
+			return luar.New(l, m.Func.Interface())
+		}
+	}
+	if prop, ok := info.props[key]; ok && prop.get != "" {
+		if m := ref.MethodByName(prop.get); m.IsValid() {
+			out := m.Call(nil)
+			if len(out) == 2 && !out[1].IsNil() {
+				l.RaiseError("%s", out[1].Interface().(error).Error())
+			}
+
+			return luar.New(l, out[0].Interface())
+		}
+	}
+	if f, ok := info.fields[key]; ok {
+		if lv := luar.New(l, reflect.Indirect(ref).FieldByIndex(f.index).Interface()); lv != nil {
+			return lv
+		}
+	}
+
+	return glua.LNil
+}
+
+// typeNewIndex returns the __newindex metamethod for registered types,
+// fallback handles values of other types.
+func (e *Engine) typeNewIndex(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		info, ref := e.typeOf(l, 1)
+		if info == nil {
+			return fallback(l)
+		}
+		key := l.CheckString(2)
+
+		if prop, ok := info.props[key]; ok {
+			if m := ref.MethodByName(prop.set); prop.set != "" && m.IsValid() {
+				e.enumValue(l, 3, m.Type().In(0))
+			}
+			info.setProperty(l, ref, key, prop)
+
+			return 0
+		}
+		f, ok := info.fields[key]
+		if !ok {
+			if e.setClassField(l.ToUserData(1), key, l.Get(3)) {
+				return 0
+			}
+			l.RaiseError("%s has no field %q", info.name, key)
+		}
+		if f.readonly {
+			l.RaiseError("field %q of %s is read-only", key, info.name)
+		}
+		if ref.Kind() != reflect.Ptr {
+			l.RaiseError("cannot set field %q of a %s value", key, info.name)
+		}
+		field := ref.Elem().FieldByIndex(f.index)
+		e.enumValue(l, 3, field.Type())
+		val, err := reflectValue(l.Get(3), field.Type())
+		if err != nil {
+			l.ArgError(3, err.Error())
+		}
+		field.Set(val)
+
+		return 0
+	}
+}
+
+// setProperty calls the setter of prop with the value being assigned to the
+// property key of ref, raising the error the setter returns.
+func (info *typeInfo) setProperty(l *glua.LState, ref reflect.Value, key string, prop *propInfo) {
+	if prop.set == "" {
+		l.RaiseError("property %q of %s is read-only", key, info.name)
+	}
+	m := ref.MethodByName(prop.set)
+	if !m.IsValid() {
+		l.RaiseError("cannot set property %q of a %s value", key, info.name)
+	}
+	val, err := reflectValue(l.Get(3), m.Type().In(0))
+	if err != nil {
+		l.ArgError(3, err.Error())
+	}
+	out := m.Call([]reflect.Value{val})
+	if len(out) == 1 && !out[0].IsNil() {
+		l.RaiseError("%s", out[0].Interface().(error).Error())
+	}
+}
+
+// typeToString returns the __tostring metamethod for registered types, a
+// __tostring method defined by the Lua class is used before the String method
+// gopher-luar looks for.
+func (e *Engine) typeToString(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		if info, _ := e.typeOf(l, 1); info != nil {
+			if fn := e.classMember(l.ToUserData(1), "__tostring"); fn != glua.LNil {
+				l.Push(fn)
+				l.Push(l.Get(1))
+				l.Call(1, 1)
+
+				return 1
+			}
+		}
+
+		return fallback(l)
+	}
+}
+
+// typeEq returns the __eq metamethod for registered types, it uses an __eq
+// method of the Lua class or the LuaComparer interface. Values LuaCompare fails
+// to compare aren't equal.
+func (e *Engine) typeEq(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		if info, ref := e.typeOf(l, 1); info != nil {
+			if fn := e.classMember(l.ToUserData(1), "__eq"); fn != glua.LNil {
+				return callMeta(l, fn)
+			}
+			if cmp, ok := ref.Interface().(LuaComparer); ok {
+				c, err := cmp.LuaCompare(l.CheckUserData(2).Value)
+				l.Push(glua.LBool(err == nil && c == 0))
+
+				return 1
+			}
+		}
+		if fallback != nil {
+			return fallback(l)
+		}
+		l.Push(glua.LFalse)
+
+		return 1
+	}
+}
+
+// typeLt returns the __lt metamethod for registered types, it uses an __lt
+// method of the Lua class or the LuaComparer interface.
+func (e *Engine) typeLt(fallback glua.LGFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		if info, ref := e.typeOf(l, 1); info != nil {
+			if fn := e.classMember(l.ToUserData(1), "__lt"); fn != glua.LNil {
+				return callMeta(l, fn)
+			}
+			if cmp, ok := ref.Interface().(LuaComparer); ok {
+				l.Push(glua.LBool(compare(l, cmp) < 0))
+
+				return 1
+			}
+		}
+		if fallback != nil {
+			return fallback(l)
+		}
+		l.RaiseError("attempt to compare two userdata values")
+
+		return 0
+	}
+}
+
+// callMeta calls the Lua implementation of a binary metamethod with the
+// operands of the metamethod being run.
+func callMeta(l *glua.LState, fn glua.LValue) int {
+	l.Push(fn)
+	l.Push(l.Get(1))
+	l.Push(l.Get(2))
+	l.Call(2, 1)
+
+	return 1
+}
+
+// lvalueType is the reflected type of the glua.LValue interface.
+var lvalueType = reflect.TypeOf((*glua.LValue)(nil)).Elem()
+
+// reflectValue converts a Lua value to a Go value of type t.
+func reflectValue(lv glua.LValue, t reflect.Type) (reflect.Value, error) {
+	if t == lvalueType {
+		return reflect.ValueOf(&lv).Elem(), nil
+	}
+	if t == reflect.TypeOf(&Value{}) {
+		return reflect.ValueOf(newValue(lv)), nil
+	}
+
+	var val reflect.Value
+	switch v := lv.(type) {
+	case *glua.LNilType:
+		return reflect.Zero(t), nil
+	case glua.LBool:
+		val = reflect.ValueOf(bool(v))
+	case glua.LNumber:
+		val = reflect.ValueOf(float64(v))
+	case glua.LString:
+		val = reflect.ValueOf(string(v))
+	case *glua.LUserData:
+		val = reflect.ValueOf(v.Value)
+	default:
+		val = reflect.ValueOf(lv)
+	}
+
+	switch {
+	case val.Type().AssignableTo(t):
+		return val, nil
+	case val.Kind() == reflect.Float64 && isNumberKind(t.Kind()):
+		return val.Convert(t), nil
+	case val.Kind() == reflect.String && t.Kind() == reflect.String:
+		return val.Convert(t), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", lv.Type(), t)
+}
+
+// isNumberKind returns true for the kinds Lua numbers convert to.
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,206 @@
+package lua_test
+
+import (
+	"errors"
+
+	. "github.com/seer-server/script-engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type Stats struct {
+	Level int
+}
+
+type Player struct {
+	Stats
+	Name      string
+	Gold      int    `lua:",readonly"`
+	Password  string `lua:"-"`
+	MaxHealth int    `lua:"max_hp"`
+	saved     bool
+}
+
+func (p *Player) AddGold(n int) {
+	p.Gold += n
+}
+
+func (p *Player) Save() {
+	p.saved = true
+}
+
+type Creature struct {
+	Name   string
+	health int
+}
+
+func (c *Creature) GetHealth() int {
+	return c.health
+}
+
+func (c *Creature) SetHealth(health int) error {
+	if health < 0 || health > 100 {
+		return errors.New("health must be between 0 and 100")
+	}
+	c.health = health
+
+	return nil
+}
+
+func (c *Creature) GetAlive() bool {
+	return c.health > 0
+}
+
+var _ = Describe("RegisterType", func() {
+	var (
+		engine *Engine
+		player *Player
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		player = &Player{Name: "bob", Gold: 10, Password: "hunter2", MaxHealth: 50}
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	Context("with struct tags", func() {
+		BeforeEach(func() {
+			engine.RegisterType("Player", Player{}, TypeOptions{Methods: []string{"AddGold"}})
+			engine.SetGlobal("player", player)
+		})
+
+		It("should expose fields by their Go and tag names", func() {
+			Expect(engine.LoadString(`
+				name, gold, hp, level = player.name, player.Gold, player.max_hp, player.level
+				player.name = "alice"
+				player.max_hp = 60
+			`)).To(BeNil())
+			Expect(engine.GetGlobal("name").AsString()).To(Equal("bob"))
+			Expect(engine.GetGlobal("gold").AsNumber()).To(Equal(10.0))
+			Expect(engine.GetGlobal("hp").AsNumber()).To(Equal(50.0))
+			Expect(engine.GetGlobal("level").AsNumber()).To(Equal(0.0))
+			Expect(player.Name).To(Equal("alice"))
+			Expect(player.MaxHealth).To(Equal(60))
+		})
+
+		It("should not allow setting read-only fields", func() {
+			err := engine.LoadString(`player.gold = 1000`)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring(`field "gold" of Player is read-only`))
+			Expect(player.Gold).To(Equal(10))
+		})
+
+		It("should hide ignored fields", func() {
+			Expect(engine.LoadString(`password = player.password`)).To(BeNil())
+			Expect(engine.GetGlobal("password").IsNil()).To(BeTrue())
+			Expect(engine.LoadString(`player.Password = "x"`)).ToNot(BeNil())
+			Expect(engine.LoadString(`player.MaxHealth = 1`)).ToNot(BeNil())
+			Expect(player.Password).To(Equal("hunter2"))
+		})
+
+		It("should encode the fields Lua sees as JSON", func() {
+			Expect(engine.LoadString(`out, err = json.encode({player = player})`)).To(BeNil())
+			Expect(engine.GetGlobal("err").IsNil()).To(BeTrue())
+			Expect(engine.GetGlobal("out").AsString()).To(Equal(
+				`{"player":{"gold":10,"level":0,"max_hp":50,"name":"bob"}}`))
+
+			engine.SetGlobal("creature", &Creature{Name: "orc"})
+			Expect(engine.LoadString(`out, err = json.encode({creature})`)).To(BeNil())
+			Expect(engine.GetGlobal("out").IsNil()).To(BeTrue())
+			Expect(engine.GetGlobal("err").AsString()).To(Equal(
+				"json: cannot encode unregistered type lua_test.Creature at [1]"))
+		})
+
+		It("should only expose allowed methods", func() {
+			Expect(engine.LoadString(`player:addGold(5)`)).To(BeNil())
+			Expect(player.Gold).To(Equal(15))
+			Expect(engine.LoadString(`player:save()`)).ToNot(BeNil())
+			Expect(player.saved).To(BeFalse())
+		})
+
+		It("should apply to values created from Lua", func() {
+			err := engine.LoadString(`local p = Player() p.gold = 5`)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("read-only"))
+		})
+	})
+
+	Context("with snake case names", func() {
+		BeforeEach(func() {
+			engine.RegisterClass("Player", Player{}, TypeOptions{SnakeCase: true})
+			engine.SetGlobal("player", player)
+		})
+
+		It("should map fields and methods to snake_case", func() {
+			Expect(engine.LoadString(`
+				player:add_gold(1)
+				player.name = "carol"
+				hp = player.max_hp
+				missing = player.AddGold
+			`)).To(BeNil())
+			Expect(player.Gold).To(Equal(11))
+			Expect(player.Name).To(Equal("carol"))
+			Expect(engine.GetGlobal("hp").AsNumber()).To(Equal(50.0))
+			Expect(engine.GetGlobal("missing").IsNil()).To(BeTrue())
+		})
+	})
+
+	Context("with properties", func() {
+		var creature *Creature
+
+		BeforeEach(func() {
+			creature = &Creature{Name: "rat", health: 10}
+			engine.RegisterType("Creature", Creature{}, TypeOptions{Properties: true})
+			engine.SetGlobal("creature", creature)
+		})
+
+		It("should call getters and setters", func() {
+			Expect(engine.LoadString(`
+				before = creature.health
+				creature.health = creature.health + 5
+				alive = creature.alive
+			`)).To(BeNil())
+			Expect(engine.GetGlobal("before").AsNumber()).To(Equal(10.0))
+			Expect(engine.GetGlobal("alive").AsBool()).To(BeTrue())
+			Expect(creature.GetHealth()).To(Equal(15))
+		})
+
+		It("should raise errors returned by setters", func() {
+			err := engine.LoadString(`creature.health = 500`)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("health must be between 0 and 100"))
+			Expect(creature.GetHealth()).To(Equal(10))
+		})
+
+		It("should not allow setting properties without setters", func() {
+			err := engine.LoadString(`creature.alive = false`)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring(`property "alive" of Creature is read-only`))
+		})
+	})
+	It("should register types and classes from ScriptFunctions", func() {
+		engine.RegisterFunc("setup", func(se *Engine) int {
+			se.RegisterType("Player", Player{}, TypeOptions{Methods: []string{"AddGold"}})
+			se.RegisterClass("Creature", Creature{}, TypeOptions{Properties: true})
+
+			return 0
+		})
+		engine.SetGlobal("player", player)
+		Expect(engine.LoadString(`
+			setup()
+			hp, hidden = player.max_hp, player.Password
+			Rat = Creature:extend()
+			rat = Rat.new()
+			rat.health = 10
+			health, is_rat = rat.health, Creature:isinstance(rat)
+		`)).To(BeNil())
+		Expect(engine.GetGlobal("hp").AsNumber()).To(Equal(50.0))
+		Expect(engine.GetGlobal("hidden").IsNil()).To(BeTrue())
+		Expect(engine.GetGlobal("health").AsNumber()).To(Equal(10.0))
+		Expect(engine.GetGlobal("is_rat").AsBool()).To(BeTrue())
+	})
+})
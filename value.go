@@ -23,6 +23,12 @@ func (v ValueError) Error() string {
 
 // Value is a utility wrapper for lua.LValue that provies conveinient methods
 // for casting.
+//
+// A Value holding a table, function, userdata, thread or channel belongs to
+// the Engine it came from. The methods of Value taking other Values, and the
+// SetGlobal and SetField methods of Engine, can't return errors so they panic
+// with ErrForeignValue when given a Value of another Engine, while Call
+// returns it. Import copies Values between Engines.
 type Value struct {
 	lval  glua.LValue
 	owner *Engine
@@ -146,7 +152,7 @@ func (v *Value) Append(value interface{}) {
 func (v *Value) ForEach(cb func(*Value, *Value)) {
 	if v.isTable() {
 		actualCb := func(key glua.LValue, val glua.LValue) {
-			cb(newValue(key), newValue(val))
+			cb(&Value{lval: key, owner: v.owner}, &Value{lval: val, owner: v.owner})
 		}
 		t := v.asTable()
 		t.ForEach(actualCb)
@@ -193,7 +199,7 @@ func (v *Value) Next(key interface{}) (*Value, *Value) {
 		t := v.asTable()
 		v1, v2 := t.Next(val)
 
-		return newValue(v1), newValue(v2)
+		return &Value{lval: v1, owner: v.owner}, &Value{lval: v2, owner: v.owner}
 	}
 
 	return Nil, Nil
@@ -205,16 +211,19 @@ func (v *Value) Remove(pos int) *Value {
 		t := v.asTable()
 		ret := t.Remove(pos)
 
-		return newValue(ret)
+		return &Value{lval: ret, owner: v.owner}
 	}
 
 	return Nil
 }
 
-// Helper method for Set and RawSet
+// Helper method for Set and RawSet, it panics with ErrForeignValue if item is
+// a Value of an Engine other than e.
 func getLValue(e *Engine, item interface{}) glua.LValue {
 	switch val := item.(type) {
 	case (*Value):
+		e.checkOwner("Value.Set", val)
+
 		return val.lval
 	case glua.LValue:
 		return val
@@ -228,7 +237,8 @@ func getLValue(e *Engine, item interface{}) glua.LValue {
 }
 
 // Set sets the value of a given key on the table, this method checks for
-// validity of array keys and handles them accordingly.
+// validity of array keys and handles them accordingly. It panics with
+// ErrForeignValue if the key or value is a Value of another Engine.
 func (v *Value) Set(goKey interface{}, val interface{}) {
 	if v.isTable() {
 		key := getLValue(v.owner, goKey)